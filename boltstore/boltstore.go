@@ -0,0 +1,335 @@
+// Package boltstore provides an implementation of
+// ratelimit.Store which persists the state of the
+// token buckets in an embedded bbolt database, so
+// that limits survive restarts of the process.
+//
+// Consumptions are written using bbolt's batching,
+// which coalesces concurrent writes into a single
+// transaction. A reservation is only returned after
+// its transaction has been committed, so the state
+// on disk is always consistent. The latency of a
+// single write can be tuned using the MaxBatchSize
+// and MaxBatchDelay fields of the database.
+//
+// Buckets which are completely refilled are equal
+// to new buckets, so they are removed by Compact.
+package boltstore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+	"go.etcd.io/bbolt"
+)
+
+// DefaultBucket is the name of the bbolt
+// bucket in which the states are stored.
+var DefaultBucket = []byte("ratelimit")
+
+// recordSize is the size of an encoded record.
+const recordSize = 24
+
+// ErrInvalidRecord is returned when a stored
+// record could not be decoded.
+var ErrInvalidRecord = errors.New("invalid record")
+
+// record contains the state of a single token
+// bucket. All times are stored as UnixNano, where
+// 0 is used for time.Time{}.
+type record struct {
+	tokens  int64
+	last    int64
+	expires int64
+}
+
+func (r record) encode() []byte {
+	v := make([]byte, recordSize)
+	binary.BigEndian.PutUint64(v[0:], uint64(r.tokens))
+	binary.BigEndian.PutUint64(v[8:], uint64(r.last))
+	binary.BigEndian.PutUint64(v[16:], uint64(r.expires))
+	return v
+}
+
+func decodeRecord(v []byte) (record, error) {
+	if len(v) != recordSize {
+		return record{}, ErrInvalidRecord
+	}
+
+	return record{
+		tokens:  int64(binary.BigEndian.Uint64(v[0:])),
+		last:    int64(binary.BigEndian.Uint64(v[8:])),
+		expires: int64(binary.BigEndian.Uint64(v[16:])),
+	}, nil
+}
+
+func (r record) lastTime() time.Time {
+	if r.last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, r.last)
+}
+
+// available returns the amount of tokens at the
+// time now including the virtually generated
+// tokens since the last consumption.
+func (r record) available(now time.Time, l time.Duration, b int) int {
+	t := int(r.tokens) + int(now.Sub(r.lastTime())/l)
+	if t > b {
+		return b
+	}
+	return t
+}
+
+// Store implements ratelimit.Store using bbolt.
+type Store struct {
+	db     *bbolt.DB
+	ownsDB bool
+	now    ratelimit.TimeSource
+	bucket []byte
+
+	stop      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+var _ ratelimit.Returner = (*Store)(nil)
+
+// NewWithTimeSource returns a new instance of Store
+// using the given TimeSource and database. The bucket
+// DefaultBucket is created if it does not exist.
+func NewWithTimeSource(timeSource ratelimit.TimeSource, db *bbolt.DB) (*Store, error) {
+	s := &Store{
+		db:     db,
+		now:    timeSource,
+		bucket: DefaultBucket,
+		stop:   make(chan struct{}),
+	}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(s.bucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// New returns a new instance of Store
+// using the given database.
+func New(db *bbolt.DB) (*Store, error) {
+	return NewWithTimeSource(time.Now, db)
+}
+
+// Open opens or creates the database at path and
+// returns a new instance of Store using it. The
+// database is closed when the Store is closed.
+func Open(path string) (*Store, error) {
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := New(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s.ownsDB = true
+
+	return s, nil
+}
+
+// TakeN implements ratelimit.Store#TakeN.
+func (s *Store) TakeN(_ context.Context, key string, n int, l time.Duration, b int) (bool, ratelimit.Reservation, error) {
+	if n <= 0 {
+		return true, ratelimit.Reservation{}, nil
+	}
+
+	if b <= 0 || l <= 0 {
+		return false, ratelimit.Reservation{}, nil
+	}
+
+	var (
+		ok  bool
+		res ratelimit.Reservation
+	)
+
+	// The function passed to Batch may be executed
+	// multiple times, so it must only assign the
+	// results and not accumulate them.
+	err := s.db.Batch(func(tx *bbolt.Tx) error {
+		bk := tx.Bucket(s.bucket)
+
+		r := record{tokens: int64(b)}
+		if v := bk.Get([]byte(key)); v != nil {
+			var err error
+			if r, err = decodeRecord(v); err != nil {
+				return err
+			}
+		}
+
+		now := s.now()
+		tokens := r.available(now, l, b)
+
+		if tokens < n {
+			ok = false
+			res = ratelimit.NewReservation(b, int(r.tokens), r.lastTime().Add(l))
+			return nil
+		}
+
+		r.tokens = int64(tokens - n)
+		r.last = now.UnixNano()
+		r.expires = now.Add(l * time.Duration(b)).UnixNano()
+
+		ok = true
+		var reset time.Time
+		if r.tokens == 0 {
+			reset = now.Add(l)
+		}
+		res = ratelimit.NewReservation(b, int(r.tokens), reset)
+
+		return bk.Put([]byte(key), r.encode())
+	})
+	if err != nil {
+		return false, ratelimit.Reservation{}, err
+	}
+
+	return ok, res, nil
+}
+
+// Tokens implements ratelimit.Store#Tokens.
+func (s *Store) Tokens(_ context.Context, key string, l time.Duration, b int) (int, error) {
+	tokens := b
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(s.bucket).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+
+		r, err := decodeRecord(v)
+		if err != nil {
+			return err
+		}
+
+		tokens = r.available(s.now(), l, b)
+		return nil
+	})
+
+	return tokens, err
+}
+
+// Reset implements ratelimit.Store#Reset.
+func (s *Store) Reset(_ context.Context, key string) error {
+	return s.db.Batch(func(tx *bbolt.Tx) error {
+		return tx.Bucket(s.bucket).Delete([]byte(key))
+	})
+}
+
+// ReturnN implements ratelimit.Returner#ReturnN.
+func (s *Store) ReturnN(_ context.Context, key string, n int, _ time.Duration, b int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	return s.db.Batch(func(tx *bbolt.Tx) error {
+		bk := tx.Bucket(s.bucket)
+
+		v := bk.Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+
+		r, err := decodeRecord(v)
+		if err != nil {
+			return err
+		}
+
+		r.tokens += int64(n)
+		if r.tokens > int64(b) {
+			r.tokens = int64(b)
+		}
+
+		return bk.Put([]byte(key), r.encode())
+	})
+}
+
+// Compact removes all buckets which are completely
+// refilled and returns the amount of removed buckets.
+func (s *Store) Compact() (int, error) {
+	var removed int
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		removed = 0
+		now := s.now().UnixNano()
+		c := tx.Bucket(s.bucket).Cursor()
+
+		for k, v := c.First(); k != nil; {
+			r, err := decodeRecord(v)
+			if err != nil || r.expires <= now {
+				if err = c.Delete(); err != nil {
+					return err
+				}
+				removed++
+				// After Delete, the cursor must be
+				// repositioned to the next key.
+				k, v = c.Seek(k)
+				continue
+			}
+			k, v = c.Next()
+		}
+
+		return nil
+	})
+
+	return removed, err
+}
+
+// StartCompaction runs Compact every interval in
+// the background until the Store is closed. Errors
+// of the compaction are passed to onError, if set.
+func (s *Store) StartCompaction(interval time.Duration, onError func(error)) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-t.C:
+				if _, err := s.Compact(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+// Close stops the background compaction and,
+// if the Store was created using Open, closes
+// the database.
+func (s *Store) Close() error {
+	var err error
+
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		s.wg.Wait()
+
+		if s.ownsDB {
+			err = s.db.Close()
+		}
+	})
+
+	return err
+}
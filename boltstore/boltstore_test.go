@@ -0,0 +1,158 @@
+package boltstore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+	"github.com/zekroTJA/ratelimit/storetest"
+	"go.etcd.io/bbolt"
+)
+
+func openDB(t *testing.T, path string) *bbolt.DB {
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.MaxBatchDelay = time.Millisecond
+	return db
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, ts ratelimit.TimeSource) ratelimit.Store {
+		db := openDB(t, filepath.Join(t.TempDir(), "test.db"))
+		t.Cleanup(func() { db.Close() })
+
+		s, err := NewWithTimeSource(ts, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestStorePersistence(t *testing.T) {
+	const limit = time.Hour
+	const burst = 3
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = s.TakeN(ctx, "a", 2, limit, burst); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tokens, err := s.Tokens(ctx, "a", limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != burst-2 {
+		t.Errorf("tokens should be %d after reopening but was %d", burst-2, tokens)
+	}
+}
+
+func TestStoreCompact(t *testing.T) {
+	const limit = 100 * time.Millisecond
+	const burst = 2
+
+	ctx := context.Background()
+	c := storetest.NewClock(time.Now())
+	db := openDB(t, filepath.Join(t.TempDir(), "test.db"))
+	defer db.Close()
+
+	s, err := NewWithTimeSource(c.Now, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, _, err = s.TakeN(ctx, key, 1, limit, burst); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Advance(limit)
+
+	if _, _, err = s.TakeN(ctx, "c", 2, limit, burst); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Advance(limit)
+
+	removed, err := s.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("removed should be %d but was %d", 2, removed)
+	}
+
+	tokens, err := s.Tokens(ctx, "c", limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != 1 {
+		t.Errorf("tokens of remaining key should be %d but was %d", 1, tokens)
+	}
+
+	c.Advance(limit)
+
+	if removed, _ = s.Compact(); removed != 1 {
+		t.Errorf("removed should be %d but was %d", 1, removed)
+	}
+}
+
+func TestStoreStartCompaction(t *testing.T) {
+	const limit = time.Millisecond
+	const burst = 1
+
+	ctx := context.Background()
+	db := openDB(t, filepath.Join(t.TempDir(), "test.db"))
+	defer db.Close()
+
+	s, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = s.TakeN(ctx, "a", 1, limit, burst); err != nil {
+		t.Fatal(err)
+	}
+
+	s.StartCompaction(5*time.Millisecond, func(err error) { t.Error(err) })
+	time.Sleep(50 * time.Millisecond)
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		if n := tx.Bucket(DefaultBucket).Stats().KeyN; n != 0 {
+			t.Errorf("bucket should be empty but contained %d keys", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
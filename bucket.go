@@ -0,0 +1,144 @@
+package ratelimit
+
+import "time"
+
+// bucket contains the state of a single
+// token bucket. It does not hold any
+// configuration nor synchronization, so
+// the owner of a bucket must ensure that
+// access to it is mutually exclusive.
+type bucket struct {
+	tokens int
+	last   time.Time
+}
+
+// newBucket returns a full bucket for
+// the burst rate b.
+func newBucket(b int) bucket {
+	return bucket{tokens: b}
+}
+
+// available returns the amount of tokens
+// in the bucket at the time now including
+// the tokens which were virtually generated
+// since the last consumption. The last
+// consumption may lie in the future when the
+// bucket has been paused using set.
+func (bk *bucket) available(now time.Time, l time.Duration, b int) int {
+	t := bk.tokens
+	if elapsed := now.Sub(bk.last); elapsed > 0 {
+		t += int(elapsed / l)
+	}
+	if t > b {
+		return b
+	}
+
+	return t
+}
+
+// takeN tries to consume n tokens from the
+// bucket at the time now using the limit l
+// and the burst rate b. The semantics of the
+// returned values are equal to those of
+// Limiter#ReserveN.
+func (bk *bucket) takeN(now time.Time, n int, l time.Duration, b int) (bool, Reservation) {
+	if n <= 0 {
+		return true, Reservation{}
+	}
+
+	if b <= 0 || l <= 0 {
+		return false, Reservation{}
+	}
+
+	res := Reservation{
+		Burst: b,
+		Reset: ResetTime{
+			isNil: true,
+		},
+	}
+
+	tokens := bk.available(now, l, b)
+
+	if tokens >= n {
+		bk.tokens = tokens - n
+		bk.last = now
+
+		res.Remaining = bk.tokens
+
+		if bk.tokens == 0 {
+			res.Reset.Time = bk.last.Add(l)
+			res.Reset.isNil = false
+		}
+
+		return true, res
+	}
+
+	res.Remaining = bk.tokens
+	res.Reset.Time = bk.last.Add(l)
+	res.Reset.isNil = false
+
+	return false, res
+}
+
+// returnN adds n previously taken tokens
+// back to the bucket, capped at the burst
+// rate b.
+func (bk *bucket) returnN(n int, b int) {
+	if n <= 0 {
+		return
+	}
+
+	bk.tokens += n
+	if bk.tokens > b {
+		bk.tokens = b
+	}
+}
+
+// wait returns the duration from now until n
+// tokens are available in the bucket. It returns
+// 0 if the tokens are available at now.
+func (bk *bucket) wait(now time.Time, n int, l time.Duration, b int) time.Duration {
+	if bk.available(now, l, b) >= n {
+		return 0
+	}
+
+	return bk.last.Add(time.Duration(n-bk.tokens) * l).Sub(now)
+}
+
+// lower lowers the amount of tokens in the bucket
+// at now to n if it holds more. If next is not zero
+// and later than the time the next token would be
+// generated, the next token is generated at next.
+// The bucket is never refilled by lower.
+func (bk *bucket) lower(now time.Time, n int, next time.Time, l time.Duration, b int) {
+	tokens := bk.available(now, l, b)
+	last := bk.last
+	if elapsed := now.Sub(last); elapsed > 0 && l > 0 {
+		if tokens >= b {
+			last = now
+		} else {
+			last = last.Add(elapsed / l * l)
+		}
+	}
+
+	if n < 0 {
+		n = 0
+	}
+	if n < tokens {
+		tokens = n
+	}
+	if !next.IsZero() && next.Add(-l).After(last) {
+		last = next.Add(-l)
+	}
+
+	bk.tokens = tokens
+	bk.last = last
+}
+
+// expired returns true when the bucket would
+// be completely refilled at the time now, so
+// it is equal to a freshly created bucket and
+// its state can safely be discarded.
+func (bk *bucket) expired(now time.Time, l time.Duration, b int) bool {
+	return l <= 0 || b <= 0 || bk.available(now, l, b) >= b
+}
@@ -0,0 +1,69 @@
+// Package chanlimit provides generic helpers to
+// limit the rate of items flowing through channel
+// based pipelines.
+package chanlimit
+
+import "context"
+
+// Waiter is implemented by limiters which
+// are able to block until n tokens are
+// available, like ratelimit.Limiter.
+type Waiter interface {
+	WaitN(ctx context.Context, n int) error
+}
+
+// Throttle returns a channel which receives the
+// items of in at the rate of the limiter l. The
+// returned channel is closed when in is closed,
+// ctx is done or the limiter fails. In the latter
+// cases, the remaining items of in are not
+// consumed.
+func Throttle[T any](ctx context.Context, in <-chan T, l Waiter) <-chan T {
+	return ThrottleCost(ctx, in, l, nil)
+}
+
+// ThrottleCost behaves like Throttle, but each item
+// takes cost(item) tokens from the limiter. If cost
+// is nil, each item takes one token. Items with a
+// cost <= 0 are passed on without waiting.
+func ThrottleCost[T any](ctx context.Context, in <-chan T, l Waiter, cost func(T) int) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+
+		for {
+			var (
+				item T
+				ok   bool
+			)
+			select {
+			case item, ok = <-in:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+
+			if err := l.WaitN(ctx, costOf(cost, item)); err != nil {
+				return
+			}
+
+			select {
+			case out <- item:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+func costOf[T any](cost func(T) int, item T) int {
+	if cost == nil {
+		return 1
+	}
+	return cost(item)
+}
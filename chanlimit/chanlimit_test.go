@@ -0,0 +1,81 @@
+package chanlimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+func source(n int) <-chan int {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 0; i < n; i++ {
+			ch <- i
+		}
+	}()
+	return ch
+}
+
+func TestThrottle(t *testing.T) {
+	const limit = 10 * time.Millisecond
+	const burst = 2
+
+	start := time.Now()
+	out := Throttle(context.Background(), source(5), ratelimit.NewLimiter(limit, burst))
+
+	i := 0
+	for v := range out {
+		if v != i {
+			t.Errorf("item %d should be %d but was %d", i, i, v)
+		}
+		i++
+	}
+
+	if i != 5 {
+		t.Errorf("%d items should be received but were %d", 5, i)
+	}
+	if d := time.Since(start); d < 3*limit {
+		t.Errorf("throttling should take at least %v but took %v", 3*limit, d)
+	}
+}
+
+func TestThrottleCost(t *testing.T) {
+	l := ratelimit.NewLimiter(time.Hour, 10)
+	out := ThrottleCost(context.Background(), source(4), l, func(v int) int { return v })
+
+	sum := 0
+	for v := range out {
+		sum += v
+	}
+
+	if sum != 6 {
+		t.Errorf("sum should be %d but was %d", 6, sum)
+	}
+	if tokens := l.Tokens(); tokens != 4 {
+		t.Errorf("%d tokens should remain but %d remained", 4, tokens)
+	}
+}
+
+func TestThrottleCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	out := Throttle(ctx, in, ratelimit.NewLimiter(time.Hour, 1))
+
+	in <- 1
+	if v := <-out; v != 1 {
+		t.Errorf("item should be %d but was %d", 1, v)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-out:
+		if ok {
+			t.Error("no further items should be received")
+		}
+	case <-time.After(time.Second):
+		t.Error("output channel should be closed after cancel")
+	}
+}
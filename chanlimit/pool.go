@@ -0,0 +1,122 @@
+package chanlimit
+
+import (
+	"context"
+	"sync"
+)
+
+// A Pool processes items using a fixed amount of
+// workers while a limiter shared by all workers
+// limits the rate at which items are processed.
+type Pool[T any] struct {
+	l       Waiter
+	workers int
+	work    func(ctx context.Context, item T) error
+
+	cost    func(T) int
+	onError func(item T, err error)
+}
+
+// NewPool returns a new Pool which processes items
+// by calling work using the given amount of workers.
+// Before an item is processed, its cost is taken
+// from the limiter l.
+func NewPool[T any](l Waiter, workers int, work func(ctx context.Context, item T) error) *Pool[T] {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &Pool[T]{
+		l:       l,
+		workers: workers,
+		work:    work,
+	}
+}
+
+// SetCost sets the function which returns the amount
+// of tokens an item costs. By default, each item
+// costs one token. Items with a cost <= 0 are
+// processed without waiting.
+func (p *Pool[T]) SetCost(fn func(T) int) {
+	p.cost = fn
+}
+
+// SetErrorHandler sets the function which is called
+// when an item could not be processed, either because
+// work failed or because its cost could not be taken
+// from the limiter. When set, the pool continues with
+// the next items. By default, the pool stops on the
+// first error and Run returns it.
+func (p *Pool[T]) SetErrorHandler(h func(item T, err error)) {
+	p.onError = h
+}
+
+// Run processes the items received from in until in
+// is closed and all received items are processed,
+// in which case nil is returned.
+//
+// When ctx is done, no further items are received and
+// Run returns the error of ctx after all workers have
+// returned. The context passed to work is canceled
+// then, so work should return early.
+func (p *Pool[T]) Run(ctx context.Context, in <-chan T) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	fail := func(item T, err error) {
+		if p.onError != nil {
+			p.onError(item, err)
+			return
+		}
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	wg.Add(p.workers)
+	for i := 0; i < p.workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				var (
+					item T
+					ok   bool
+				)
+				select {
+				case item, ok = <-in:
+					if !ok {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+
+				if err := p.l.WaitN(ctx, costOf(p.cost, item)); err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					fail(item, err)
+					continue
+				}
+
+				if err := p.work(ctx, item); err != nil {
+					fail(item, err)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
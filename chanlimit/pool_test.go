@@ -0,0 +1,128 @@
+package chanlimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+func TestPool(t *testing.T) {
+	const limit = 5 * time.Millisecond
+	const burst = 4
+
+	var (
+		mu        sync.Mutex
+		processed = make(map[int]bool)
+		active    int32
+		maxActive int32
+	)
+
+	p := NewPool(ratelimit.NewLimiter(limit, burst), 3, func(ctx context.Context, v int) error {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		processed[v] = true
+		return nil
+	})
+	p.SetCost(func(v int) int { return v % 2 })
+
+	start := time.Now()
+	if err := p.Run(context.Background(), source(20)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(processed) != 20 {
+		t.Errorf("%d items should be processed but were %d", 20, len(processed))
+	}
+	if m := atomic.LoadInt32(&maxActive); m > 3 {
+		t.Errorf("at most %d workers should be active but were %d", 3, m)
+	}
+	// 10 items cost one token each.
+	if d := time.Since(start); d < (10-burst)*limit {
+		t.Errorf("processing should take at least %v but took %v", (10-burst)*limit, d)
+	}
+}
+
+func TestPoolError(t *testing.T) {
+	errFail := errors.New("fail")
+
+	var calls int32
+	p := NewPool(ratelimit.NewLimiter(time.Millisecond, 100), 2, func(ctx context.Context, v int) error {
+		atomic.AddInt32(&calls, 1)
+		if v == 3 {
+			return errFail
+		}
+		return nil
+	})
+
+	in := make(chan int)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case in <- i:
+			case <-time.After(time.Second):
+				return
+			}
+		}
+	}()
+
+	if err := p.Run(context.Background(), in); err != errFail {
+		t.Errorf("Run() should return %v but returned %v", errFail, err)
+	}
+
+	var failed []int
+	p.SetErrorHandler(func(v int, err error) {
+		failed = append(failed, v)
+	})
+	p.SetCost(func(v int) int { return v * 100 })
+	if err := p.Run(context.Background(), source(3)); err != nil {
+		t.Errorf("Run() should return nil but returned %v", err)
+	}
+	if len(failed) != 1 || failed[0] != 2 {
+		t.Errorf("item 2 should fail because its cost exceeds the burst but failed were %v", failed)
+	}
+}
+
+func TestPoolCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var processed int32
+	p := NewPool(ratelimit.NewLimiter(time.Hour, 2), 4, func(ctx context.Context, v int) error {
+		atomic.AddInt32(&processed, 1)
+		return nil
+	})
+
+	done := make(chan error)
+	go func() { done <- p.Run(ctx, source(10)) }()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Run() should return %v but returned %v", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run() should return after cancel")
+	}
+
+	if n := atomic.LoadInt32(&processed); n != 2 {
+		t.Errorf("%d items should be processed but were %d", 2, n)
+	}
+}
@@ -0,0 +1,310 @@
+// Package cluster provides a peer-to-peer mode for
+// distributed rate limiting without an external
+// store.
+//
+// Each Node owns a slice of the key space which is
+// assigned using consistent hashing. Operations on
+// keys owned by other nodes are forwarded to the
+// owner via HTTP. When the owner is unreachable, the
+// operation is performed on the local store, so that
+// limits are still enforced per node.
+//
+// A Node implements ratelimit.Store, so it can be
+// used with a ratelimit.KeyedLimiter:
+//
+//	node := cluster.NewNode("http://10.0.0.1:7070", peers, ratelimit.NewMemoryStore())
+//	go http.ListenAndServe(":7070", node)
+//
+//	limiter := ratelimit.NewKeyedLimiterWithStore(node, 10*time.Second, 3)
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+// DefaultTimeout is the timeout of forwarded
+// requests when no client was passed to the Node.
+const DefaultTimeout = time.Second
+
+// maxBodySize is the maximum size of
+// request and response bodies.
+const maxBodySize = 1 << 16
+
+const (
+	opTake   = "take"
+	opTokens = "tokens"
+	opReset  = "reset"
+)
+
+// ErrUnknownOp is returned by a peer when
+// the requested operation is not supported.
+var ErrUnknownOp = errors.New("unknown operation")
+
+// request is the body of a forwarded operation.
+type request struct {
+	Op    string        `json:"op"`
+	Key   string        `json:"key"`
+	N     int           `json:"n,omitempty"`
+	Limit time.Duration `json:"limit,omitempty"`
+	Burst int           `json:"burst,omitempty"`
+}
+
+// response is the body of the answer to a
+// forwarded operation. Reset is the UnixNano
+// timestamp of the reset time or 0 if it is nil.
+type response struct {
+	Ok        bool   `json:"ok"`
+	Burst     int    `json:"burst"`
+	Remaining int    `json:"remaining"`
+	Reset     int64  `json:"reset"`
+	Tokens    int    `json:"tokens"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Node is a member of a cluster which owns a
+// slice of the key space. It implements
+// ratelimit.Store and http.Handler, where the
+// handler serves operations forwarded by peers.
+type Node struct {
+	self   string
+	local  ratelimit.Store
+	client *http.Client
+
+	mu      sync.RWMutex
+	ring    *Ring
+	onError func(peer string, err error)
+}
+
+var _ ratelimit.Store = (*Node)(nil)
+
+// NewNodeWithClient returns a new Node which is
+// reachable by its peers at the URL self. peers
+// contains the URLs of all other nodes of the
+// cluster. The state of all owned keys, and of all
+// keys of unreachable peers, is held by local.
+// Requests to peers are sent using client.
+func NewNodeWithClient(client *http.Client, self string, peers []string, local ratelimit.Store) *Node {
+	n := &Node{
+		self:   self,
+		local:  local,
+		client: client,
+	}
+	n.SetPeers(peers)
+
+	return n
+}
+
+// NewNode returns a new Node which is reachable by
+// its peers at the URL self. peers contains the URLs
+// of all other nodes of the cluster. The state of
+// all owned keys, and of all keys of unreachable
+// peers, is held by local.
+func NewNode(self string, peers []string, local ratelimit.Store) *Node {
+	return NewNodeWithClient(&http.Client{Timeout: DefaultTimeout}, self, peers, local)
+}
+
+// SetPeers replaces the peers of the node. The node
+// itself is always part of the cluster, so it does
+// not need to be contained in peers.
+func (n *Node) SetPeers(peers []string) {
+	nodes := make([]string, 0, len(peers)+1)
+	nodes = append(nodes, n.self)
+	for _, p := range peers {
+		if p != n.self {
+			nodes = append(nodes, p)
+		}
+	}
+
+	ring := NewRing(DefaultReplicas, nodes...)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.ring = ring
+}
+
+// SetErrorHandler sets a function which is called
+// when a peer could not be reached and the local
+// store has been used instead.
+func (n *Node) SetErrorHandler(onError func(peer string, err error)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.onError = onError
+}
+
+// Owner returns the URL of the node
+// which owns the given key.
+func (n *Node) Owner(key string) string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.ring.Get(key)
+}
+
+// TakeN implements ratelimit.Store#TakeN.
+func (n *Node) TakeN(ctx context.Context, key string, tokens int, l time.Duration, b int) (bool, ratelimit.Reservation, error) {
+	if tokens <= 0 {
+		return true, ratelimit.Reservation{}, nil
+	}
+
+	if owner := n.Owner(key); owner != n.self {
+		res, err := n.forward(ctx, owner, request{Op: opTake, Key: key, N: tokens, Limit: l, Burst: b})
+		if err == nil {
+			return res.Ok, ratelimit.NewReservation(res.Burst, res.Remaining, resetTime(res.Reset)), nil
+		}
+		if ctx.Err() != nil {
+			return false, ratelimit.Reservation{}, ctx.Err()
+		}
+		n.handleError(owner, err)
+	}
+
+	return n.local.TakeN(ctx, key, tokens, l, b)
+}
+
+// Tokens implements ratelimit.Store#Tokens.
+func (n *Node) Tokens(ctx context.Context, key string, l time.Duration, b int) (int, error) {
+	if owner := n.Owner(key); owner != n.self {
+		res, err := n.forward(ctx, owner, request{Op: opTokens, Key: key, Limit: l, Burst: b})
+		if err == nil {
+			return res.Tokens, nil
+		}
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		n.handleError(owner, err)
+	}
+
+	return n.local.Tokens(ctx, key, l, b)
+}
+
+// Reset implements ratelimit.Store#Reset.
+//
+// The key is also reset in the local store, so
+// that the state of a previously unreachable
+// owner is discarded as well.
+func (n *Node) Reset(ctx context.Context, key string) error {
+	if owner := n.Owner(key); owner != n.self {
+		if _, err := n.forward(ctx, owner, request{Op: opReset, Key: key}); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			n.handleError(owner, err)
+		}
+	}
+
+	return n.local.Reset(ctx, key)
+}
+
+// ServeHTTP handles operations forwarded by peers.
+// These are always performed on the local store.
+//
+// The endpoint is unauthenticated, so it must only
+// be reachable by the peers of the cluster.
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req request
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&req); err != nil {
+		writeResponse(w, http.StatusBadRequest, response{Error: err.Error()})
+		return
+	}
+
+	var (
+		res response
+		err error
+	)
+
+	ctx := r.Context()
+	switch req.Op {
+	case opTake:
+		var rs ratelimit.Reservation
+		res.Ok, rs, err = n.local.TakeN(ctx, req.Key, req.N, req.Limit, req.Burst)
+		res.Burst = rs.Burst
+		res.Remaining = rs.Remaining
+		res.Reset = rs.Reset.UnixNano()
+	case opTokens:
+		res.Tokens, err = n.local.Tokens(ctx, req.Key, req.Limit, req.Burst)
+	case opReset:
+		err = n.local.Reset(ctx, req.Key)
+	default:
+		err = ErrUnknownOp
+	}
+
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, response{Error: err.Error()})
+		return
+	}
+
+	writeResponse(w, http.StatusOK, res)
+}
+
+// forward sends the request to the given peer.
+func (n *Node) forward(ctx context.Context, peer string, req request) (response, error) {
+	var res response
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return res, err
+	}
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, peer, bytes.NewReader(body))
+	if err != nil {
+		return res, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+
+	hres, err := n.client.Do(hreq)
+	if err != nil {
+		return res, err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, hres.Body)
+		hres.Body.Close()
+	}()
+
+	if err = json.NewDecoder(io.LimitReader(hres.Body, maxBodySize)).Decode(&res); err != nil {
+		return res, fmt.Errorf("peer responded with status %d: %w", hres.StatusCode, err)
+	}
+
+	if hres.StatusCode != http.StatusOK {
+		return res, fmt.Errorf("peer responded with status %d: %s", hres.StatusCode, res.Error)
+	}
+
+	return res, nil
+}
+
+func (n *Node) handleError(peer string, err error) {
+	n.mu.RLock()
+	onError := n.onError
+	n.mu.RUnlock()
+
+	if onError != nil {
+		onError(peer, err)
+	}
+}
+
+func writeResponse(w http.ResponseWriter, status int, res response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+func resetTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
@@ -0,0 +1,233 @@
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+type testCluster struct {
+	nodes   []*Node
+	servers []*httptest.Server
+	stores  []*ratelimit.MemoryStore
+}
+
+func newTestCluster(t *testing.T, size int) *testCluster {
+	c := &testCluster{}
+
+	urls := make([]string, size)
+	for i := 0; i < size; i++ {
+		i := i
+		c.servers = append(c.servers, httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				c.nodes[i].ServeHTTP(w, r)
+			})))
+		urls[i] = c.servers[i].URL
+	}
+
+	for i := 0; i < size; i++ {
+		s := ratelimit.NewMemoryStore()
+		c.stores = append(c.stores, s)
+		c.nodes = append(c.nodes, NewNode(urls[i], urls, s))
+	}
+
+	t.Cleanup(func() {
+		for _, s := range c.servers {
+			s.Close()
+		}
+	})
+
+	return c
+}
+
+// keyOwnedBy returns a key which is owned by
+// the node with the given index.
+func (c *testCluster) keyOwnedBy(t *testing.T, i int) string {
+	for j := 0; j < 10000; j++ {
+		key := "key" + strconv.Itoa(j)
+		if c.nodes[0].Owner(key) == c.servers[i].URL {
+			return key
+		}
+	}
+	t.Fatalf("no key found owned by node %d", i)
+	return ""
+}
+
+func TestNodeOwner(t *testing.T) {
+	c := newTestCluster(t, 3)
+
+	for j := 0; j < 100; j++ {
+		key := "key" + strconv.Itoa(j)
+		owner := c.nodes[0].Owner(key)
+		for i, n := range c.nodes[1:] {
+			if o := n.Owner(key); o != owner {
+				t.Fatalf("node %d sees owner %q for key %q but node 0 sees %q", i+1, o, key, owner)
+			}
+		}
+	}
+}
+
+func TestNodeShared(t *testing.T) {
+	const limit = time.Hour
+	const burst = 3
+
+	c := newTestCluster(t, 3)
+
+	for i := range c.nodes {
+		key := c.keyOwnedBy(t, i)
+
+		limiters := make([]*ratelimit.KeyedLimiter, len(c.nodes))
+		for j, n := range c.nodes {
+			limiters[j] = ratelimit.NewKeyedLimiterWithStore(n, limit, burst)
+		}
+
+		// The bucket of the key is shared between all
+		// nodes, so only burst reservations are allowed
+		// in total.
+		var allowed int
+		for j := 0; j < 2*burst; j++ {
+			ok, res, err := limiters[j%len(limiters)].Reserve(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				allowed++
+			}
+			if res.Burst != burst {
+				t.Errorf("res.Burst should be %d but was %d", burst, res.Burst)
+			}
+		}
+
+		if allowed != burst {
+			t.Errorf("key owned by node %d: %d reservations should be allowed but %d were",
+				i, burst, allowed)
+		}
+
+		// Only the owner holds the state of the key.
+		for j, s := range c.stores {
+			tokens, _ := s.Tokens(context.Background(), key, limit, burst)
+			if j == i && tokens != 0 {
+				t.Errorf("owner should have %d tokens but had %d", 0, tokens)
+			}
+			if j != i && tokens != burst {
+				t.Errorf("node %d should not hold state of key owned by %d", j, i)
+			}
+		}
+
+		tokens, err := limiters[(i+1)%len(limiters)].Tokens(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tokens != 0 {
+			t.Errorf("tokens should be %d but were %d", 0, tokens)
+		}
+
+		if err = limiters[(i+1)%len(limiters)].Reset(key); err != nil {
+			t.Fatal(err)
+		}
+		if !limiters[i].Allow(key) {
+			t.Error("Reservation after reset was not successful")
+		}
+	}
+}
+
+func TestNodeFallback(t *testing.T) {
+	const limit = time.Hour
+	const burst = 2
+
+	c := newTestCluster(t, 3)
+	key := c.keyOwnedBy(t, 2)
+
+	var (
+		mu     sync.Mutex
+		failed []string
+	)
+	c.nodes[0].SetErrorHandler(func(peer string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, peer)
+	})
+
+	c.servers[2].Close()
+
+	l0 := ratelimit.NewKeyedLimiterWithStore(c.nodes[0], limit, burst)
+	l1 := ratelimit.NewKeyedLimiterWithStore(c.nodes[1], limit, burst)
+
+	// Without the owner, each node limits locally.
+	for _, l := range []*ratelimit.KeyedLimiter{l0, l1} {
+		for j := 0; j < burst; j++ {
+			ok, _, err := l.Reserve(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Fatal("Reservation was not successful")
+			}
+		}
+		if l.Allow(key) {
+			t.Error("Reservation was successful even though it should not")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failed) == 0 || failed[0] != c.servers[2].URL {
+		t.Errorf("error handler should be called with %q but was called with %v",
+			c.servers[2].URL, failed)
+	}
+}
+
+func TestNodeCanceled(t *testing.T) {
+	const limit = time.Hour
+	const burst = 2
+
+	c := newTestCluster(t, 2)
+	key := c.keyOwnedBy(t, 1)
+
+	called := false
+	c.nodes[0].SetErrorHandler(func(string, error) { called = true })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A canceled request must not be counted against
+	// the owner nor be served by the local store.
+	if _, _, err := c.nodes[0].TakeN(ctx, key, 1, limit, burst); err != context.Canceled {
+		t.Errorf("TakeN() should return %v but returned %v", context.Canceled, err)
+	}
+	if _, err := c.nodes[0].Tokens(ctx, key, limit, burst); err != context.Canceled {
+		t.Errorf("Tokens() should return %v but returned %v", context.Canceled, err)
+	}
+	if err := c.nodes[0].Reset(ctx, key); err != context.Canceled {
+		t.Errorf("Reset() should return %v but returned %v", context.Canceled, err)
+	}
+
+	if called {
+		t.Error("error handler should not be called")
+	}
+	if n := c.stores[0].Len(); n != 0 {
+		t.Errorf("local store should hold no buckets but held %d", n)
+	}
+}
+
+func TestNodeServeHTTP(t *testing.T) {
+	n := NewNode("http://self", nil, ratelimit.NewMemoryStore())
+
+	rec := httptest.NewRecorder()
+	n.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status should be %d but was %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+
+	c := newTestCluster(t, 2)
+	_, err := c.nodes[0].forward(context.Background(), c.servers[1].URL, request{Op: "unknown"})
+	if err == nil {
+		t.Error("unknown operation should fail")
+	}
+}
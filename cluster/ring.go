@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is the amount of virtual nodes
+// which are placed on the ring for each node.
+const DefaultReplicas = 100
+
+// Ring is an immutable consistent hash ring which
+// maps keys to nodes. Each node is placed multiple
+// times on the ring, so that keys are distributed
+// evenly and only a small share of the keys move
+// when nodes are added or removed.
+type Ring struct {
+	hashes []uint32
+	nodes  map[uint32]string
+}
+
+// NewRing returns a new Ring with the given nodes
+// where each node is placed replicas times on the
+// ring. If replicas is < 1, DefaultReplicas is used.
+// The ring does not depend on the order of nodes.
+func NewRing(replicas int, nodes ...string) *Ring {
+	if replicas < 1 {
+		replicas = DefaultReplicas
+	}
+
+	r := &Ring{
+		hashes: make([]uint32, 0, replicas*len(nodes)),
+		nodes:  make(map[uint32]string, replicas*len(nodes)),
+	}
+
+	for _, node := range nodes {
+		for i := 0; i < replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + node))
+			if other, ok := r.nodes[h]; ok {
+				// Break collisions independently of the
+				// order of nodes, so that all nodes of a
+				// cluster agree on the owners of keys.
+				if node < other {
+					r.nodes[h] = node
+				}
+				continue
+			}
+			r.nodes[h] = node
+			r.hashes = append(r.hashes, h)
+		}
+	}
+
+	sort.Slice(r.hashes, func(i, j int) bool {
+		return r.hashes[i] < r.hashes[j]
+	})
+
+	return r
+}
+
+// Get returns the node which owns the given key.
+// If the ring is empty, an empty string is
+// returned.
+func (r *Ring) Get(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= h
+	})
+	if i == len(r.hashes) {
+		i = 0
+	}
+
+	return r.nodes[r.hashes[i]]
+}
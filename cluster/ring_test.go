@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRingGet(t *testing.T) {
+	r := NewRing(0)
+	if n := r.Get("a"); n != "" {
+		t.Errorf("empty ring should return \"\" but returned %q", n)
+	}
+
+	nodes := []string{"a", "b", "c"}
+	r = NewRing(0, nodes...)
+
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := "key" + strconv.Itoa(i)
+		n := r.Get(key)
+		if n2 := r.Get(key); n != n2 {
+			t.Fatalf("Get(%q) is not stable: %q != %q", key, n, n2)
+		}
+		counts[n]++
+	}
+
+	for _, n := range nodes {
+		if counts[n] < 500 {
+			t.Errorf("node %q owns only %d of 3000 keys", n, counts[n])
+		}
+	}
+}
+
+func TestRingMove(t *testing.T) {
+	r1 := NewRing(0, "a", "b", "c")
+	r2 := NewRing(0, "a", "b", "c", "d")
+
+	var moved int
+	for i := 0; i < 3000; i++ {
+		key := "key" + strconv.Itoa(i)
+		n1, n2 := r1.Get(key), r2.Get(key)
+		if n1 != n2 {
+			if n2 != "d" {
+				t.Fatalf("key %q moved from %q to %q instead of d", key, n1, n2)
+			}
+			moved++
+		}
+	}
+
+	if moved > 1200 {
+		t.Errorf("adding a node should move about a quarter of the keys but moved %d of 3000", moved)
+	}
+}
+
+func TestRingCollision(t *testing.T) {
+	// The first virtual nodes of both
+	// nodes have the same hash.
+	const a, b = "http://node-818288", "http://node-17000020"
+
+	r1 := NewRing(1, a, b)
+	r2 := NewRing(1, b, a)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if n1, n2 := r1.Get(key), r2.Get(key); n1 != b || n2 != b {
+			t.Fatalf("key %q should be owned by %q on both rings but was owned by %q and %q", key, b, n1, n2)
+		}
+	}
+}
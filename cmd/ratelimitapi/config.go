@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Config defines the policies of the server.
+//
+//	{
+//	  "default_policy": "default",
+//	  "policies": {
+//	    "default": { "limit": "100ms", "burst": 10 },
+//	    "login":   { "limit": "1m", "burst": 5 }
+//	  }
+//	}
+type Config struct {
+	// DefaultPolicy is used for reservations
+	// which do not specify a policy.
+	DefaultPolicy string `json:"default_policy"`
+	// Policies maps the names of the
+	// policies to their limits.
+	Policies map[string]PolicyConfig `json:"policies"`
+}
+
+// PolicyConfig defines the token bucket
+// of each key of a policy.
+type PolicyConfig struct {
+	// Limit is the duration after which
+	// a new token is generated.
+	Limit Duration `json:"limit"`
+	// Burst is the size of the bucket.
+	Burst int `json:"burst"`
+}
+
+// Duration is a time.Duration which is encoded
+// in JSON as string like "1m30s".
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadConfig reads and validates the
+// Config from the file at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseConfig(data)
+}
+
+// ParseConfig parses and validates a Config.
+func ParseConfig(data []byte) (*Config, error) {
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+
+	if len(c.Policies) == 0 {
+		return nil, errors.New("at least one policy must be defined")
+	}
+	for name, p := range c.Policies {
+		if name == "" {
+			return nil, errors.New("policy names must not be empty")
+		}
+		if p.Limit <= 0 {
+			return nil, fmt.Errorf("%s: limit must be > 0", name)
+		}
+		if p.Burst <= 0 {
+			return nil, fmt.Errorf("%s: burst must be > 0", name)
+		}
+	}
+	if _, ok := c.Policies[c.DefaultPolicy]; c.DefaultPolicy != "" && !ok {
+		return nil, fmt.Errorf("default policy %q is not defined", c.DefaultPolicy)
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const testConfig = `{
+	"default_policy": "default",
+	"policies": {
+		"default": { "limit": "100ms", "burst": 10 },
+		"login": { "limit": "1m", "burst": 2 }
+	}
+}`
+
+func TestParseConfig(t *testing.T) {
+	c, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.DefaultPolicy != "default" {
+		t.Errorf("default policy should be %q but was %q", "default", c.DefaultPolicy)
+	}
+	if p := c.Policies["login"]; time.Duration(p.Limit) != time.Minute || p.Burst != 2 {
+		t.Errorf("login policy should be (%v, %d) but was (%v, %d)", time.Minute, 2, time.Duration(p.Limit), p.Burst)
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	cases := map[string]string{
+		"malformed":       `{"policies": `,
+		"no policies":     `{"policies": {}}`,
+		"invalid limit":   `{"policies": {"a": {"limit": "soon", "burst": 1}}}`,
+		"no limit":        `{"policies": {"a": {"burst": 1}}}`,
+		"no burst":        `{"policies": {"a": {"limit": "1s"}}}`,
+		"empty name":      `{"policies": {"": {"limit": "1s", "burst": 1}}}`,
+		"unknown default": `{"default_policy": "b", "policies": {"a": {"limit": "1s", "burst": 1}}}`,
+	}
+
+	for name, data := range cases {
+		if _, err := ParseConfig([]byte(data)); err == nil {
+			t.Errorf("%s: config should be invalid", name)
+		}
+	}
+}
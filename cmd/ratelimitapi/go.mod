@@ -0,0 +1,22 @@
+module github.com/zekroTJA/ratelimit/cmd/ratelimitapi
+
+go 1.24
+
+require (
+	github.com/redis/go-redis/v9 v9.22.0
+	github.com/zekroTJA/ratelimit v0.0.0
+	github.com/zekroTJA/ratelimit/httplimit v0.0.0
+	github.com/zekroTJA/ratelimit/redisstore v0.0.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	go.uber.org/atomic v1.11.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+)
+
+replace (
+	github.com/zekroTJA/ratelimit => ../../
+	github.com/zekroTJA/ratelimit/httplimit => ../../httplimit
+	github.com/zekroTJA/ratelimit/redisstore => ../../redisstore
+)
@@ -0,0 +1,138 @@
+// Command ratelimitapi is an HTTP server which decides
+// reservations of keys for other services, so that
+// services which are not written in Go can share the
+// limiters of this package.
+//
+// The policies are defined by a JSON config file
+// (see Config). The buckets are kept in memory or,
+// when the -redis flag is set, in Redis, so that
+// multiple instances of the server can share them.
+//
+//	ratelimitapi -addr :8080 -config policies.json
+//
+//	$ curl -X POST localhost:8080/v1/reserve -d '{"key":"user-42","n":1,"policy":"login"}'
+//	{"allowed":true,"policy":"login","reservation":{"burst":5,"remaining":4,"reset":"0001-01-01T00:00:00Z"}}
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zekroTJA/ratelimit"
+	"github.com/zekroTJA/ratelimit/httplimit"
+	"github.com/zekroTJA/ratelimit/redisstore"
+)
+
+// pruneInterval is the interval in which
+// refilled in-memory buckets are removed.
+const pruneInterval = time.Minute
+
+func main() {
+	addr := flag.String("addr", ":8080", "address of the HTTP server")
+	configPath := flag.String("config", "", "path of the config file")
+	redisAddr := flag.String("redis", "", "address of the Redis server (in-memory buckets if empty)")
+	redisPrefix := flag.String("redis-prefix", redisstore.DefaultPrefix, "prefix of the Redis keys")
+	ietf := flag.Bool("ietf-headers", false, "write the IETF RateLimit headers instead of X-RateLimit-*")
+	flag.Parse()
+
+	if err := run(*addr, *configPath, *redisAddr, *redisPrefix, *ietf); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(addr, configPath, redisAddr, redisPrefix string, ietf bool) error {
+	if configPath == "" {
+		return errors.New("a config file must be passed")
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	var store ratelimit.Store
+	if redisAddr != "" {
+		client := redis.NewClient(&redis.Options{Addr: redisAddr})
+		defer client.Close()
+		store = redisstore.New(client, redisPrefix)
+	} else {
+		ms := ratelimit.NewMemoryStore()
+		stop := prune(ms, config, pruneInterval)
+		defer stop()
+		store = ms
+	}
+
+	s := NewServer(store, config)
+	s.SetAdminToken(os.Getenv("RATELIMIT_ADMIN_TOKEN"))
+	if ietf {
+		s.SetHeaderWriter(httplimit.Headers(httplimit.IETFHeaders(), httplimit.RetryAfter(false)))
+	}
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	log.Printf("listening on %s", addr)
+	if err = srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// prune periodically removes the buckets of store
+// which are refilled for all policies of config and
+// returns a function which stops the pruning.
+func prune(store *ratelimit.MemoryStore, config *Config, interval time.Duration) func() {
+	// A bucket which is refilled using the longest limit
+	// and the highest burst is refilled for all policies.
+	var (
+		l time.Duration
+		b int
+	)
+	for _, p := range config.Policies {
+		if time.Duration(p.Limit) > l {
+			l = time.Duration(p.Limit)
+		}
+		if p.Burst > b {
+			b = p.Burst
+		}
+	}
+
+	t := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-t.C:
+				store.Prune(l, b)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		t.Stop()
+		close(done)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+	"github.com/zekroTJA/ratelimit/httplimit"
+)
+
+// ReserveRequest is the body of
+// POST /v1/reserve.
+type ReserveRequest struct {
+	// Key identifies the bucket.
+	Key string `json:"key"`
+	// N is the amount of tokens to
+	// reserve, 1 if not set.
+	N int `json:"n"`
+	// Policy is the name of the policy, the
+	// default policy of the Config if not set.
+	Policy string `json:"policy"`
+}
+
+// ReserveResponse is the response
+// of POST /v1/reserve.
+type ReserveResponse struct {
+	Allowed     bool                  `json:"allowed"`
+	Policy      string                `json:"policy"`
+	Reservation ratelimit.Reservation `json:"reservation"`
+}
+
+// KeyResponse is the response of
+// GET /v1/admin/policies/{policy}/keys/{key}.
+type KeyResponse struct {
+	Policy string   `json:"policy"`
+	Key    string   `json:"key"`
+	Tokens int      `json:"tokens"`
+	Burst  int      `json:"burst"`
+	Limit  Duration `json:"limit"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server is an HTTP server which decides reservations
+// for other services, so that services written in any
+// language can share the buckets.
+//
+//	POST   /v1/reserve
+//	GET    /v1/admin/policies/{policy}/keys/{key}
+//	DELETE /v1/admin/policies/{policy}/keys/{key}
+//
+// Denied reservations are answered with the status
+// 429 Too Many Requests. The state of the bucket is
+// attached as headers like by httplimit.Limiter.
+type Server struct {
+	now ratelimit.TimeSource
+	mux *http.ServeMux
+
+	policies      map[string]*ratelimit.KeyedLimiter
+	defaultPolicy string
+
+	headers    httplimit.HeaderWriter
+	adminToken string
+}
+
+var _ http.Handler = (*Server)(nil)
+
+// NewServerWithTimeSource returns a new Server with
+// the given TimeSource which keeps the buckets of
+// the policies of config in store.
+func NewServerWithTimeSource(timeSource ratelimit.TimeSource, store ratelimit.Store, config *Config) *Server {
+	s := &Server{
+		now:           timeSource,
+		mux:           http.NewServeMux(),
+		policies:      make(map[string]*ratelimit.KeyedLimiter, len(config.Policies)),
+		defaultPolicy: config.DefaultPolicy,
+		headers:       httplimit.DefaultHeaders(),
+	}
+
+	for name, p := range config.Policies {
+		s.policies[name] = ratelimit.NewKeyedLimiterWithStore(store, time.Duration(p.Limit), p.Burst)
+	}
+
+	s.mux.HandleFunc("POST /v1/reserve", s.handleReserve)
+	s.mux.HandleFunc("GET /v1/admin/policies/{policy}/keys/{key}", s.admin(s.handleGetKey))
+	s.mux.HandleFunc("DELETE /v1/admin/policies/{policy}/keys/{key}", s.admin(s.handleResetKey))
+
+	return s
+}
+
+// NewServer returns a new Server which keeps the
+// buckets of the policies of config in store.
+func NewServer(store ratelimit.Store, config *Config) *Server {
+	return NewServerWithTimeSource(time.Now, store, config)
+}
+
+// SetHeaderWriter sets the HeaderWriter which writes
+// the rate limit headers of reservations. By default,
+// httplimit.DefaultHeaders is used.
+func (s *Server) SetHeaderWriter(w httplimit.HeaderWriter) {
+	s.headers = w
+}
+
+// SetAdminToken sets the token which must be passed
+// as bearer token in the Authorization header to
+// the admin endpoints. If token is empty, which is
+// the default, the admin endpoints are disabled.
+func (s *Server) SetAdminToken(token string) {
+	s.adminToken = token
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleReserve(w http.ResponseWriter, r *http.Request) {
+	var req ReserveRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if req.Key == "" {
+		writeError(w, http.StatusBadRequest, "key must not be empty")
+		return
+	}
+	if req.N == 0 {
+		req.N = 1
+	}
+	if req.N < 0 {
+		writeError(w, http.StatusBadRequest, "n must be >= 0")
+		return
+	}
+	if req.Policy == "" {
+		req.Policy = s.defaultPolicy
+	}
+	if req.Policy == "" {
+		writeError(w, http.StatusBadRequest, "policy must not be empty")
+		return
+	}
+
+	kl, ok := s.policies[req.Policy]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown policy")
+		return
+	}
+
+	allowed, res, err := kl.ReserveNContext(r.Context(), bucketKey(req.Policy, req.Key), req.N)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "store unavailable")
+		return
+	}
+
+	if s.headers != nil {
+		s.headers(w.Header(), httplimit.Decision{
+			Allowed:     allowed,
+			Reservation: res,
+			Policy:      req.Policy,
+			Limit:       kl.Limit(),
+			Now:         s.now(),
+		})
+	}
+
+	status := http.StatusOK
+	if !allowed {
+		status = http.StatusTooManyRequests
+	}
+	writeJSON(w, status, ReserveResponse{
+		Allowed:     allowed,
+		Policy:      req.Policy,
+		Reservation: res,
+	})
+}
+
+func (s *Server) handleGetKey(w http.ResponseWriter, r *http.Request) {
+	policy, key := r.PathValue("policy"), r.PathValue("key")
+	kl, ok := s.policies[policy]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown policy")
+		return
+	}
+
+	tokens, err := kl.Store().Tokens(r.Context(), bucketKey(policy, key), kl.Limit(), kl.Burst())
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "store unavailable")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, KeyResponse{
+		Policy: policy,
+		Key:    key,
+		Tokens: tokens,
+		Burst:  kl.Burst(),
+		Limit:  Duration(kl.Limit()),
+	})
+}
+
+func (s *Server) handleResetKey(w http.ResponseWriter, r *http.Request) {
+	policy, key := r.PathValue("policy"), r.PathValue("key")
+	kl, ok := s.policies[policy]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown policy")
+		return
+	}
+
+	if err := kl.Store().Reset(r.Context(), bucketKey(policy, key)); err != nil {
+		writeError(w, http.StatusServiceUnavailable, "store unavailable")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// admin wraps h so that it is only served
+// for requests passing the admin token.
+func (s *Server) admin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			writeError(w, http.StatusNotFound, "admin endpoints are disabled")
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !equalTokens(token, s.adminToken) {
+			writeError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// bucketKey returns the key of the
+// bucket of key using policy.
+func bucketKey(policy, key string) string {
+	return policy + "|" + key
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
+
+// equalTokens compares the tokens a and b
+// in constant time.
+func equalTokens(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
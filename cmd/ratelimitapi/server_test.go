@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+func newTestServer(t *testing.T) (*Server, *time.Time) {
+	c, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts := func() time.Time { return now }
+	s := NewServerWithTimeSource(ts, ratelimit.NewMemoryStoreWithTimeSource(ts), c)
+	s.SetAdminToken("secret")
+
+	return s, &now
+}
+
+func do(s *Server, method, path, body, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestReserve(t *testing.T) {
+	s, now := newTestServer(t)
+	const body = `{"key": "user-42", "policy": "login"}`
+
+	for i := 0; i < 2; i++ {
+		rec := do(s, "POST", "/v1/reserve", body, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("reservation %d should succeed but got %d: %s", i, rec.Code, rec.Body)
+		}
+
+		var resp ReserveResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if !resp.Allowed || resp.Policy != "login" || resp.Reservation.Burst != 2 || resp.Reservation.Remaining != 1-i {
+			t.Errorf("reservation %d has unexpected response %+v", i, resp)
+		}
+		if v := rec.Header().Get("X-RateLimit-Remaining"); v != strconv.Itoa(1-i) {
+			t.Errorf("X-RateLimit-Remaining should be %d but was %q", 1-i, v)
+		}
+	}
+
+	rec := do(s, "POST", "/v1/reserve", body, "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("reservation should be denied but got %d", rec.Code)
+	}
+	var resp ReserveResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Allowed || !resp.Reservation.Reset.Time.Equal(now.Add(time.Minute)) {
+		t.Errorf("denied reservation has unexpected response %+v", resp)
+	}
+	if v := rec.Header().Get("Retry-After"); v != "60" {
+		t.Errorf("Retry-After should be %q but was %q", "60", v)
+	}
+
+	// The default policy and other keys
+	// have their own buckets.
+	if rec = do(s, "POST", "/v1/reserve", `{"key": "user-42", "n": 10}`, ""); rec.Code != http.StatusOK {
+		t.Errorf("reservation of the default policy should succeed but got %d", rec.Code)
+	}
+	if rec = do(s, "POST", "/v1/reserve", `{"key": "user-43", "policy": "login"}`, ""); rec.Code != http.StatusOK {
+		t.Errorf("reservation of other key should succeed but got %d", rec.Code)
+	}
+}
+
+func TestReserveInvalid(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	cases := map[string]struct {
+		body   string
+		status int
+	}{
+		"malformed":      {`{"key": `, http.StatusBadRequest},
+		"no key":         {`{"n": 1}`, http.StatusBadRequest},
+		"negative n":     {`{"key": "a", "n": -1}`, http.StatusBadRequest},
+		"unknown policy": {`{"key": "a", "policy": "b"}`, http.StatusNotFound},
+	}
+
+	for name, c := range cases {
+		if rec := do(s, "POST", "/v1/reserve", c.body, ""); rec.Code != c.status {
+			t.Errorf("%s: status should be %d but was %d", name, c.status, rec.Code)
+		}
+	}
+
+	if rec := do(s, "GET", "/v1/reserve", "", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET should not be allowed but got %d", rec.Code)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	s, _ := newTestServer(t)
+	const path = "/v1/admin/policies/login/keys/user-42"
+
+	do(s, "POST", "/v1/reserve", `{"key": "user-42", "policy": "login", "n": 2}`, "")
+
+	rec := do(s, "GET", path, "", "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status should be %d but was %d", http.StatusOK, rec.Code)
+	}
+	var resp KeyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := KeyResponse{Policy: "login", Key: "user-42", Tokens: 0, Burst: 2, Limit: Duration(time.Minute)}
+	if resp != want {
+		t.Errorf("response should be %+v but was %+v", want, resp)
+	}
+
+	if rec = do(s, "DELETE", path, "", "secret"); rec.Code != http.StatusNoContent {
+		t.Fatalf("status should be %d but was %d", http.StatusNoContent, rec.Code)
+	}
+	rec = do(s, "GET", path, "", "secret")
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Tokens != 2 {
+		t.Errorf("tokens should be %d after reset but were %d", 2, resp.Tokens)
+	}
+
+	if rec = do(s, "GET", "/v1/admin/policies/unknown/keys/a", "", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown policy should result in %d but got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec = do(s, "DELETE", path, "", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token should result in %d but got %d", http.StatusUnauthorized, rec.Code)
+	}
+	if rec = do(s, "GET", path, "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("missing token should result in %d but got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	s.SetAdminToken("")
+	if rec = do(s, "GET", path, "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("disabled admin endpoints should result in %d but got %d", http.StatusNotFound, rec.Code)
+	}
+}
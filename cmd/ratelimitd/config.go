@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// Config contains the descriptors of a domain. Its
+// format follows the configuration of the reference
+// implementation of the Envoy rate limit service.
+//
+//	domain: edge
+//	descriptors:
+//	  - key: remote_address
+//	    rate_limit:
+//	      unit: second
+//	      requests_per_unit: 10
+//	  - key: path
+//	    value: /login
+//	    descriptors:
+//	      - key: remote_address
+//	        rate_limit:
+//	          unit: minute
+//	          requests_per_unit: 5
+type Config struct {
+	Domain      string       `yaml:"domain"`
+	Descriptors []Descriptor `yaml:"descriptors"`
+}
+
+// Descriptor matches a descriptor entry by its key
+// and value. If the value is empty, all values of
+// the key match and each value has its own bucket.
+type Descriptor struct {
+	Key         string       `yaml:"key"`
+	Value       string       `yaml:"value"`
+	RateLimit   *RateLimit   `yaml:"rate_limit"`
+	Descriptors []Descriptor `yaml:"descriptors"`
+}
+
+// RateLimit defines the limit of a descriptor. It is
+// translated to a token bucket with a burst rate of
+// RequestsPerUnit, unless Burst is set, which is
+// refilled completely within one unit.
+type RateLimit struct {
+	Name            string `yaml:"name"`
+	Unit            string `yaml:"unit"`
+	RequestsPerUnit uint32 `yaml:"requests_per_unit"`
+	Burst           int    `yaml:"burst"`
+	Unlimited       bool   `yaml:"unlimited"`
+}
+
+var units = map[string]struct {
+	d    time.Duration
+	unit rlsv3.RateLimitResponse_RateLimit_Unit
+}{
+	"second": {time.Second, rlsv3.RateLimitResponse_RateLimit_SECOND},
+	"minute": {time.Minute, rlsv3.RateLimitResponse_RateLimit_MINUTE},
+	"hour":   {time.Hour, rlsv3.RateLimitResponse_RateLimit_HOUR},
+	"day":    {24 * time.Hour, rlsv3.RateLimitResponse_RateLimit_DAY},
+	"week":   {7 * 24 * time.Hour, rlsv3.RateLimitResponse_RateLimit_WEEK},
+	"month":  {30 * 24 * time.Hour, rlsv3.RateLimitResponse_RateLimit_MONTH},
+	"year":   {365 * 24 * time.Hour, rlsv3.RateLimitResponse_RateLimit_YEAR},
+}
+
+// LoadConfig reads and validates the
+// Config from the file at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseConfig(data)
+}
+
+// ParseConfig parses and validates a Config.
+func ParseConfig(data []byte) (*Config, error) {
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+
+	if c.Domain == "" {
+		return nil, errors.New("domain must not be empty")
+	}
+	if err := validate(c.Descriptors, c.Domain); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func validate(descriptors []Descriptor, path string) error {
+	seen := make(map[string]bool)
+	for _, d := range descriptors {
+		p := path + "." + d.Key
+		if d.Value != "" {
+			p += "_" + d.Value
+		}
+
+		if d.Key == "" {
+			return fmt.Errorf("%s: key must not be empty", path)
+		}
+		if seen[d.Key+"="+d.Value] {
+			return fmt.Errorf("%s: duplicate descriptor", p)
+		}
+		seen[d.Key+"="+d.Value] = true
+
+		if rl := d.RateLimit; rl != nil && !rl.Unlimited {
+			if _, ok := units[strings.ToLower(rl.Unit)]; !ok {
+				return fmt.Errorf("%s: invalid unit %q", p, rl.Unit)
+			}
+			if rl.RequestsPerUnit == 0 {
+				return fmt.Errorf("%s: requests_per_unit must be > 0", p)
+			}
+			if rl.Burst < 0 {
+				return fmt.Errorf("%s: burst must be >= 0", p)
+			}
+		}
+
+		if err := validate(d.Descriptors, p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bucket returns the limit duration after which a
+// new token is generated and the burst rate of the
+// token bucket of the rate limit.
+func (rl *RateLimit) bucket() (time.Duration, int) {
+	u := units[strings.ToLower(rl.Unit)]
+	b := rl.Burst
+	if b == 0 {
+		b = int(rl.RequestsPerUnit)
+	}
+	return u.d / time.Duration(rl.RequestsPerUnit), b
+}
+
+// proto returns the rate limit as reported
+// in the descriptor statuses.
+func (rl *RateLimit) proto() *rlsv3.RateLimitResponse_RateLimit {
+	return &rlsv3.RateLimitResponse_RateLimit{
+		Name:            rl.Name,
+		RequestsPerUnit: rl.RequestsPerUnit,
+		Unit:            units[strings.ToLower(rl.Unit)].unit,
+	}
+}
+
+// match returns the rate limit of the descriptor
+// with the given entries. If no descriptor matches
+// all entries or the matched descriptor has no rate
+// limit, nil is returned.
+func (c *Config) match(entries [][2]string) *RateLimit {
+	descriptors := c.Descriptors
+	var matched *Descriptor
+
+	for _, e := range entries {
+		matched = nil
+		for i := range descriptors {
+			d := &descriptors[i]
+			if d.Key != e[0] {
+				continue
+			}
+			if d.Value == e[1] {
+				matched = d
+				break
+			}
+			if d.Value == "" {
+				matched = d
+			}
+		}
+		if matched == nil {
+			return nil
+		}
+		descriptors = matched.Descriptors
+	}
+
+	if matched == nil {
+		return nil
+	}
+	return matched.RateLimit
+}
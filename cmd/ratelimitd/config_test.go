@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+domain: edge
+descriptors:
+  - key: remote_address
+    rate_limit:
+      unit: second
+      requests_per_unit: 10
+  - key: path
+    value: /login
+    descriptors:
+      - key: remote_address
+        rate_limit:
+          name: login
+          unit: minute
+          requests_per_unit: 3
+  - key: path
+    rate_limit:
+      unit: hour
+      requests_per_unit: 100
+      burst: 10
+  - key: internal
+    rate_limit:
+      unlimited: true
+`
+
+func TestParseConfig(t *testing.T) {
+	c, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		entries [][2]string
+		limit   time.Duration
+		burst   int
+		name    string
+	}{
+		{[][2]string{{"remote_address", "10.0.0.1"}}, 100 * time.Millisecond, 10, ""},
+		{[][2]string{{"path", "/login"}, {"remote_address", "10.0.0.1"}}, 20 * time.Second, 3, "login"},
+		{[][2]string{{"path", "/status"}}, 36 * time.Second, 10, ""},
+		{[][2]string{{"path", "/login"}}, 0, 0, ""},
+		{[][2]string{{"path", "/status"}, {"remote_address", "10.0.0.1"}}, 0, 0, ""},
+		{[][2]string{{"unknown", "x"}}, 0, 0, ""},
+	}
+
+	for _, cs := range cases {
+		rl := c.match(cs.entries)
+		if cs.burst == 0 {
+			if rl != nil {
+				t.Errorf("%v should match no rate limit but matched %+v", cs.entries, rl)
+			}
+			continue
+		}
+		if rl == nil {
+			t.Errorf("%v should match a rate limit", cs.entries)
+			continue
+		}
+		if l, b := rl.bucket(); l != cs.limit || b != cs.burst || rl.Name != cs.name {
+			t.Errorf("%v should match (%v, %d, %q) but matched (%v, %d, %q)",
+				cs.entries, cs.limit, cs.burst, cs.name, l, b, rl.Name)
+		}
+	}
+
+	if rl := c.match([][2]string{{"internal", "x"}}); rl == nil || !rl.Unlimited {
+		t.Errorf("internal should match an unlimited rate limit but matched %+v", rl)
+	}
+
+	if l, b := pruneBounds([]*Config{c}); l != 36*time.Second || b != 10 {
+		t.Errorf("prune bounds should be (%v, %d) but were (%v, %d)", 36*time.Second, 10, l, b)
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	cases := map[string]string{
+		"no domain":         "descriptors: []",
+		"no key":            "domain: a\ndescriptors:\n  - value: x",
+		"invalid unit":      "domain: a\ndescriptors:\n  - key: k\n    rate_limit: {unit: fortnight, requests_per_unit: 1}",
+		"no requests":       "domain: a\ndescriptors:\n  - key: k\n    rate_limit: {unit: second}",
+		"negative burst":    "domain: a\ndescriptors:\n  - key: k\n    rate_limit: {unit: second, requests_per_unit: 1, burst: -1}",
+		"duplicate":         "domain: a\ndescriptors:\n  - key: k\n  - key: k",
+		"nested invalid":    "domain: a\ndescriptors:\n  - key: k\n    descriptors:\n      - key: ''",
+		"malformed yaml":    "domain: [a",
+		"unknown unit case": "domain: a\ndescriptors:\n  - key: k\n    rate_limit: {unit: ms, requests_per_unit: 1}",
+	}
+
+	for name, data := range cases {
+		if _, err := ParseConfig([]byte(data)); err == nil {
+			t.Errorf("%s: config should be invalid", name)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edge.yaml")
+	if err := os.WriteFile(path, []byte(testConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Domain != "edge" {
+		t.Errorf("domain should be %q but was %q", "edge", c.Domain)
+	}
+
+	if _, err = LoadConfig(path + ".missing"); err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Errorf("loading a missing file should fail but returned %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+module github.com/zekroTJA/ratelimit/cmd/ratelimitd
+
+go 1.25.0
+
+require (
+	github.com/envoyproxy/go-control-plane/envoy v1.37.0
+	github.com/redis/go-redis/v9 v9.22.0
+	github.com/zekroTJA/ratelimit v0.0.0
+	github.com/zekroTJA/ratelimit/redisstore v0.0.0
+	google.golang.org/grpc v1.82.1
+	google.golang.org/protobuf v1.36.11
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/cncf/xds/go v0.0.0-20260202195803-dba9d589def2 // indirect
+	github.com/envoyproxy/protoc-gen-validate v1.3.3 // indirect
+	github.com/kr/pretty v0.3.1 // indirect
+	github.com/planetscale/vtprotobuf v0.6.1-0.20240319094008-0393e58bdf10 // indirect
+	github.com/rogpeppe/go-internal v1.12.0 // indirect
+	github.com/stretchr/testify v1.11.1 // indirect
+	go.uber.org/atomic v1.11.0 // indirect
+	golang.org/x/net v0.53.0 // indirect
+	golang.org/x/sys v0.43.0 // indirect
+	golang.org/x/text v0.36.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260414002931-afd174a4e478 // indirect
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+)
+
+replace (
+	github.com/zekroTJA/ratelimit => ../../
+	github.com/zekroTJA/ratelimit/redisstore => ../../redisstore
+)
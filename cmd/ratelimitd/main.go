@@ -0,0 +1,174 @@
+// Command ratelimitd is a rate limit service for
+// Envoy implementing the gRPC protocol
+// envoy.service.ratelimit.v3.RateLimitService.
+//
+// The limits are defined per domain by config
+// files (see Config). The buckets are kept in
+// memory or, when the -redis flag is set, in
+// Redis, so that multiple instances of the
+// service can share them.
+//
+//	ratelimitd -addr :8081 -config edge.yaml -config internal.yaml
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/redis/go-redis/v9"
+	"github.com/zekroTJA/ratelimit"
+	"github.com/zekroTJA/ratelimit/redisstore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// pruneInterval is the interval in which
+// refilled in-memory buckets are removed.
+const pruneInterval = time.Minute
+
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+func main() {
+	var configPaths stringsFlag
+	addr := flag.String("addr", ":8081", "address of the gRPC server")
+	redisAddr := flag.String("redis", "", "address of the Redis server (in-memory buckets if empty)")
+	redisPrefix := flag.String("redis-prefix", redisstore.DefaultPrefix, "prefix of the Redis keys")
+	flag.Var(&configPaths, "config", "path of a config file (can be passed multiple times)")
+	flag.Parse()
+
+	if err := run(*addr, *redisAddr, *redisPrefix, configPaths); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(addr, redisAddr, redisPrefix string, configPaths []string) error {
+	if len(configPaths) == 0 {
+		return fmt.Errorf("at least one config file must be passed")
+	}
+
+	configs := make([]*Config, len(configPaths))
+	for i, path := range configPaths {
+		c, err := LoadConfig(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		configs[i] = c
+	}
+
+	var (
+		store ratelimit.Store
+		ms    *ratelimit.MemoryStore
+	)
+	if redisAddr != "" {
+		client := redis.NewClient(&redis.Options{Addr: redisAddr})
+		defer client.Close()
+		store = redisstore.New(client, redisPrefix)
+	} else {
+		ms = ratelimit.NewMemoryStore()
+		store = ms
+	}
+
+	svc := NewService(store, configs...)
+	if ms != nil {
+		stop := prune(ms, svc.PruneBounds, pruneInterval)
+		defer stop()
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	srv := grpc.NewServer()
+	rlsv3.RegisterRateLimitServiceServer(srv, svc)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		srv.GracefulStop()
+	}()
+
+	log.Printf("listening on %s", lis.Addr())
+	return srv.Serve(lis)
+}
+
+// prune periodically removes the buckets of store
+// which are refilled for the bounds returned by
+// bounds and returns a function which stops the
+// pruning. The bounds are requested on each run,
+// so that limit overrides of requests are covered.
+func prune(store *ratelimit.MemoryStore, bounds func() (time.Duration, int), interval time.Duration) func() {
+	t := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-t.C:
+				if l, b := bounds(); l > 0 {
+					store.Prune(l, b)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		t.Stop()
+		close(done)
+	}
+}
+
+// pruneBounds returns the longest limit duration and
+// the highest burst rate of all rate limits of configs.
+// A bucket which is refilled using these values is
+// refilled for each of the rate limits.
+func pruneBounds(configs []*Config) (time.Duration, int) {
+	var (
+		maxL time.Duration
+		maxB int
+		walk func([]Descriptor)
+	)
+
+	walk = func(descriptors []Descriptor) {
+		for _, d := range descriptors {
+			if rl := d.RateLimit; rl != nil && !rl.Unlimited {
+				l, b := rl.bucket()
+				if l > maxL {
+					maxL = l
+				}
+				if b > maxB {
+					maxB = b
+				}
+			}
+			walk(d.Descriptors)
+		}
+	}
+
+	for _, c := range configs {
+		walk(c.Descriptors)
+	}
+
+	return maxL, maxB
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	rlv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/zekroTJA/ratelimit"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Service implements the Envoy rate limit service
+// (envoy.service.ratelimit.v3.RateLimitService)
+// using a ratelimit.Store for the buckets of the
+// configured descriptors.
+type Service struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+
+	mu      sync.RWMutex
+	now     ratelimit.TimeSource
+	store   ratelimit.Store
+	domains map[string]*Config
+
+	// overrideL and overrideB are the longest limit
+	// duration and the highest burst rate of all
+	// limit overrides of requests.
+	overrideL time.Duration
+	overrideB int
+}
+
+// NewServiceWithTimeSource returns a new Service with
+// the given TimeSource which keeps the buckets in
+// store and limits the descriptors of configs.
+func NewServiceWithTimeSource(timeSource ratelimit.TimeSource, store ratelimit.Store, configs ...*Config) *Service {
+	s := &Service{
+		now:   timeSource,
+		store: store,
+	}
+	s.SetConfigs(configs...)
+	return s
+}
+
+// NewService returns a new Service which keeps the
+// buckets in store and limits the descriptors of
+// configs.
+func NewService(store ratelimit.Store, configs ...*Config) *Service {
+	return NewServiceWithTimeSource(time.Now, store, configs...)
+}
+
+// SetConfigs replaces the configs of all domains.
+func (s *Service) SetConfigs(configs ...*Config) {
+	domains := make(map[string]*Config, len(configs))
+	for _, c := range configs {
+		domains[c.Domain] = c
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.domains = domains
+}
+
+// PruneBounds returns the longest limit duration and
+// the highest burst rate of all rate limits of the
+// configs and of all limit overrides of requests so
+// far. A bucket which is refilled using these values
+// is refilled for each of the rate limits, so it can
+// be pruned from the store.
+func (s *Service) PruneBounds() (time.Duration, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	configs := make([]*Config, 0, len(s.domains))
+	for _, c := range s.domains {
+		configs = append(configs, c)
+	}
+
+	l, b := pruneBounds(configs)
+	if s.overrideL > l {
+		l = s.overrideL
+	}
+	if s.overrideB > b {
+		b = s.overrideB
+	}
+	return l, b
+}
+
+// ShouldRateLimit implements
+// rlsv3.RateLimitServiceServer.
+//
+// Each descriptor takes hits_addend tokens (at least
+// one) from its bucket. Descriptors which match no
+// rate limit of the config of the domain are not
+// limited. If any descriptor is over its limit, the
+// overall code is OVER_LIMIT.
+func (s *Service) ShouldRateLimit(ctx context.Context, req *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	if req.GetDomain() == "" {
+		return nil, status.Error(codes.InvalidArgument, "domain must not be empty")
+	}
+	if len(req.GetDescriptors()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "descriptors must not be empty")
+	}
+
+	s.mu.RLock()
+	config := s.domains[req.GetDomain()]
+	s.mu.RUnlock()
+
+	resp := &rlsv3.RateLimitResponse{
+		OverallCode: rlsv3.RateLimitResponse_OK,
+		Statuses:    make([]*rlsv3.RateLimitResponse_DescriptorStatus, len(req.GetDescriptors())),
+	}
+
+	for i, d := range req.GetDescriptors() {
+		st, err := s.take(ctx, req, config, d)
+		if err != nil {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		if st.Code == rlsv3.RateLimitResponse_OVER_LIMIT {
+			resp.OverallCode = rlsv3.RateLimitResponse_OVER_LIMIT
+		}
+		resp.Statuses[i] = st
+	}
+
+	return resp, nil
+}
+
+// take takes the tokens of the descriptor d from
+// its bucket and returns the status of d.
+func (s *Service) take(
+	ctx context.Context,
+	req *rlsv3.RateLimitRequest,
+	config *Config,
+	d *rlv3.RateLimitDescriptor,
+) (*rlsv3.RateLimitResponse_DescriptorStatus, error) {
+	st := &rlsv3.RateLimitResponse_DescriptorStatus{
+		Code: rlsv3.RateLimitResponse_OK,
+	}
+
+	entries := make([][2]string, len(d.GetEntries()))
+	keys := make([]string, len(entries)+1)
+	keys[0] = req.GetDomain()
+	for i, e := range d.GetEntries() {
+		entries[i] = [2]string{e.GetKey(), e.GetValue()}
+		keys[i+1] = e.GetKey() + "=" + e.GetValue()
+	}
+
+	var rl *RateLimit
+	if o := d.GetLimit(); o != nil {
+		rl = &RateLimit{
+			Unit:            strings.ToLower(o.GetUnit().String()),
+			RequestsPerUnit: o.GetRequestsPerUnit(),
+		}
+		if _, ok := units[rl.Unit]; !ok || rl.RequestsPerUnit == 0 {
+			rl = nil
+		}
+	} else if config != nil {
+		rl = config.match(entries)
+	}
+
+	if rl == nil || rl.Unlimited {
+		return st, nil
+	}
+
+	n := int(req.GetHitsAddend())
+	if h := d.GetHitsAddend(); h != nil {
+		n = int(h.GetValue())
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	l, b := rl.bucket()
+	if d.GetLimit() != nil {
+		s.observeOverride(l, b)
+	}
+
+	ok, res, err := s.store.TakeN(ctx, strings.Join(keys, "|"), n, l, b)
+	if err != nil {
+		return nil, err
+	}
+
+	st.CurrentLimit = rl.proto()
+	st.LimitRemaining = uint32(res.Remaining)
+	if !ok {
+		st.Code = rlsv3.RateLimitResponse_OVER_LIMIT
+	}
+	if !res.Reset.IsNil() {
+		if d := res.Reset.Sub(s.now()); d > 0 {
+			st.DurationUntilReset = durationpb.New(d)
+		}
+	}
+
+	return st, nil
+}
+
+// observeOverride records the bucket bounds of a
+// limit override, so that its buckets are not
+// pruned before they are refilled.
+func (s *Service) observeOverride(l time.Duration, b int) {
+	s.mu.RLock()
+	known := l <= s.overrideL && b <= s.overrideB
+	s.mu.RUnlock()
+	if known {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l > s.overrideL {
+		s.overrideL = l
+	}
+	if b > s.overrideB {
+		s.overrideB = b
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	rlv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/zekroTJA/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newClient starts a gRPC server serving s
+// and returns a client connected to it.
+func newClient(t *testing.T, s *Service) rlsv3.RateLimitServiceClient {
+	lis := bufconn.Listen(1 << 16)
+	srv := grpc.NewServer()
+	rlsv3.RegisterRateLimitServiceServer(srv, s)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return rlsv3.NewRateLimitServiceClient(conn)
+}
+
+func descriptor(kv ...string) *rlv3.RateLimitDescriptor {
+	d := &rlv3.RateLimitDescriptor{}
+	for i := 0; i < len(kv); i += 2 {
+		d.Entries = append(d.Entries, &rlv3.RateLimitDescriptor_Entry{Key: kv[i], Value: kv[i+1]})
+	}
+	return d
+}
+
+func TestShouldRateLimit(t *testing.T) {
+	c, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts := func() time.Time { return now }
+	client := newClient(t, NewServiceWithTimeSource(ts, ratelimit.NewMemoryStoreWithTimeSource(ts), c))
+	ctx := context.Background()
+
+	login := &rlsv3.RateLimitRequest{
+		Domain: "edge",
+		Descriptors: []*rlv3.RateLimitDescriptor{
+			descriptor("path", "/login", "remote_address", "10.0.0.1"),
+			descriptor("remote_address", "10.0.0.1"),
+			descriptor("unknown", "x"),
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.ShouldRateLimit(ctx, login)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.OverallCode != rlsv3.RateLimitResponse_OK {
+			t.Fatalf("request %d should be OK but was %v", i, resp.OverallCode)
+		}
+		if n := len(resp.Statuses); n != 3 {
+			t.Fatalf("response should contain %d statuses but contained %d", 3, n)
+		}
+		st := resp.Statuses[0]
+		if st.LimitRemaining != uint32(2-i) {
+			t.Errorf("request %d should have %d remaining but had %d", i, 2-i, st.LimitRemaining)
+		}
+		if cl := st.CurrentLimit; cl.Name != "login" || cl.RequestsPerUnit != 3 || cl.Unit != rlsv3.RateLimitResponse_RateLimit_MINUTE {
+			t.Errorf("current limit should be login 3/minute but was %v", cl)
+		}
+		if resp.Statuses[2].CurrentLimit != nil {
+			t.Errorf("unknown descriptor should not be limited but was %v", resp.Statuses[2])
+		}
+	}
+
+	resp, err := client.ShouldRateLimit(ctx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.OverallCode != rlsv3.RateLimitResponse_OVER_LIMIT {
+		t.Errorf("request should be OVER_LIMIT but was %v", resp.OverallCode)
+	}
+	if st := resp.Statuses[0]; st.Code != rlsv3.RateLimitResponse_OVER_LIMIT || st.DurationUntilReset.AsDuration() != 20*time.Second {
+		t.Errorf("login status should be OVER_LIMIT with a reset in %v but was %v", 20*time.Second, st)
+	}
+	if st := resp.Statuses[1]; st.Code != rlsv3.RateLimitResponse_OK || st.LimitRemaining != 6 {
+		t.Errorf("remote address status should be OK with %d remaining but was %v", 6, st)
+	}
+
+	// Other clients have their own buckets.
+	other := &rlsv3.RateLimitRequest{
+		Domain:      "edge",
+		Descriptors: []*rlv3.RateLimitDescriptor{descriptor("path", "/login", "remote_address", "10.0.0.2")},
+	}
+	if resp, err = client.ShouldRateLimit(ctx, other); err != nil || resp.OverallCode != rlsv3.RateLimitResponse_OK {
+		t.Errorf("other client should be OK but was (%v, %v)", resp.GetOverallCode(), err)
+	}
+
+	now = now.Add(20 * time.Second)
+	if resp, err = client.ShouldRateLimit(ctx, login); err != nil || resp.OverallCode != rlsv3.RateLimitResponse_OK {
+		t.Errorf("request should be OK after refill but was (%v, %v)", resp.GetOverallCode(), err)
+	}
+}
+
+func TestShouldRateLimitHitsAndOverrides(t *testing.T) {
+	c, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(t, NewService(ratelimit.NewMemoryStore(), c))
+	ctx := context.Background()
+
+	resp, err := client.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{
+		Domain:      "edge",
+		HitsAddend:  7,
+		Descriptors: []*rlv3.RateLimitDescriptor{descriptor("remote_address", "10.0.0.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := resp.Statuses[0]; st.LimitRemaining != 3 {
+		t.Errorf("%d tokens should remain but %d remained", 3, st.LimitRemaining)
+	}
+
+	override := descriptor("unknown", "x")
+	override.Limit = &rlv3.RateLimitDescriptor_RateLimitOverride{
+		RequestsPerUnit: 1,
+		Unit:            typev3.RateLimitUnit_HOUR,
+	}
+	req := &rlsv3.RateLimitRequest{Domain: "edge", Descriptors: []*rlv3.RateLimitDescriptor{override}}
+	for _, want := range []rlsv3.RateLimitResponse_Code{rlsv3.RateLimitResponse_OK, rlsv3.RateLimitResponse_OVER_LIMIT} {
+		if resp, err = client.ShouldRateLimit(ctx, req); err != nil || resp.OverallCode != want {
+			t.Errorf("overridden descriptor should be %v but was (%v, %v)", want, resp.GetOverallCode(), err)
+		}
+	}
+
+	resp, err = client.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{
+		Domain:      "unknown",
+		Descriptors: []*rlv3.RateLimitDescriptor{descriptor("remote_address", "10.0.0.1")},
+	})
+	if err != nil || resp.OverallCode != rlsv3.RateLimitResponse_OK {
+		t.Errorf("unknown domain should not be limited but was (%v, %v)", resp.GetOverallCode(), err)
+	}
+
+	if _, err = client.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{Domain: "edge"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("request without descriptors should fail with %v but failed with %v", codes.InvalidArgument, err)
+	}
+}
+
+func TestPruneBounds(t *testing.T) {
+	c, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(ratelimit.NewMemoryStore(), c)
+
+	if l, b := s.PruneBounds(); l != 36*time.Second || b != 10 {
+		t.Errorf("bounds should be (%v, %d) but were (%v, %d)", 36*time.Second, 10, l, b)
+	}
+
+	override := descriptor("unknown", "x")
+	override.Limit = &rlv3.RateLimitDescriptor_RateLimitOverride{
+		RequestsPerUnit: 1,
+		Unit:            typev3.RateLimitUnit_HOUR,
+	}
+	_, err = s.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{
+		Domain:      "edge",
+		Descriptors: []*rlv3.RateLimitDescriptor{override},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l, b := s.PruneBounds(); l != time.Hour || b != 10 {
+		t.Errorf("bounds should include the override (%v, %d) but were (%v, %d)", time.Hour, 10, l, b)
+	}
+}
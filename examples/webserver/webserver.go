@@ -2,8 +2,8 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/zekroTJA/ratelimit"
@@ -67,12 +67,13 @@ func (ws *webServer) checkLimit(w http.ResponseWriter, r *http.Request) bool {
 	// Getting the address of the incomming connection.
 	// Because you will likely test this with a local connection,
 	// the local port number will be attached and differ on every
-	// request. So, we need to cut away everything behind the last
-	// ":", if existent.
-	addr := r.RemoteAddr
-	if strings.Contains(addr, ":") {
-		split := strings.Split(addr, ":")
-		addr = strings.Join(split[0:len(split)-1], ":")
+	// request. So, we need to split away the port, which also
+	// handles IPv6 addresses like "[::1]:1234" correctly.
+	// If your service runs behind a proxy, take a look at
+	// httplimit.ClientIP instead.
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
 	}
 
 	// Getting the limiter for the current connections address
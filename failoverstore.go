@@ -0,0 +1,392 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by FailoverStore#Reset
+// when the circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit is open")
+
+// FailurePolicy defines how a FailoverStore
+// decides reservations when its wrapped
+// store fails or the circuit is open.
+type FailurePolicy int
+
+const (
+	// FailOpen allows all reservations.
+	FailOpen FailurePolicy = iota
+	// FailClosed denies all reservations.
+	FailClosed
+	// FailLocal decides reservations using a local
+	// MemoryStore with the same limit and burst
+	// rate, so that each process limits on its own.
+	FailLocal
+)
+
+// CircuitState is the state of the circuit
+// breaker of a FailoverStore.
+type CircuitState int
+
+const (
+	// CircuitClosed passes all calls
+	// to the wrapped store.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen does not pass any calls to the
+	// wrapped store until the cooldown elapsed.
+	CircuitOpen
+	// CircuitHalfOpen passes a single trial call
+	// to the wrapped store which decides if the
+	// circuit is closed or opened again.
+	CircuitHalfOpen
+)
+
+const (
+	// DefaultFailoverTimeout is the default
+	// timeout of calls to the wrapped store.
+	DefaultFailoverTimeout = 100 * time.Millisecond
+	// DefaultFailureThreshold is the default amount
+	// of consecutive failures after which the
+	// circuit is opened.
+	DefaultFailureThreshold = 5
+	// DefaultCooldown is the default duration for
+	// which the circuit stays open.
+	DefaultCooldown = 5 * time.Second
+)
+
+// FailoverStore wraps a, typically remote, Store and
+// defines its behavior when the store is slow or
+// unavailable. Each call to the wrapped store is
+// limited by a timeout. After a number of consecutive
+// failures, a circuit breaker stops calling the store
+// for a cooldown period. While the store fails, all
+// reservations are decided by the FailurePolicy.
+//
+// The Path field of the returned Reservations reports
+// which path has been taken.
+//
+// Buckets of the local store of FailLocal which are
+// completely refilled are removed every
+// DefaultPruneInterval, so that the amount of buckets
+// is bounded by the amount of keys recently used
+// during an outage.
+type FailoverStore struct {
+	now    TimeSource
+	store  Store
+	local  *MemoryStore
+	policy FailurePolicy
+
+	mu        sync.Mutex
+	timeout   time.Duration
+	threshold int
+	cooldown  time.Duration
+	onError   func(error)
+
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	trial    bool
+
+	// localL and localB are the longest limit duration
+	// and the highest burst rate of all buckets of the
+	// local store, which are used to prune it.
+	localL        time.Duration
+	localB        int
+	pruneInterval time.Duration
+	nextPrune     time.Time
+}
+
+var _ Store = (*FailoverStore)(nil)
+
+// NewFailoverStoreWithTimeSource returns a new
+// FailoverStore with the given TimeSource which
+// wraps store and applies policy on failures.
+func NewFailoverStoreWithTimeSource(timeSource TimeSource, store Store, policy FailurePolicy) *FailoverStore {
+	return &FailoverStore{
+		now:           timeSource,
+		store:         store,
+		local:         NewMemoryStoreWithTimeSource(timeSource),
+		policy:        policy,
+		timeout:       DefaultFailoverTimeout,
+		threshold:     DefaultFailureThreshold,
+		cooldown:      DefaultCooldown,
+		pruneInterval: DefaultPruneInterval,
+	}
+}
+
+// NewFailoverStore returns a new FailoverStore
+// which wraps store and applies policy on
+// failures.
+func NewFailoverStore(store Store, policy FailurePolicy) *FailoverStore {
+	return NewFailoverStoreWithTimeSource(time.Now, store, policy)
+}
+
+// SetTimeout sets the timeout of each call
+// to the wrapped store. If d is <= 0, calls
+// are only limited by the passed context.
+func (s *FailoverStore) SetTimeout(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeout = d
+}
+
+// SetCircuitBreaker sets the amount of consecutive
+// failures after which the circuit is opened and the
+// duration for which it stays open.
+func (s *FailoverStore) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.threshold = threshold
+	s.cooldown = cooldown
+}
+
+// SetErrorHandler sets a function which is called
+// with every error of the wrapped store.
+func (s *FailoverStore) SetErrorHandler(onError func(error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onError = onError
+}
+
+// SetPruneInterval sets the interval in which
+// refilled buckets are removed from the local store
+// of FailLocal. If d is 0, no buckets are removed.
+func (s *FailoverStore) SetPruneInterval(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneInterval = d
+	s.nextPrune = time.Time{}
+}
+
+// State returns the current state of
+// the circuit breaker.
+func (s *FailoverStore) State() CircuitState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == CircuitOpen && !s.now().Before(s.openedAt.Add(s.cooldown)) {
+		return CircuitHalfOpen
+	}
+
+	return s.state
+}
+
+// TakeN implements Store#TakeN. If the wrapped
+// store fails or the circuit is open, the
+// reservation is decided by the FailurePolicy
+// and no error is returned. Only when ctx is
+// done, its error is returned.
+func (s *FailoverStore) TakeN(ctx context.Context, key string, n int, l time.Duration, b int) (bool, Reservation, error) {
+	if n <= 0 {
+		return true, Reservation{}, nil
+	}
+
+	if b <= 0 || l <= 0 {
+		return false, Reservation{}, nil
+	}
+
+	s.pruneLocal()
+
+	var (
+		ok  bool
+		res Reservation
+	)
+
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		ok, res, err = s.store.TakeN(ctx, key, n, l, b)
+		return err
+	})
+	if err == nil {
+		res.Path = PathStore
+		return ok, res, nil
+	}
+	if ctx.Err() != nil {
+		return false, Reservation{}, ctx.Err()
+	}
+
+	switch s.policy {
+	case FailOpen:
+		return true, Reservation{
+			Burst:     b,
+			Remaining: b,
+			Reset:     ResetTime{isNil: true},
+			Path:      PathFailOpen,
+		}, nil
+	case FailClosed:
+		return false, Reservation{
+			Burst: b,
+			Reset: NewResetTime(s.now().Add(l)),
+			Path:  PathFailClosed,
+		}, nil
+	default:
+		s.observeLocal(l, b)
+		ok, res, err = s.local.TakeN(ctx, key, n, l, b)
+		res.Path = PathLocal
+		return ok, res, err
+	}
+}
+
+// Tokens implements Store#Tokens. If the wrapped
+// store fails or the circuit is open, b is
+// returned for FailOpen, 0 for FailClosed and
+// the tokens of the local store for FailLocal.
+func (s *FailoverStore) Tokens(ctx context.Context, key string, l time.Duration, b int) (int, error) {
+	var tokens int
+
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		tokens, err = s.store.Tokens(ctx, key, l, b)
+		return err
+	})
+	if err == nil {
+		return tokens, nil
+	}
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	switch s.policy {
+	case FailOpen:
+		return b, nil
+	case FailClosed:
+		return 0, nil
+	default:
+		return s.local.Tokens(ctx, key, l, b)
+	}
+}
+
+// Reset implements Store#Reset. The key is reset
+// in the wrapped and in the local store. Errors
+// of the wrapped store are returned.
+func (s *FailoverStore) Reset(ctx context.Context, key string) error {
+	s.local.Reset(ctx, key)
+	return s.call(ctx, func(ctx context.Context) error {
+		return s.store.Reset(ctx, key)
+	})
+}
+
+// call executes fn with the configured timeout if
+// the circuit breaker permits it and records the
+// result.
+func (s *FailoverStore) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	s.mu.Lock()
+	if !s.permit() {
+		s.mu.Unlock()
+		return ErrCircuitOpen
+	}
+	timeout := s.timeout
+	s.mu.Unlock()
+
+	callCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := fn(callCtx)
+
+	s.mu.Lock()
+
+	// Failures caused by the caller giving up
+	// do not tell anything about the store.
+	if err != nil && ctx.Err() != nil {
+		s.trial = false
+		s.mu.Unlock()
+		return err
+	}
+
+	s.record(err)
+	onError := s.onError
+	s.mu.Unlock()
+
+	// The handler is called without holding the lock,
+	// so that it may call methods of the store.
+	if err != nil && onError != nil {
+		onError(err)
+	}
+
+	return err
+}
+
+// observeLocal records the bucket bounds of a
+// reservation decided by the local store, so that
+// its bucket is not pruned before it is refilled.
+func (s *FailoverStore) observeLocal(l time.Duration, b int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l > s.localL {
+		s.localL = l
+	}
+	if b > s.localB {
+		s.localB = b
+	}
+}
+
+// pruneLocal removes the refilled buckets from
+// the local store if the prune interval has
+// elapsed.
+func (s *FailoverStore) pruneLocal() {
+	s.mu.Lock()
+	if s.pruneInterval <= 0 || s.localB <= 0 {
+		s.mu.Unlock()
+		return
+	}
+	now := s.now()
+	if s.nextPrune.IsZero() {
+		s.nextPrune = now.Add(s.pruneInterval)
+	}
+	due := !now.Before(s.nextPrune)
+	if due {
+		s.nextPrune = now.Add(s.pruneInterval)
+	}
+	l, b := s.localL, s.localB
+	s.mu.Unlock()
+
+	if due {
+		s.local.Prune(l, b)
+	}
+}
+
+// permit returns true if a call may be passed to
+// the wrapped store. When the cooldown of an open
+// circuit elapsed, a single trial call is permitted.
+func (s *FailoverStore) permit() bool {
+	switch s.state {
+	case CircuitOpen:
+		if s.now().Before(s.openedAt.Add(s.cooldown)) {
+			return false
+		}
+		s.state = CircuitHalfOpen
+		s.trial = true
+		return true
+	case CircuitHalfOpen:
+		if s.trial {
+			return false
+		}
+		s.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the circuit breaker
+// with the result of a call.
+func (s *FailoverStore) record(err error) {
+	s.trial = false
+
+	if err == nil {
+		s.state = CircuitClosed
+		s.failures = 0
+		return
+	}
+
+	s.failures++
+	if s.state == CircuitHalfOpen || (s.threshold > 0 && s.failures >= s.threshold) {
+		s.state = CircuitOpen
+		s.openedAt = s.now()
+	}
+}
@@ -0,0 +1,250 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyStore fails all operations while
+// err is set and blocks while block is set.
+type flakyStore struct {
+	*MemoryStore
+
+	err   error
+	block bool
+	calls int
+}
+
+func (s *flakyStore) TakeN(ctx context.Context, key string, n int, l time.Duration, b int) (bool, Reservation, error) {
+	s.calls++
+	if s.block {
+		<-ctx.Done()
+		return false, Reservation{}, ctx.Err()
+	}
+	if s.err != nil {
+		return false, Reservation{}, s.err
+	}
+	return s.MemoryStore.TakeN(ctx, key, n, l, b)
+}
+
+func (s *flakyStore) Tokens(ctx context.Context, key string, l time.Duration, b int) (int, error) {
+	s.calls++
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.MemoryStore.Tokens(ctx, key, l, b)
+}
+
+func TestFailoverStorePolicies(t *testing.T) {
+	const limit = time.Hour
+	const burst = 2
+
+	errStore := errors.New("store error")
+	ctx := context.Background()
+
+	cases := []struct {
+		policy FailurePolicy
+		path   Path
+		ok     []bool
+		tokens int
+	}{
+		{FailOpen, PathFailOpen, []bool{true, true, true}, burst},
+		{FailClosed, PathFailClosed, []bool{false, false, false}, 0},
+		{FailLocal, PathLocal, []bool{true, true, false}, 0},
+	}
+
+	for _, c := range cases {
+		ts := &testTimeSource{}
+		fs := &flakyStore{MemoryStore: NewMemoryStoreWithTimeSource(ts.Now)}
+		s := NewFailoverStoreWithTimeSource(ts.Now, fs, c.policy)
+
+		ok, res, err := s.TakeN(ctx, "a", 1, limit, burst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || res.Path != PathStore {
+			t.Errorf("policy %d: reservation should succeed with path %q but returned (%t, %q)",
+				c.policy, PathStore, ok, res.Path)
+		}
+
+		fs.err = errStore
+
+		for i, expOk := range c.ok {
+			ok, res, err = s.TakeN(ctx, "a", 1, limit, burst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != expOk {
+				t.Errorf("policy %d, reservation %d: ok should be %t but was %t", c.policy, i, expOk, ok)
+			}
+			if res.Path != c.path {
+				t.Errorf("policy %d: path should be %q but was %q", c.policy, c.path, res.Path)
+			}
+			if res.Burst != burst {
+				t.Errorf("policy %d: res.Burst should be %d but was %d", c.policy, burst, res.Burst)
+			}
+		}
+
+		tokens, err := s.Tokens(ctx, "a", limit, burst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tokens != c.tokens {
+			t.Errorf("policy %d: tokens should be %d but were %d", c.policy, c.tokens, tokens)
+		}
+	}
+}
+
+func TestFailoverStoreCircuitBreaker(t *testing.T) {
+	const limit = time.Hour
+	const burst = 100
+	const cooldown = time.Second
+
+	ctx := context.Background()
+	ts := &testTimeSource{}
+	fs := &flakyStore{MemoryStore: NewMemoryStoreWithTimeSource(ts.Now), err: errors.New("store error")}
+	s := NewFailoverStoreWithTimeSource(ts.Now, fs, FailLocal)
+	s.SetCircuitBreaker(3, cooldown)
+
+	var errs int
+	s.SetErrorHandler(func(error) { errs++ })
+
+	for i := 0; i < 10; i++ {
+		s.TakeN(ctx, "a", 1, limit, burst)
+	}
+
+	if fs.calls != 3 {
+		t.Errorf("store should be called %d times but was called %d times", 3, fs.calls)
+	}
+	if errs != 3 {
+		t.Errorf("error handler should be called %d times but was called %d times", 3, errs)
+	}
+	if st := s.State(); st != CircuitOpen {
+		t.Errorf("state should be %d but was %d", CircuitOpen, st)
+	}
+
+	ts.Advance(cooldown)
+
+	if st := s.State(); st != CircuitHalfOpen {
+		t.Errorf("state should be %d but was %d", CircuitHalfOpen, st)
+	}
+
+	// The trial call fails, so the circuit opens again.
+	s.TakeN(ctx, "a", 1, limit, burst)
+	s.TakeN(ctx, "a", 1, limit, burst)
+	if fs.calls != 4 {
+		t.Errorf("store should be called %d times but was called %d times", 4, fs.calls)
+	}
+	if st := s.State(); st != CircuitOpen {
+		t.Errorf("state should be %d but was %d", CircuitOpen, st)
+	}
+
+	ts.Advance(cooldown)
+	fs.err = nil
+
+	_, res, _ := s.TakeN(ctx, "a", 1, limit, burst)
+	if res.Path != PathStore {
+		t.Errorf("path should be %q but was %q", PathStore, res.Path)
+	}
+	if st := s.State(); st != CircuitClosed {
+		t.Errorf("state should be %d but was %d", CircuitClosed, st)
+	}
+
+	if err := s.Reset(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailoverStoreTimeout(t *testing.T) {
+	ts := &testTimeSource{}
+	fs := &flakyStore{MemoryStore: NewMemoryStoreWithTimeSource(ts.Now), block: true}
+	s := NewFailoverStoreWithTimeSource(ts.Now, fs, FailClosed)
+	s.SetTimeout(10 * time.Millisecond)
+
+	ok, res, err := s.TakeN(context.Background(), "a", 1, time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok || res.Path != PathFailClosed {
+		t.Errorf("reservation should fail with path %q but returned (%t, %q)", PathFailClosed, ok, res.Path)
+	}
+
+	// When the caller cancels, its error is returned
+	// and it does not count as failure of the store.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.SetTimeout(0)
+	s.SetCircuitBreaker(1, time.Second)
+
+	_, _, err = s.TakeN(ctx, "a", 1, time.Second, 1)
+	if err != context.Canceled {
+		t.Errorf("error should be %v but was %v", context.Canceled, err)
+	}
+	if st := s.State(); st != CircuitClosed {
+		t.Errorf("state should be %d but was %d", CircuitClosed, st)
+	}
+}
+
+func TestFailoverStoreErrorHandlerReentrant(t *testing.T) {
+	fs := &flakyStore{MemoryStore: NewMemoryStore(), err: errors.New("store error")}
+	s := NewFailoverStore(fs, FailLocal)
+	s.SetCircuitBreaker(1, time.Hour)
+
+	var state CircuitState
+	s.SetErrorHandler(func(error) { state = s.State() })
+
+	done := make(chan struct{})
+	go func() {
+		s.TakeN(context.Background(), "a", 1, time.Second, 1)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("error handler calling the store should not deadlock")
+	}
+	if state != CircuitOpen {
+		t.Errorf("state in the error handler should be %v but was %v", CircuitOpen, state)
+	}
+}
+
+func TestFailoverStorePruneLocal(t *testing.T) {
+	const limit = time.Second
+	const burst = 2
+
+	ctx := context.Background()
+	ts := &testTimeSource{}
+	fs := &flakyStore{MemoryStore: NewMemoryStoreWithTimeSource(ts.Now), err: errors.New("store error")}
+	s := NewFailoverStoreWithTimeSource(ts.Now, fs, FailLocal)
+	s.SetCircuitBreaker(0, 0)
+
+	for i := 0; i < 10; i++ {
+		s.TakeN(ctx, string(rune('a'+i)), 1, limit, burst)
+	}
+	if n := s.local.Len(); n != 10 {
+		t.Fatalf("local store should hold %d buckets but held %d", 10, n)
+	}
+
+	// The store is available again, so only the
+	// prune interval removes the local buckets.
+	fs.err = nil
+	ts.Advance(DefaultPruneInterval)
+	if _, _, err := s.TakeN(ctx, "x", 1, limit, burst); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.local.Len(); n != 0 {
+		t.Errorf("refilled buckets should be pruned but local store held %d", n)
+	}
+
+	s.SetPruneInterval(0)
+	fs.err = errors.New("store error")
+	s.TakeN(ctx, "a", 1, limit, burst)
+	ts.Advance(2 * DefaultPruneInterval)
+	s.TakeN(ctx, "b", 1, limit, burst)
+	if n := s.local.Len(); n != 2 {
+		t.Errorf("buckets should not be pruned but local store held %d", n)
+	}
+}
@@ -0,0 +1,37 @@
+package fnlimit
+
+import "time"
+
+// Clock is a ratelimit.TimeSource which is also
+// able to schedule functions, so that the timers
+// of the wrappers can be driven by the same time
+// source as their limiters.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine
+	// after the duration d has elapsed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer scheduled by a Clock.
+type Timer interface {
+	// Stop prevents the timer from firing. It
+	// returns false if the timer has already
+	// fired or been stopped.
+	Stop() bool
+}
+
+// RealClock is the Clock using the
+// functions of the package time.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,308 @@
+// Package fnlimit provides generic wrappers which
+// limit how often a function is called, like
+// rate limited, throttled and debounced event
+// handlers.
+package fnlimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+// Mode defines how Limit handles calls
+// exceeding the rate of the limiter.
+//
+// In the Block and Coalesce modes, calls are
+// dropped if the limiter never generates tokens,
+// like with a limit or burst rate <= 0, instead of
+// being retried endlessly.
+type Mode int
+
+const (
+	// Drop discards excess calls.
+	Drop Mode = iota
+	// Block blocks excess calls until the
+	// limiter has a token available.
+	Block
+	// Coalesce defers an excess call until the
+	// limiter has a token available. Further calls
+	// in the meantime replace the argument of the
+	// deferred call, so only the latest argument
+	// is passed to the function.
+	Coalesce
+)
+
+// A Func wraps a function and controls
+// when calls are passed to it.
+type Func[T any] struct {
+	mu    sync.Mutex
+	clock Clock
+	fn    func(T)
+
+	call func(f *Func[T], v T) bool
+
+	pending bool
+	arg     T
+	timer   Timer
+	gen     uint64
+
+	stopped chan struct{}
+}
+
+func newFunc[T any](clock Clock, fn func(T), call func(f *Func[T], v T) bool) *Func[T] {
+	return &Func[T]{
+		clock:   clock,
+		fn:      fn,
+		call:    call,
+		stopped: make(chan struct{}),
+	}
+}
+
+// LimitWithClock returns a Func which passes calls to
+// fn at the rate of the limiter l using the given
+// Clock, which should be the time source of l. Excess
+// calls are handled according to mode.
+func LimitWithClock[T any](clock Clock, fn func(T), l *ratelimit.Limiter, mode Mode) *Func[T] {
+	switch mode {
+	case Block:
+		return newFunc(clock, fn, func(f *Func[T], v T) bool {
+			for {
+				ok, res := l.Reserve()
+				if ok {
+					f.fn(v)
+					return true
+				}
+				if res.Reset.Time.IsZero() {
+					return false
+				}
+				if !f.sleep(res.Reset.Sub(clock.Now())) {
+					return false
+				}
+			}
+		})
+	case Coalesce:
+		return newFunc(clock, fn, func(f *Func[T], v T) bool {
+			f.mu.Lock()
+			if f.pending {
+				f.arg = v
+				f.mu.Unlock()
+				return true
+			}
+			ok, res := l.Reserve()
+			if !ok && res.Reset.Time.IsZero() {
+				f.mu.Unlock()
+				return false
+			}
+			if !ok {
+				f.pending, f.arg = true, v
+				f.schedule(res.Reset.Sub(clock.Now()), func() {
+					f.coalesced(l)
+				})
+			}
+			f.mu.Unlock()
+
+			if ok {
+				f.fn(v)
+			}
+			return true
+		})
+	default:
+		return newFunc(clock, fn, func(f *Func[T], v T) bool {
+			if !l.Allow() {
+				return false
+			}
+			f.fn(v)
+			return true
+		})
+	}
+}
+
+// Limit returns a Func which passes calls to fn at
+// the rate of the limiter l. Excess calls are
+// handled according to mode.
+func Limit[T any](fn func(T), l *ratelimit.Limiter, mode Mode) *Func[T] {
+	return LimitWithClock(RealClock, fn, l, mode)
+}
+
+// ThrottleWithClock returns a Func which calls fn at
+// most once per interval using the given Clock. The
+// call is made at the trailing edge of the interval
+// with the argument of the latest call.
+func ThrottleWithClock[T any](clock Clock, fn func(T), interval time.Duration) *Func[T] {
+	return newFunc(clock, fn, func(f *Func[T], v T) bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if !f.pending {
+			f.pending = true
+			f.schedule(interval, f.fire)
+		}
+		f.arg = v
+		return true
+	})
+}
+
+// Throttle returns a Func which calls fn at most
+// once per interval. The call is made at the
+// trailing edge of the interval with the argument
+// of the latest call.
+func Throttle[T any](fn func(T), interval time.Duration) *Func[T] {
+	return ThrottleWithClock(RealClock, fn, interval)
+}
+
+// DebounceWithClock returns a Func which calls fn
+// with the argument of the latest call after no
+// further calls have been made for the duration
+// wait using the given Clock.
+func DebounceWithClock[T any](clock Clock, fn func(T), wait time.Duration) *Func[T] {
+	return newFunc(clock, fn, func(f *Func[T], v T) bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.pending, f.arg = true, v
+		f.schedule(wait, f.fire)
+		return true
+	})
+}
+
+// Debounce returns a Func which calls fn with the
+// argument of the latest call after no further
+// calls have been made for the duration wait.
+func Debounce[T any](fn func(T), wait time.Duration) *Func[T] {
+	return DebounceWithClock(RealClock, fn, wait)
+}
+
+// Call passes v to the wrapped function according to
+// the semantics of the Func. It returns false if the
+// call has been dropped. Deferred calls count as
+// passed. After Stop, all calls are dropped.
+func (f *Func[T]) Call(v T) bool {
+	select {
+	case <-f.stopped:
+		return false
+	default:
+	}
+
+	return f.call(f, v)
+}
+
+// Flush immediately calls the wrapped function with
+// the argument of the deferred call, if there is one,
+// regardless of the limiter or the timers.
+func (f *Func[T]) Flush() {
+	f.mu.Lock()
+	v, ok := f.take()
+	f.mu.Unlock()
+
+	if ok {
+		f.fn(v)
+	}
+}
+
+// Stop discards the deferred call, if there is one,
+// and unblocks calls waiting in the Block mode.
+// Afterwards, all calls are dropped.
+func (f *Func[T]) Stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.take()
+	select {
+	case <-f.stopped:
+	default:
+		close(f.stopped)
+	}
+}
+
+// schedule (re)starts the timer which calls fire
+// after d. f.mu must be held.
+func (f *Func[T]) schedule(d time.Duration, fire func()) {
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+
+	f.gen++
+	gen := f.gen
+	f.timer = f.clock.AfterFunc(d, func() {
+		f.mu.Lock()
+		current := f.gen == gen
+		f.mu.Unlock()
+		if current {
+			fire()
+		}
+	})
+}
+
+// fire calls the wrapped function with
+// the argument of the deferred call.
+func (f *Func[T]) fire() {
+	f.Flush()
+}
+
+// coalesced tries to make the deferred call using
+// the limiter l. If l has no token available, the
+// call is deferred again. If l never generates
+// tokens, the call is dropped.
+func (f *Func[T]) coalesced(l *ratelimit.Limiter) {
+	f.mu.Lock()
+	if !f.pending {
+		f.mu.Unlock()
+		return
+	}
+
+	ok, res := l.Reserve()
+	if !ok && res.Reset.Time.IsZero() {
+		f.take()
+		f.mu.Unlock()
+		return
+	}
+	if !ok {
+		f.schedule(res.Reset.Sub(f.clock.Now()), func() {
+			f.coalesced(l)
+		})
+		f.mu.Unlock()
+		return
+	}
+
+	v, _ := f.take()
+	f.mu.Unlock()
+
+	f.fn(v)
+}
+
+// take returns and clears the argument of the
+// deferred call and stops its timer. f.mu
+// must be held.
+func (f *Func[T]) take() (T, bool) {
+	var zero T
+	if !f.pending {
+		return zero, false
+	}
+
+	v := f.arg
+	f.pending, f.arg = false, zero
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	f.gen++
+
+	return v, true
+}
+
+// sleep blocks for the duration d on the clock.
+// It returns false if f has been stopped.
+func (f *Func[T]) sleep(d time.Duration) bool {
+	done := make(chan struct{})
+	t := f.clock.AfterFunc(d, func() { close(done) })
+
+	select {
+	case <-done:
+		return true
+	case <-f.stopped:
+		t.Stop()
+		return false
+	}
+}
@@ -0,0 +1,223 @@
+package fnlimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+type recorder struct {
+	mu    sync.Mutex
+	calls []int
+}
+
+func (r *recorder) fn(v int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, v)
+}
+
+func (r *recorder) get() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.calls...)
+}
+
+func assertCalls(t *testing.T, r *recorder, exp ...int) {
+	t.Helper()
+	calls := r.get()
+	if len(calls) != len(exp) {
+		t.Fatalf("calls were %v (expected %v)", calls, exp)
+	}
+	for i := range exp {
+		if calls[i] != exp[i] {
+			t.Fatalf("calls were %v (expected %v)", calls, exp)
+		}
+	}
+}
+
+func TestLimitDrop(t *testing.T) {
+	c := newFakeClock()
+	var r recorder
+	f := LimitWithClock(c, r.fn, ratelimit.NewLimiterWithTimeSource(c.Now, time.Second, 2), Drop)
+
+	if !f.Call(1) || !f.Call(2) {
+		t.Fatal("calls were dropped within burst")
+	}
+	if f.Call(3) {
+		t.Fatal("call was not dropped")
+	}
+	assertCalls(t, &r, 1, 2)
+
+	c.Advance(time.Second)
+	if !f.Call(4) {
+		t.Fatal("call was dropped after refill")
+	}
+	assertCalls(t, &r, 1, 2, 4)
+}
+
+func TestLimitBlock(t *testing.T) {
+	c := newFakeClock()
+	var r recorder
+	f := LimitWithClock(c, r.fn, ratelimit.NewLimiterWithTimeSource(c.Now, time.Second, 1), Block)
+
+	f.Call(1)
+
+	done := make(chan bool)
+	go func() { done <- f.Call(2) }()
+
+	for c.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assertCalls(t, &r, 1)
+
+	c.Advance(time.Second)
+	if !<-done {
+		t.Fatal("blocked call was dropped")
+	}
+	assertCalls(t, &r, 1, 2)
+
+	go func() { done <- f.Call(3) }()
+	for c.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	f.Stop()
+	if <-done {
+		t.Fatal("blocked call was passed after stop")
+	}
+	assertCalls(t, &r, 1, 2)
+}
+
+func TestLimitCoalesce(t *testing.T) {
+	c := newFakeClock()
+	var r recorder
+	f := LimitWithClock(c, r.fn, ratelimit.NewLimiterWithTimeSource(c.Now, time.Second, 1), Coalesce)
+
+	for i := 1; i <= 4; i++ {
+		if !f.Call(i) {
+			t.Fatalf("call %d was dropped", i)
+		}
+	}
+	assertCalls(t, &r, 1)
+
+	c.Advance(500 * time.Millisecond)
+	assertCalls(t, &r, 1)
+
+	c.Advance(500 * time.Millisecond)
+	assertCalls(t, &r, 1, 4)
+
+	c.Advance(time.Second)
+	assertCalls(t, &r, 1, 4)
+
+	f.Call(5)
+	f.Call(6)
+	f.Flush()
+	assertCalls(t, &r, 1, 4, 5, 6)
+	if c.Pending() != 0 {
+		t.Fatal("timer was not stopped on flush")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	c := newFakeClock()
+	var r recorder
+	f := ThrottleWithClock(c, r.fn, time.Second)
+
+	f.Call(1)
+	c.Advance(600 * time.Millisecond)
+	f.Call(2)
+	assertCalls(t, &r)
+
+	c.Advance(400 * time.Millisecond)
+	assertCalls(t, &r, 2)
+
+	c.Advance(time.Second)
+	assertCalls(t, &r, 2)
+
+	f.Call(3)
+	c.Advance(time.Second)
+	assertCalls(t, &r, 2, 3)
+}
+
+func TestDebounce(t *testing.T) {
+	c := newFakeClock()
+	var r recorder
+	f := DebounceWithClock(c, r.fn, time.Second)
+
+	f.Call(1)
+	c.Advance(600 * time.Millisecond)
+	f.Call(2)
+	c.Advance(600 * time.Millisecond)
+	assertCalls(t, &r)
+
+	c.Advance(400 * time.Millisecond)
+	assertCalls(t, &r, 2)
+
+	f.Call(3)
+	f.Stop()
+	c.Advance(time.Second)
+	assertCalls(t, &r, 2)
+
+	if f.Call(4) {
+		t.Fatal("call was passed after stop")
+	}
+}
+
+func TestDebounceRealClock(t *testing.T) {
+	done := make(chan int, 1)
+	f := Debounce(func(v int) { done <- v }, 10*time.Millisecond)
+
+	f.Call(1)
+	f.Call(2)
+
+	select {
+	case v := <-done:
+		if v != 2 {
+			t.Fatalf("argument was %d (expected 2)", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("debounced call was not made")
+	}
+}
+
+func TestLimitNoTokens(t *testing.T) {
+	c := newFakeClock()
+	l := ratelimit.NewLimiterWithTimeSource(c.Now, time.Second, 0)
+
+	for _, mode := range []Mode{Block, Coalesce} {
+		var r recorder
+		f := LimitWithClock(c, r.fn, l, mode)
+
+		done := make(chan bool, 1)
+		go func() { done <- f.Call(1) }()
+		select {
+		case ok := <-done:
+			if ok {
+				t.Errorf("mode %d: call should be dropped", mode)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("mode %d: call should not block", mode)
+		}
+
+		if n := c.Pending(); n != 0 {
+			t.Errorf("mode %d: no timer should be scheduled but %d were", mode, n)
+		}
+		assertCalls(t, &r)
+	}
+
+	// A limiter losing its burst while a call is
+	// deferred drops the deferred call.
+	l = ratelimit.NewLimiterWithTimeSource(c.Now, time.Second, 1)
+	var r recorder
+	f := LimitWithClock(c, r.fn, l, Coalesce)
+	f.Call(1)
+	f.Call(2)
+	l.SetBurst(0)
+	c.Advance(time.Second)
+	assertCalls(t, &r, 1)
+	if n := c.Pending(); n != 0 {
+		t.Errorf("no timer should be scheduled but %d were", n)
+	}
+}
@@ -0,0 +1,240 @@
+// Package gossip provides approximate global rate
+// limiting for very hot keys where coordinating
+// every single reservation is too slow.
+//
+// Each Node enforces a share of the global limit
+// locally using a ratelimit.Limiter. Periodically,
+// the nodes exchange their demand (the amount of
+// requested tokens since the last exchange) and
+// rebalance their shares proportionally to the
+// demand, so that busy nodes get a larger part of
+// the global limit.
+//
+// The shares of all nodes sum up to the global
+// limit, so the global overshoot is bounded by the
+// rounding of the local burst rates (at most one
+// token per node) plus the tokens which are granted
+// using outdated shares until the next exchange.
+package gossip
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+// DefaultPeerTTL is the duration after which a
+// peer is considered dead when no message has been
+// received from it.
+const DefaultPeerTTL = 10 * time.Second
+
+// Message is exchanged between nodes to
+// report their demand.
+type Message struct {
+	// Node is the ID of the sending node.
+	Node string `json:"node"`
+	// Demand is the amount of tokens requested
+	// from the node since its last message.
+	Demand int `json:"demand"`
+}
+
+// Transport delivers messages to all peers.
+type Transport interface {
+	// Broadcast sends msg to all peers.
+	Broadcast(ctx context.Context, msg Message) error
+}
+
+type peer struct {
+	demand int
+	seen   time.Time
+}
+
+// Node is a member of a group of nodes which
+// share a global limit. It implements the
+// reservation methods of ratelimit.Limiter.
+type Node struct {
+	mu  sync.Mutex
+	now ratelimit.TimeSource
+
+	id        string
+	transport Transport
+	limit     time.Duration
+	burst     int
+	peerTTL   time.Duration
+
+	local  *ratelimit.Limiter
+	share  float64
+	demand int
+	last   int
+	peers  map[string]peer
+}
+
+// NewNodeWithTimeSource returns a new Node with the
+// given TimeSource, the unique id and a transport to
+// reach its peers. The group of nodes shares a global
+// burst rate of b and a limit time of l until a new
+// token will be generated.
+//
+// Until the first exchange with peers, the node
+// enforces the full global limit locally.
+func NewNodeWithTimeSource(
+	timeSource ratelimit.TimeSource,
+	id string,
+	transport Transport,
+	l time.Duration,
+	b int,
+) *Node {
+	return &Node{
+		now:       timeSource,
+		id:        id,
+		transport: transport,
+		limit:     l,
+		burst:     b,
+		peerTTL:   DefaultPeerTTL,
+		local:     ratelimit.NewLimiterWithTimeSource(timeSource, l, b),
+		share:     1,
+		peers:     make(map[string]peer),
+	}
+}
+
+// NewNode returns a new Node with the unique id and
+// a transport to reach its peers. The group of nodes
+// shares a global burst rate of b and a limit time
+// of l until a new token will be generated.
+//
+// Until the first exchange with peers, the node
+// enforces the full global limit locally.
+func NewNode(id string, transport Transport, l time.Duration, b int) *Node {
+	return NewNodeWithTimeSource(time.Now, id, transport, l, b)
+}
+
+// SetPeerTTL sets the duration after which a
+// peer is considered dead when no message has
+// been received from it.
+func (n *Node) SetPeerTTL(d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.peerTTL = d
+}
+
+// ReserveN behaves like Limiter#ReserveN using
+// the local share of the global limit. All
+// requested tokens count as demand of the node,
+// whether the reservation succeeds or not.
+func (n *Node) ReserveN(tokens int) (bool, ratelimit.Reservation) {
+	if tokens > 0 {
+		n.mu.Lock()
+		n.demand += tokens
+		n.mu.Unlock()
+	}
+
+	return n.local.ReserveN(tokens)
+}
+
+// Reserve is shorthand for ReserveN(1).
+func (n *Node) Reserve() (bool, ratelimit.Reservation) {
+	return n.ReserveN(1)
+}
+
+// AllowN is shorthand for ReserveN(n) but only
+// returning a boolean which exposes the
+// succeed of the reservation.
+func (n *Node) AllowN(tokens int) bool {
+	ok, _ := n.ReserveN(tokens)
+	return ok
+}
+
+// Allow is shorthand for AllowN(1).
+func (n *Node) Allow() bool {
+	return n.AllowN(1)
+}
+
+// Share returns the current share of the
+// global limit which is enforced by the node.
+func (n *Node) Share() float64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.share
+}
+
+// Gossip sends the demand of the node since the
+// last call of Gossip to all peers and rebalances
+// the local share.
+func (n *Node) Gossip(ctx context.Context) error {
+	n.mu.Lock()
+	msg := Message{Node: n.id, Demand: n.demand}
+	n.last = n.demand
+	n.demand = 0
+	n.rebalance()
+	n.mu.Unlock()
+
+	return n.transport.Broadcast(ctx, msg)
+}
+
+// Receive processes a message of a peer and
+// rebalances the local share.
+func (n *Node) Receive(msg Message) {
+	if msg.Node == n.id {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.peers[msg.Node] = peer{
+		demand: msg.Demand,
+		seen:   n.now(),
+	}
+	n.rebalance()
+}
+
+// Run calls Gossip every interval until ctx is
+// canceled. Errors of the transport are passed
+// to onError, if set.
+func (n *Node) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := n.Gossip(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// rebalance calculates the share of the node from
+// its own last reported demand and the demands of
+// all live peers and applies it to the local
+// limiter. Each node is weighted with its demand
+// plus one, so that idle nodes keep a small share.
+func (n *Node) rebalance() {
+	now := n.now()
+
+	own := float64(n.last + 1)
+	total := own
+	for id, p := range n.peers {
+		if now.Sub(p.seen) > n.peerTTL {
+			delete(n.peers, id)
+			continue
+		}
+		total += float64(p.demand + 1)
+	}
+
+	n.share = own / total
+
+	burst := int(math.Floor(float64(n.burst) * n.share))
+	if burst < 1 {
+		burst = 1
+	}
+
+	n.local.SetLimit(time.Duration(float64(n.limit) / n.share))
+	n.local.SetBurst(burst)
+}
@@ -0,0 +1,156 @@
+package gossip
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit/storetest"
+)
+
+// memTransport delivers messages directly
+// to all nodes of the group.
+type memTransport struct {
+	nodes *[]*Node
+}
+
+func (t memTransport) Broadcast(_ context.Context, msg Message) error {
+	for _, n := range *t.nodes {
+		n.Receive(msg)
+	}
+	return nil
+}
+
+func newTestGroup(c *storetest.Clock, size int, l time.Duration, b int) []*Node {
+	nodes := make([]*Node, 0, size)
+	tr := memTransport{nodes: &nodes}
+	for i := 0; i < size; i++ {
+		nodes = append(nodes, NewNodeWithTimeSource(c.Now, string(rune('a'+i)), tr, l, b))
+	}
+	return nodes
+}
+
+// TestSimulation simulates a group of three nodes
+// with very different demand over 60 seconds and
+// checks that the global limit is approximately
+// enforced and that the shares follow the demand.
+func TestSimulation(t *testing.T) {
+	const limit = 100 * time.Millisecond
+	const burst = 10
+	const step = 10 * time.Millisecond
+	const gossipInterval = 500 * time.Millisecond
+	const duration = 60 * time.Second
+
+	ctx := context.Background()
+	c := storetest.NewClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	nodes := newTestGroup(c, 3, limit, burst)
+
+	// Demand per step: node a requests 10 times the
+	// global rate, node b the global rate and node c
+	// does not request anything.
+	demand := []int{10, 1, 0}
+	allowed := make([]int, len(nodes))
+
+	for elapsed := time.Duration(0); elapsed < duration; elapsed += step {
+		if elapsed%gossipInterval == 0 {
+			for _, n := range nodes {
+				if err := n.Gossip(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		for i, n := range nodes {
+			for j := 0; j < demand[i]; j++ {
+				if n.Allow() {
+					allowed[i]++
+				}
+			}
+		}
+
+		c.Advance(step)
+	}
+
+	var total int
+	for _, a := range allowed {
+		total += a
+	}
+
+	// The first gossip round already happens at the
+	// start, so the bound is the global rate plus the
+	// global burst plus one token per node for the
+	// rounding of the local burst rates.
+	maxAllowed := int(duration/limit) + burst + len(nodes)
+	if total > maxAllowed {
+		t.Errorf("%d tokens were granted globally but at most %d should be", total, maxAllowed)
+	}
+
+	minAllowed := int(duration/limit) * 8 / 10
+	if total < minAllowed {
+		t.Errorf("%d tokens were granted globally but at least %d should be", total, minAllowed)
+	}
+
+	if s := nodes[0].Share(); s < 0.8 {
+		t.Errorf("share of the busiest node should be > 0.8 but was %f", s)
+	}
+	if s := nodes[2].Share(); s > 0.05 {
+		t.Errorf("share of the idle node should be < 0.05 but was %f", s)
+	}
+	if allowed[2] != 0 {
+		t.Errorf("idle node should not grant any tokens but granted %d", allowed[2])
+	}
+
+	var sum float64
+	for _, n := range nodes {
+		sum += n.Share()
+	}
+	if sum < 0.99 || sum > 1.01 {
+		t.Errorf("shares should sum up to 1 but summed up to %f", sum)
+	}
+}
+
+func TestPeerTTL(t *testing.T) {
+	c := storetest.NewClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	nodes := newTestGroup(c, 2, time.Second, 10)
+	nodes[0].SetPeerTTL(time.Second)
+
+	nodes[1].AllowN(5)
+	nodes[1].Gossip(context.Background())
+
+	if s := nodes[0].Share(); s >= 0.5 {
+		t.Errorf("share should be < 0.5 but was %f", s)
+	}
+
+	c.Advance(2 * time.Second)
+	nodes[0].Gossip(context.Background())
+
+	if s := nodes[0].Share(); s != 1 {
+		t.Errorf("share should be 1 after the peer expired but was %f", s)
+	}
+}
+
+func TestHTTPTransport(t *testing.T) {
+	n1 := NewNode("a", nil, time.Second, 10)
+	srv := httptest.NewServer(n1)
+	defer srv.Close()
+
+	tr := NewHTTPTransport(nil, []string{srv.URL})
+	n2 := NewNode("b", tr, time.Second, 10)
+
+	n2.AllowN(9)
+	if err := n2.Gossip(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// n1 has no demand and n2 requested 9 tokens, so
+	// the weights are 1 and 10.
+	if s, exp := n1.Share(), 1.0/11; s != exp {
+		t.Errorf("share should be %f but was %f", exp, s)
+	}
+
+	tr.SetPeers([]string{srv.URL + "/invalid", "http://127.0.0.1:0"})
+	if err := n2.Gossip(context.Background()); err == nil {
+		t.Error("broadcast to unreachable peer should fail")
+	}
+}
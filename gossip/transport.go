@@ -0,0 +1,131 @@
+package gossip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxBodySize is the maximum size of a message.
+const maxBodySize = 1 << 12
+
+// HTTPTransport broadcasts messages to peers by
+// sending them as JSON via HTTP POST requests.
+type HTTPTransport struct {
+	client *http.Client
+
+	mu    sync.RWMutex
+	peers []string
+}
+
+var _ Transport = (*HTTPTransport)(nil)
+
+// NewHTTPTransport returns a new HTTPTransport
+// which sends messages to the given peer URLs
+// using client. If client is nil,
+// http.DefaultClient is used.
+func NewHTTPTransport(client *http.Client, peers []string) *HTTPTransport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &HTTPTransport{
+		client: client,
+		peers:  peers,
+	}
+}
+
+// SetPeers replaces the peer URLs.
+func (t *HTTPTransport) SetPeers(peers []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.peers = peers
+}
+
+// Broadcast implements Transport#Broadcast. The
+// message is sent to all peers concurrently. The
+// returned error contains all failed peers.
+func (t *HTTPTransport) Broadcast(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	t.mu.RLock()
+	peers := t.peers
+	t.mu.RUnlock()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []string
+	)
+
+	for _, p := range peers {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			if err := t.send(ctx, p, body); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %s", p, err))
+				mu.Unlock()
+			}
+		}(p)
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("broadcast failed: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func (t *HTTPTransport) send(ctx context.Context, peer string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+	}()
+
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// ServeHTTP receives messages sent by
+// the HTTPTransport of peers.
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var msg Message
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n.Receive(msg)
+	w.WriteHeader(http.StatusNoContent)
+}
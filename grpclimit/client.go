@@ -0,0 +1,150 @@
+package grpclimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ClientLimiter throttles outbound calls using a
+// ratelimit.Limiter per target, so that a slow or
+// exhausted backend does not affect the calls to
+// other backends.
+//
+// When a call fails with the code ResourceExhausted,
+// the bucket of the target is paused until the delay
+// of its RetryInfo detail passed. Without RetryInfo,
+// the bucket is paused until the next token would
+// be generated.
+type ClientLimiter struct {
+	mu  sync.Mutex
+	now ratelimit.TimeSource
+
+	limit time.Duration
+	burst int
+
+	limiters map[string]*ratelimit.Limiter
+}
+
+// NewClientLimiterWithTimeSource returns a new
+// ClientLimiter with the given TimeSource. Each
+// target has its own Limiter with a burst rate of
+// b and a limit time of l until a new token will
+// be generated.
+func NewClientLimiterWithTimeSource(timeSource ratelimit.TimeSource, l time.Duration, b int) *ClientLimiter {
+	return &ClientLimiter{
+		now:      timeSource,
+		limit:    l,
+		burst:    b,
+		limiters: make(map[string]*ratelimit.Limiter),
+	}
+}
+
+// NewClientLimiter returns a new ClientLimiter.
+// Each target has its own Limiter with a burst
+// rate of b and a limit time of l until a new
+// token will be generated.
+func NewClientLimiter(l time.Duration, b int) *ClientLimiter {
+	return NewClientLimiterWithTimeSource(time.Now, l, b)
+}
+
+// Limiter returns the Limiter of the given target,
+// as returned by grpc.ClientConn#Target.
+func (c *ClientLimiter) Limiter(target string) *ratelimit.Limiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	l, ok := c.limiters[target]
+	if !ok {
+		l = ratelimit.NewLimiterWithTimeSource(c.now, c.limit, c.burst)
+		c.limiters[target] = l
+	}
+
+	return l
+}
+
+// UnaryClientInterceptor returns an interceptor
+// which waits for a token of the target before
+// each unary call.
+func (c *ClientLimiter) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		l := c.Limiter(cc.Target())
+		if err := wait(ctx, l); err != nil {
+			return err
+		}
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		c.observe(l, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns an interceptor
+// which waits for a token of the target before
+// each stream is opened.
+func (c *ClientLimiter) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		l := c.Limiter(cc.Target())
+		if err := wait(ctx, l); err != nil {
+			return nil, err
+		}
+
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			c.observe(l, err)
+			return nil, err
+		}
+
+		return &clientStream{ClientStream: cs, c: c, l: l}, nil
+	}
+}
+
+// observe pauses the bucket l if err
+// has the code ResourceExhausted.
+func (c *ClientLimiter) observe(l *ratelimit.Limiter, err error) {
+	if status.Code(err) != codes.ResourceExhausted {
+		return
+	}
+
+	var next time.Time
+	if d, ok := RetryDelay(err); ok {
+		next = c.now().Add(d)
+	}
+	l.Sync(0, next)
+}
+
+// wait blocks until l has a token available and
+// converts its errors to status errors.
+func wait(ctx context.Context, l *ratelimit.Limiter) error {
+	err := l.Wait(ctx)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+}
+
+// clientStream wraps a ClientStream to pause
+// the bucket when the stream fails with the
+// code ResourceExhausted.
+type clientStream struct {
+	grpc.ClientStream
+
+	c *ClientLimiter
+	l *ratelimit.Limiter
+}
+
+func (s *clientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	s.c.observe(s.l, err)
+	return err
+}
@@ -0,0 +1,103 @@
+package grpclimit
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const target = "passthrough:///bufnet"
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	cl := NewClientLimiter(time.Millisecond, 5)
+	server := New(ratelimit.NewKeyedLimiter(time.Hour, 1), Method())
+	c := serve(t, server, grpc.WithUnaryInterceptor(cl.UnaryClientInterceptor()))
+
+	if _, err := c.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := c.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("call should fail with %v but failed with %v", codes.ResourceExhausted, err)
+	}
+	if tokens := cl.Limiter(target).Tokens(); tokens != 0 {
+		t.Errorf("bucket of the target should be paused but had %d tokens", tokens)
+	}
+	if tokens := cl.Limiter("other").Tokens(); tokens != 5 {
+		t.Errorf("bucket of other targets should not be affected but had %d tokens", tokens)
+	}
+
+	// The call is throttled on the client,
+	// so the server is not called.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.Check(ctx, &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.ResourceExhausted || time.Since(start) > 50*time.Millisecond {
+		t.Errorf("call should fail immediately with %v but failed with %v", codes.ResourceExhausted, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err = c.Check(ctx, &healthpb.HealthCheckRequest{}); status.Code(err) != codes.Canceled {
+		t.Errorf("call should fail with %v but failed with %v", codes.Canceled, err)
+	}
+}
+
+func TestClientInterceptorRetryDelay(t *testing.T) {
+	const delay = 100 * time.Millisecond
+
+	var calls int32
+	deny := func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return nil, DeniedError(ratelimit.NewReservation(1, 0, time.Now().Add(delay)), time.Now())
+		}
+		return handler(ctx, req)
+	}
+
+	cl := NewClientLimiter(time.Millisecond, 5)
+	c := serveWith(t, []grpc.ServerOption{grpc.UnaryInterceptor(deny)},
+		grpc.WithUnaryInterceptor(cl.UnaryClientInterceptor()))
+
+	if _, err := c.Check(context.Background(), &healthpb.HealthCheckRequest{}); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("call should fail with %v but failed with %v", codes.ResourceExhausted, err)
+	}
+
+	start := time.Now()
+	if _, err := c.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < delay-10*time.Millisecond {
+		t.Errorf("call should wait for the retry delay of %v but took %v", delay, d)
+	}
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	cl := NewClientLimiter(time.Millisecond, 5)
+	server := New(ratelimit.NewKeyedLimiter(time.Hour, 1), Method())
+	c := serve(t, server, grpc.WithStreamInterceptor(cl.StreamClientInterceptor()))
+
+	for i, want := range []codes.Code{codes.OK, codes.ResourceExhausted} {
+		ctx, cancel := context.WithCancel(context.Background())
+		st, err := c.Watch(ctx, &healthpb.HealthCheckRequest{})
+		if err == nil {
+			_, err = st.Recv()
+		}
+		cancel()
+		if status.Code(err) != want {
+			t.Errorf("stream %d should result in %v but got %v", i, want, err)
+		}
+	}
+
+	if tokens := cl.Limiter(target).Tokens(); tokens != 0 {
+		t.Errorf("bucket of the target should be paused but had %d tokens", tokens)
+	}
+}
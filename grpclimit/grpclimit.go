@@ -0,0 +1,235 @@
+// Package grpclimit provides gRPC interceptors
+// which limit calls using keyed limiters.
+//
+// For each call, a key (like the peer IP or an
+// API key passed as metadata) is extracted using
+// a KeyFunc. If the bucket of the key has no
+// tokens left, the call is denied with the code
+// ResourceExhausted and a RetryInfo detail.
+package grpclimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// KeyedReserver is implemented by limiters which
+// manage a bucket per key, like
+// ratelimit.KeyedLimiter.
+type KeyedReserver interface {
+	ReserveNContext(ctx context.Context, key string, n int) (bool, ratelimit.Reservation, error)
+}
+
+// ErrorHandler converts errors of the KeyFunc
+// or the limiter to the error of the call.
+type ErrorHandler func(ctx context.Context, err error) error
+
+type contextKey int
+
+const reservationKey contextKey = iota
+
+// ReservationFromContext returns the Reservation
+// of the current call, if available.
+func ReservationFromContext(ctx context.Context) (ratelimit.Reservation, bool) {
+	res, ok := ctx.Value(reservationKey).(ratelimit.Reservation)
+	return res, ok
+}
+
+// Limiter limits calls using a KeyedReserver
+// and a KeyFunc.
+type Limiter struct {
+	mu  sync.RWMutex
+	now ratelimit.TimeSource
+
+	limiter KeyedReserver
+	keyFunc KeyFunc
+	methods map[string]KeyedReserver
+
+	perMessage bool
+	onError    ErrorHandler
+}
+
+// NewWithTimeSource returns a new Limiter with the
+// given TimeSource which limits calls using the
+// given limiter per key extracted by keyFunc.
+func NewWithTimeSource(timeSource ratelimit.TimeSource, limiter KeyedReserver, keyFunc KeyFunc) *Limiter {
+	return &Limiter{
+		now:     timeSource,
+		limiter: limiter,
+		keyFunc: keyFunc,
+		methods: make(map[string]KeyedReserver),
+		onError: defaultError,
+	}
+}
+
+// New returns a new Limiter which limits calls
+// using the given limiter per key extracted by
+// keyFunc.
+func New(limiter KeyedReserver, keyFunc KeyFunc) *Limiter {
+	return NewWithTimeSource(time.Now, limiter, keyFunc)
+}
+
+// SetMethodLimiter sets the limiter which is used
+// for calls to the given full method name instead
+// of the default limiter. The buckets of the key
+// are separated per method. If limiter is nil,
+// calls to the method are not limited.
+func (l *Limiter) SetMethodLimiter(method string, limiter KeyedReserver) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.methods[method] = limiter
+}
+
+// SetPerMessage enables limiting of each message
+// received on streams. When enabled, each received
+// message takes a token from the bucket of the
+// stream in addition to the token taken when the
+// stream is opened. If the bucket is exhausted,
+// RecvMsg returns the ResourceExhausted error.
+func (l *Limiter) SetPerMessage(enabled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.perMessage = enabled
+}
+
+// SetErrorHandler sets the handler which converts
+// errors of the KeyFunc or the limiter. By default,
+// ErrNoKey results in InvalidArgument and all other
+// errors in Internal.
+func (l *Limiter) SetErrorHandler(h ErrorHandler) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onError = h
+}
+
+// UnaryServerInterceptor returns an interceptor
+// which limits unary calls.
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := l.reserve(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor
+// which limits streaming calls.
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := l.reserve(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		l.mu.RLock()
+		perMessage := l.perMessage
+		l.mu.RUnlock()
+
+		ws := &stream{ServerStream: ss, ctx: ctx}
+		if perMessage {
+			ws.l, ws.method = l, info.FullMethod
+		}
+
+		return handler(srv, ws)
+	}
+}
+
+// reserve takes a token for a call to method and
+// returns the context of the call containing the
+// Reservation. If the call is denied, the error
+// to return is returned.
+func (l *Limiter) reserve(ctx context.Context, method string) (context.Context, error) {
+	limiter, key, err := l.resolve(ctx, method)
+	if err != nil || limiter == nil {
+		return ctx, err
+	}
+
+	ok, res, err := limiter.ReserveNContext(ctx, key, 1)
+	if err != nil {
+		return ctx, l.handleError(ctx, err)
+	}
+
+	if !ok {
+		return ctx, DeniedError(res, l.now())
+	}
+
+	return context.WithValue(ctx, reservationKey, res), nil
+}
+
+// resolve returns the limiter and the bucket key
+// of a call to method. If the call is not limited,
+// a nil limiter is returned.
+func (l *Limiter) resolve(ctx context.Context, method string) (KeyedReserver, string, error) {
+	l.mu.RLock()
+	limiter, custom := l.methods[method]
+	l.mu.RUnlock()
+
+	if !custom {
+		limiter = l.limiter
+	}
+	if limiter == nil {
+		return nil, "", nil
+	}
+
+	key, err := l.keyFunc(ctx, method)
+	if err != nil {
+		return nil, "", l.handleError(ctx, err)
+	}
+	if custom {
+		key = method + "|" + key
+	}
+
+	return limiter, key, nil
+}
+
+func (l *Limiter) handleError(ctx context.Context, err error) error {
+	l.mu.RLock()
+	h := l.onError
+	l.mu.RUnlock()
+	return h(ctx, err)
+}
+
+// stream wraps a ServerStream to pass the
+// context containing the Reservation and to
+// limit received messages.
+type stream struct {
+	grpc.ServerStream
+
+	ctx    context.Context
+	l      *Limiter
+	method string
+}
+
+func (s *stream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *stream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil || s.l == nil {
+		return err
+	}
+
+	ctx, err := s.l.reserve(s.ctx, s.method)
+	if err != nil {
+		return err
+	}
+	s.ctx = ctx
+
+	return nil
+}
+
+func defaultError(_ context.Context, err error) error {
+	if errors.Is(err, ErrNoKey) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, "rate limiter failed")
+}
@@ -0,0 +1,197 @@
+package grpclimit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	checkMethod = "/grpc.health.v1.Health/Check"
+	watchMethod = "/grpc.health.v1.Health/Watch"
+)
+
+// serve starts a health server using the
+// interceptors of l and returns a client.
+func serve(t *testing.T, l *Limiter, opts ...grpc.DialOption) healthpb.HealthClient {
+	return serveWith(t, []grpc.ServerOption{
+		grpc.UnaryInterceptor(l.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(l.StreamServerInterceptor()),
+	}, opts...)
+}
+
+// serveWith starts a health server with the given
+// options and returns a client using dialOpts.
+func serveWith(t *testing.T, opts []grpc.ServerOption, dialOpts ...grpc.DialOption) healthpb.HealthClient {
+	lis := bufconn.Listen(1 << 16)
+	srv := grpc.NewServer(opts...)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	dialOpts = append(dialOpts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient("passthrough:///bufnet", dialOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func withKey(key string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	const burst = 2
+
+	l := New(ratelimit.NewKeyedLimiter(time.Hour, burst), Metadata("x-api-key"))
+	c := serve(t, l)
+
+	for i := 0; i < burst; i++ {
+		if _, err := c.Check(withKey("a"), &healthpb.HealthCheckRequest{}); err != nil {
+			t.Fatalf("call %d should succeed but failed: %v", i, err)
+		}
+	}
+
+	_, err := c.Check(withKey("a"), &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("call should fail with %v but failed with %v", codes.ResourceExhausted, err)
+	}
+	if d, ok := RetryDelay(err); !ok || d <= 59*time.Minute || d > time.Hour {
+		t.Errorf("retry delay should be about an hour but was (%v, %t)", d, ok)
+	}
+
+	if _, err = c.Check(withKey("b"), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Errorf("call of other key should succeed but failed: %v", err)
+	}
+
+	_, err = c.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("call without key should fail with %v but failed with %v", codes.InvalidArgument, err)
+	}
+}
+
+func TestMethodLimiter(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	l := New(ratelimit.NewKeyedLimiterWithStore(store, time.Hour, 1), Metadata("x-api-key"))
+	l.SetMethodLimiter(checkMethod, ratelimit.NewKeyedLimiterWithStore(store, time.Hour, 3))
+	c := serve(t, l)
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Check(withKey("a"), &healthpb.HealthCheckRequest{}); err != nil {
+			t.Fatalf("call %d should succeed but failed: %v", i, err)
+		}
+	}
+
+	// The stream uses the default limiter,
+	// which has its own bucket.
+	for i, want := range []codes.Code{codes.OK, codes.ResourceExhausted} {
+		ctx, cancel := context.WithCancel(withKey("a"))
+		st, err := c.Watch(ctx, &healthpb.HealthCheckRequest{})
+		if err == nil {
+			_, err = st.Recv()
+		}
+		cancel()
+		if status.Code(err) != want {
+			t.Errorf("stream %d should result in %v but got %v", i, want, err)
+		}
+	}
+
+	l.SetMethodLimiter(checkMethod, nil)
+	for i := 0; i < 3; i++ {
+		if _, err := c.Check(withKey("a"), &healthpb.HealthCheckRequest{}); err != nil {
+			t.Fatalf("unlimited call %d should succeed but failed: %v", i, err)
+		}
+	}
+}
+
+// fakeStream is a ServerStream which
+// receives messages endlessly.
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeStream) Context() context.Context { return s.ctx }
+func (s *fakeStream) RecvMsg(any) error        { return nil }
+
+func TestPerMessage(t *testing.T) {
+	now := epoch
+	ts := func() time.Time { return now }
+
+	kl := ratelimit.NewKeyedLimiterWithStore(ratelimit.NewMemoryStoreWithTimeSource(ts), time.Second, 3)
+	l := NewWithTimeSource(ts, kl, Metadata("x-api-key"))
+	l.SetPerMessage(true)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "a"))
+	info := &grpc.StreamServerInfo{FullMethod: "/pkg.Service/Upload", IsClientStream: true}
+
+	received := 0
+	err := l.StreamServerInterceptor()(nil, &fakeStream{ctx: ctx}, info, func(_ any, ss grpc.ServerStream) error {
+		if _, ok := ReservationFromContext(ss.Context()); !ok {
+			t.Error("stream context should contain the reservation")
+		}
+		for {
+			if err := ss.RecvMsg(nil); err != nil {
+				return err
+			}
+			received++
+		}
+	})
+
+	if received != 2 {
+		t.Errorf("%d messages should be received but were %d", 2, received)
+	}
+	if d, ok := RetryDelay(err); !ok || d != time.Second {
+		t.Errorf("stream should fail with a retry delay of %v but failed with %v", time.Second, err)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	storeErr := errors.New("store error")
+	l := New(failingReserver{storeErr}, Method())
+	c := serve(t, l)
+
+	_, err := c.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("call should fail with %v but failed with %v", codes.Internal, err)
+	}
+
+	l.SetErrorHandler(func(ctx context.Context, err error) error {
+		if err != storeErr {
+			t.Errorf("error should be %v but was %v", storeErr, err)
+		}
+		return nil
+	})
+	if _, err = c.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Errorf("call should succeed when the error is handled but failed: %v", err)
+	}
+}
+
+type failingReserver struct {
+	err error
+}
+
+func (r failingReserver) ReserveNContext(context.Context, string, int) (bool, ratelimit.Reservation, error) {
+	return false, ratelimit.Reservation{}, r.err
+}
@@ -0,0 +1,113 @@
+package grpclimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ErrNoKey is returned by a KeyFunc when no
+// key could be extracted from the call.
+var ErrNoKey = errors.New("no key could be extracted from the call")
+
+// KeyFunc extracts the key from the context of a
+// call to the given full method name (like
+// "/package.Service/Method") by which the call
+// is limited.
+type KeyFunc func(ctx context.Context, method string) (string, error)
+
+// PeerIP returns a KeyFunc which uses the IP
+// address of the peer of the call.
+func PeerIP() KeyFunc {
+	return func(ctx context.Context, _ string) (string, error) {
+		p, ok := peer.FromContext(ctx)
+		if !ok || p.Addr == nil {
+			return "", ErrNoKey
+		}
+
+		addr := p.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		if addr == "" {
+			return "", ErrNoKey
+		}
+		return addr, nil
+	}
+}
+
+// Metadata returns a KeyFunc which uses the first
+// value of the incoming metadata with the given
+// name. A "Bearer " prefix of the value is removed,
+// so that it can be used with the "authorization"
+// metadata.
+func Metadata(name string) KeyFunc {
+	return func(ctx context.Context, _ string) (string, error) {
+		vals := metadata.ValueFromIncomingContext(ctx, name)
+		if len(vals) == 0 {
+			return "", ErrNoKey
+		}
+		v := vals[0]
+		if len(v) > 7 && strings.EqualFold(v[:7], "bearer ") {
+			v = v[7:]
+		}
+		if v == "" {
+			return "", ErrNoKey
+		}
+		return v, nil
+	}
+}
+
+// Method returns a KeyFunc which uses the full
+// method name, so that each method has its
+// own bucket.
+func Method() KeyFunc {
+	return func(_ context.Context, method string) (string, error) {
+		return method, nil
+	}
+}
+
+// Join returns a KeyFunc which joins the keys of
+// all given KeyFuncs, for example to limit each
+// peer per method. If any KeyFunc fails, its
+// error is returned.
+func Join(fns ...KeyFunc) KeyFunc {
+	return func(ctx context.Context, method string) (string, error) {
+		keys := make([]string, len(fns))
+		for i, fn := range fns {
+			k, err := fn(ctx, method)
+			if err != nil {
+				return "", err
+			}
+			keys[i] = k
+		}
+		return strings.Join(keys, "|"), nil
+	}
+}
+
+// FirstOf returns a KeyFunc which uses the key of
+// the first given KeyFunc which does not return
+// ErrNoKey, for example an API key and the peer
+// IP for anonymous calls. The keys are prefixed
+// with the index of the KeyFunc, so that keys of
+// different KeyFuncs do not collide.
+func FirstOf(fns ...KeyFunc) KeyFunc {
+	return func(ctx context.Context, method string) (string, error) {
+		for i, fn := range fns {
+			k, err := fn(ctx, method)
+			if err == ErrNoKey {
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d:%s", i, k), nil
+		}
+		return "", ErrNoKey
+	}
+}
@@ -0,0 +1,47 @@
+package grpclimit
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestKeyFuncs(t *testing.T) {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234},
+	})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(
+		"authorization", "Bearer secret",
+		"x-tenant", "acme",
+	))
+	const method = "/pkg.Service/Method"
+
+	cases := []struct {
+		name string
+		fn   KeyFunc
+		ctx  context.Context
+		want string
+		err  error
+	}{
+		{"peer", PeerIP(), ctx, "10.0.0.1", nil},
+		{"no peer", PeerIP(), context.Background(), "", ErrNoKey},
+		{"metadata", Metadata("x-tenant"), ctx, "acme", nil},
+		{"bearer", Metadata("authorization"), ctx, "secret", nil},
+		{"no metadata", Metadata("x-missing"), ctx, "", ErrNoKey},
+		{"method", Method(), ctx, method, nil},
+		{"join", Join(Method(), PeerIP()), ctx, method + "|10.0.0.1", nil},
+		{"join error", Join(Method(), Metadata("x-missing")), ctx, "", ErrNoKey},
+		{"first of", FirstOf(Metadata("x-missing"), PeerIP()), ctx, "1:10.0.0.1", nil},
+		{"first of none", FirstOf(Metadata("x-missing")), ctx, "", ErrNoKey},
+	}
+
+	for _, c := range cases {
+		k, err := c.fn(c.ctx, method)
+		if k != c.want || err != c.err {
+			t.Errorf("%s: should return (%q, %v) but returned (%q, %v)", c.name, c.want, c.err, k, err)
+		}
+	}
+}
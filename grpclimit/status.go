@@ -0,0 +1,54 @@
+package grpclimit
+
+import (
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// DeniedError returns the error for a call which
+// has been denied with the Reservation res at now.
+// It has the code ResourceExhausted and carries a
+// RetryInfo detail with the duration until the
+// next token is generated.
+func DeniedError(res ratelimit.Reservation, now time.Time) error {
+	st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+
+	var delay time.Duration
+	if !res.Reset.IsNil() {
+		delay = res.Reset.Sub(now)
+	}
+	if delay < 0 {
+		delay = 0
+	}
+
+	if ds, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(delay),
+	}); err == nil {
+		st = ds
+	}
+
+	return st.Err()
+}
+
+// RetryDelay returns the retry delay of the
+// RetryInfo detail of err, if err is a status
+// error with the code ResourceExhausted.
+func RetryDelay(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		return 0, false
+	}
+
+	for _, d := range st.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok && ri.RetryDelay != nil {
+			return ri.RetryDelay.AsDuration(), true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,228 @@
+package httplimit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Header names which are evaluated by ClientIP.
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+// ClientIP determines the IP address of the client
+// of a request.
+//
+// The forwarding headers Forwarded, X-Forwarded-For
+// and X-Real-IP are only evaluated when the request
+// has been received from a trusted proxy. Their
+// entries are evaluated from right to left and the
+// first address which is not a trusted proxy is
+// used as client IP, so that clients can not spoof
+// their address by sending these headers.
+//
+// Optionally, addresses can be aggregated by prefix,
+// so that for example all addresses of an IPv6 /64
+// network, which is usually assigned to a single
+// client, share the same bucket.
+type ClientIP struct {
+	trusted    []netip.Prefix
+	headers    []string
+	ipv4Prefix int
+	ipv6Prefix int
+}
+
+// NewClientIP returns a new ClientIP which trusts
+// the forwarding headers of requests received from
+// the given proxies, passed as CIDRs ("10.0.0.0/8")
+// or single addresses ("10.0.0.1").
+func NewClientIP(trustedProxies ...string) (*ClientIP, error) {
+	c := &ClientIP{
+		headers:    []string{HeaderForwarded, HeaderXForwardedFor, HeaderXRealIP},
+		ipv4Prefix: 32,
+		ipv6Prefix: 128,
+	}
+
+	for _, p := range trustedProxies {
+		prefix, err := parsePrefix(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", p, err)
+		}
+		c.trusted = append(c.trusted, prefix)
+	}
+
+	return c, nil
+}
+
+// SetHeaders sets the forwarding headers which are
+// evaluated, in order of precedence. Only the first
+// header present in a request is used. By default,
+// Forwarded, X-Forwarded-For and X-Real-IP are
+// evaluated.
+func (c *ClientIP) SetHeaders(headers ...string) {
+	c.headers = headers
+}
+
+// SetPrefixes sets the prefix lengths by which IPv4
+// and IPv6 addresses are aggregated. For example,
+// SetPrefixes(32, 64) uses the full IPv4 address and
+// the /64 network of IPv6 addresses.
+func (c *ClientIP) SetPrefixes(ipv4, ipv6 int) {
+	c.ipv4Prefix = ipv4
+	c.ipv6Prefix = ipv6
+}
+
+// IP returns the IP address of the client of the
+// request. If the remote address of the request is
+// not a valid IP address, ErrNoKey is returned.
+func (c *ClientIP) IP(r *http.Request) (netip.Addr, error) {
+	peer, err := parseAddr(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, ErrNoKey
+	}
+
+	if !c.isTrusted(peer) {
+		return peer, nil
+	}
+
+	for _, h := range c.headers {
+		chain := forwardedChain(r.Header, h)
+		if len(chain) == 0 {
+			continue
+		}
+
+		client := peer
+		for i := len(chain) - 1; i >= 0; i-- {
+			addr, err := parseAddr(chain[i])
+			if err != nil {
+				break
+			}
+			client = addr
+			if !c.isTrusted(addr) {
+				break
+			}
+		}
+
+		return client, nil
+	}
+
+	return peer, nil
+}
+
+// Key returns the key of the client of the request,
+// which is its IP address or, if aggregation is
+// enabled, the network of its IP address.
+func (c *ClientIP) Key(r *http.Request) (string, error) {
+	addr, err := c.IP(r)
+	if err != nil {
+		return "", err
+	}
+
+	bits := c.ipv6Prefix
+	if addr.Is4() {
+		bits = c.ipv4Prefix
+	}
+
+	if bits > 0 && bits < addr.BitLen() {
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			return "", err
+		}
+		return prefix.String(), nil
+	}
+
+	return addr.String(), nil
+}
+
+// KeyFunc returns Key as KeyFunc.
+func (c *ClientIP) KeyFunc() KeyFunc {
+	return c.Key
+}
+
+func (c *ClientIP) isTrusted(addr netip.Addr) bool {
+	for _, p := range c.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedChain returns the addresses of all
+// hops listed in the given header, from the
+// client to the last proxy.
+func forwardedChain(h http.Header, name string) []string {
+	values := h.Values(name)
+	if len(values) == 0 {
+		return nil
+	}
+
+	var chain []string
+	for _, v := range values {
+		for _, e := range strings.Split(v, ",") {
+			e = strings.TrimSpace(e)
+			if strings.EqualFold(name, HeaderForwarded) {
+				e = forwardedFor(e)
+			}
+			chain = append(chain, e)
+		}
+	}
+
+	return chain
+}
+
+// forwardedFor returns the value of the for
+// parameter of an element of a Forwarded header
+// as defined in RFC 7239.
+func forwardedFor(element string) string {
+	for _, pair := range strings.Split(element, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && strings.EqualFold(k, "for") {
+			return strings.Trim(v, `"`)
+		}
+	}
+	return ""
+}
+
+// parseAddr parses an IP address which may be
+// followed by a port and may be enclosed in
+// square brackets. IPv4-mapped IPv6 addresses
+// are converted to IPv4 and zones are removed.
+func parseAddr(s string) (netip.Addr, error) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	return addr.Unmap().WithZone(""), nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if p.Addr().Is4In6() {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), nil
+	}
+
+	addr, err := parseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,127 @@
+package httplimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewClientIP(t *testing.T) {
+	if _, err := NewClientIP("10.0.0.0/8", "192.0.2.1", "::1", "fd00::/8", "::ffff:10.0.0.0/104"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"invalid", "10.0.0.0/33", ""} {
+		if _, err := NewClientIP(p); err == nil {
+			t.Errorf("NewClientIP(%q) should fail", p)
+		}
+	}
+}
+
+func TestClientIPKey(t *testing.T) {
+	c, err := NewClientIP("10.0.0.0/8", "fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string][]string
+		key     string
+	}{
+		{"IPv4", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"IPv6", "[2001:db8::1]:1234", nil, "2001:db8::1"},
+		{"IPv6 zone", "[fe80::1%eth0]:1234", nil, "fe80::1"},
+		{"IPv4-mapped", "[::ffff:192.0.2.1]:1234", nil, "192.0.2.1"},
+		{"untrusted XFF ignored", "192.0.2.1:1234",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "192.0.2.1"},
+		{"trusted XFF", "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"trusted XFF chain", "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-For": {"203.0.113.7, 198.51.100.1, 10.0.0.2"}}, "198.51.100.1"},
+		{"trusted XFF multiple lines", "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-For": {"203.0.113.7", "198.51.100.1, 10.0.0.2"}}, "198.51.100.1"},
+		{"trusted XFF all trusted", "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"trusted XFF invalid", "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1, unknown, 10.0.0.2"}}, "10.0.0.2"},
+		{"trusted XFF IPv6 with port", "[fd00::1]:1234",
+			map[string][]string{"X-Forwarded-For": {"[2001:db8::7]:4711"}}, "2001:db8::7"},
+		{"trusted Forwarded", "10.0.0.1:1234",
+			map[string][]string{"Forwarded": {`for=198.51.100.1;proto=https, For="[2001:db8:cafe::17]:4711"`}}, "2001:db8:cafe::17"},
+		{"Forwarded precedence", "10.0.0.1:1234",
+			map[string][]string{
+				"Forwarded":       {"for=198.51.100.1"},
+				"X-Forwarded-For": {"198.51.100.2"},
+			}, "198.51.100.1"},
+		{"trusted X-Real-IP", "10.0.0.1:1234",
+			map[string][]string{"X-Real-Ip": {"198.51.100.1"}}, "198.51.100.1"},
+		{"trusted no header", "10.0.0.1:1234", nil, "10.0.0.1"},
+	}
+
+	for _, cs := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = cs.remote
+		for k, vs := range cs.headers {
+			for _, v := range vs {
+				r.Header.Add(k, v)
+			}
+		}
+
+		key, err := c.Key(r)
+		if err != nil {
+			t.Errorf("%s: %s", cs.name, err)
+			continue
+		}
+		if key != cs.key {
+			t.Errorf("%s: key should be %q but was %q", cs.name, cs.key, key)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "invalid"
+	if _, err := c.Key(r); err != ErrNoKey {
+		t.Errorf("error should be %v but was %v", ErrNoKey, err)
+	}
+}
+
+func TestClientIPPrefixes(t *testing.T) {
+	c, _ := NewClientIP()
+	c.SetPrefixes(24, 64)
+
+	cases := map[string]string{
+		"192.0.2.17:1234":             "192.0.2.0/24",
+		"[2001:db8:1:2:3:4:5:6]:1234": "2001:db8:1:2::/64",
+		"[2001:db8:1:2:ffff::1]:1234": "2001:db8:1:2::/64",
+		"[2001:db8:1:3::1]:1234":      "2001:db8:1:3::/64",
+		"[::ffff:192.0.2.200]:1234":   "192.0.2.0/24",
+	}
+
+	for remote, exp := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remote
+
+		key, err := c.KeyFunc()(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != exp {
+			t.Errorf("%s: key should be %q but was %q", remote, exp, key)
+		}
+	}
+}
+
+func TestClientIPSetHeaders(t *testing.T) {
+	c, _ := NewClientIP("10.0.0.1")
+	c.SetHeaders(HeaderXRealIP)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.Header.Set("X-Real-IP", "198.51.100.2")
+
+	if key, _ := c.Key(r); key != "198.51.100.2" {
+		t.Errorf("key should be %q but was %q", "198.51.100.2", key)
+	}
+}
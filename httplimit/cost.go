@@ -0,0 +1,191 @@
+package httplimit
+
+import (
+	"context"
+	"net/http"
+)
+
+// Response describes a completed response
+// for a CostFunc.
+type Response struct {
+	// Status is the status code of the response.
+	Status int
+	// Bytes is the amount of bytes written
+	// to the response body.
+	Bytes int64
+}
+
+// CostFunc returns the total amount of tokens a
+// request costs after its handler has completed.
+type CostFunc func(r *http.Request, res Response) int
+
+// KeyedReturner is implemented by limiters which
+// are able to give back previously taken tokens
+// of a key, like ratelimit.KeyedLimiter.
+type KeyedReturner interface {
+	ReturnNContext(ctx context.Context, key string, n int) error
+}
+
+type costHolder struct {
+	n   int
+	set bool
+}
+
+// SetCost sets the cost of the current request,
+// which is used by ContextCost. It must be called
+// by the handler with the context of the request.
+// False is returned if the request is not limited
+// by a Limiter with a CostFunc.
+func SetCost(ctx context.Context, n int) bool {
+	c, ok := ctx.Value(costKey).(*costHolder)
+	if ok {
+		c.n, c.set = n, true
+	}
+	return ok
+}
+
+// ContextCost returns a CostFunc which uses the cost
+// set by the handler using SetCost. If the handler
+// did not set a cost, fallback is used.
+func ContextCost(fallback int) CostFunc {
+	return func(r *http.Request, _ Response) int {
+		if c, ok := r.Context().Value(costKey).(*costHolder); ok && c.set {
+			return c.n
+		}
+		return fallback
+	}
+}
+
+// BytesCost returns a CostFunc which charges one
+// token per started bytesPerToken bytes written to
+// the response body, but at least one token.
+func BytesCost(bytesPerToken int64) CostFunc {
+	return func(_ *http.Request, res Response) int {
+		if bytesPerToken <= 0 || res.Bytes <= bytesPerToken {
+			return 1
+		}
+		return int((res.Bytes + bytesPerToken - 1) / bytesPerToken)
+	}
+}
+
+// FreeStatus reports whether responses with the
+// given status are not charged by default, which
+// is true for 304 Not Modified and all 5xx
+// server errors.
+func FreeStatus(status int) bool {
+	return status == http.StatusNotModified || status >= 500
+}
+
+// SetCostFunc sets the CostFunc which determines
+// the cost of each request after its handler has
+// completed. If fn is nil, which is the default,
+// each request costs one token.
+//
+// Requests are admitted when at least one token is
+// available, which is taken before the handler is
+// called. Afterwards, the remaining cost is charged.
+// Because the bucket can not go into debt, the
+// charge is capped at the available tokens, which
+// are charged again with the tokens left as long as
+// concurrent requests take some of them in the
+// meantime. When
+// the cost is 0 or the status is free, the token
+// taken on admission is given back, if the limiter
+// implements KeyedReturner. Errors during the
+// charge are ignored, because the response has
+// already been written.
+//
+// The rate limit headers only reflect the state
+// of the bucket on admission.
+func (l *Limiter) SetCostFunc(fn CostFunc) {
+	l.cost = fn
+}
+
+// SetFreeStatus sets the function which reports
+// whether responses with a status are not charged
+// when a CostFunc is set. By default, FreeStatus
+// is used.
+func (l *Limiter) SetFreeStatus(fn func(status int) bool) {
+	l.free = fn
+}
+
+// charge charges the cost of the completed
+// request r, of which one token has been
+// taken on admission.
+func (l *Limiter) charge(r *http.Request, limiter KeyedReserver, key string, res Response) {
+	cost := 0
+	if l.free == nil || !l.free(res.Status) {
+		cost = l.cost(r, res)
+	}
+
+	// The charge must not be aborted when the
+	// client has gone away after the response.
+	ctx := context.WithoutCancel(r.Context())
+
+	switch {
+	case cost < 1:
+		if rt, ok := limiter.(KeyedReturner); ok {
+			rt.ReturnNContext(ctx, key, 1)
+		}
+	case cost > 1:
+		// When the charge is denied, the available tokens
+		// are charged instead. These may have been taken
+		// by concurrent requests in the meantime, so the
+		// charge is retried with the tokens reported by
+		// each denial, which decrease on every retry.
+		for n := cost - 1; n > 0; {
+			ok, rs, err := limiter.ReserveNContext(ctx, key, n)
+			if err != nil || ok || rs.Remaining >= n {
+				return
+			}
+			n = rs.Remaining
+		}
+	}
+}
+
+// costWriter records the status and the amount
+// of bytes written of a response.
+type costWriter struct {
+	http.ResponseWriter
+
+	status int
+	bytes  int64
+}
+
+func (w *costWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *costWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher.
+func (w *costWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying ResponseWriter
+// for http.ResponseController.
+func (w *costWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *costWriter) response() Response {
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return Response{Status: status, Bytes: w.bytes}
+}
@@ -0,0 +1,157 @@
+package httplimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+func TestCostFuncs(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+
+	bc := BytesCost(100)
+	for bytes, want := range map[int64]int{0: 1, 100: 1, 101: 2, 1000: 10} {
+		if c := bc(r, Response{Bytes: bytes}); c != want {
+			t.Errorf("BytesCost for %d bytes should be %d but was %d", bytes, want, c)
+		}
+	}
+
+	if c := ContextCost(3)(r, Response{}); c != 3 {
+		t.Errorf("ContextCost without cost should be %d but was %d", 3, c)
+	}
+	if SetCost(r.Context(), 5) {
+		t.Error("SetCost should return false outside of the middleware")
+	}
+
+	for status, want := range map[int]bool{200: false, 304: true, 404: false, 500: true, 503: true} {
+		if FreeStatus(status) != want {
+			t.Errorf("FreeStatus(%d) should be %t", status, want)
+		}
+	}
+}
+
+func TestMiddlewareCost(t *testing.T) {
+	const burst = 10
+
+	kl := ratelimit.NewKeyedLimiter(time.Hour, burst)
+	l := New(kl, RemoteIP())
+	l.SetCostFunc(ContextCost(1))
+
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/search":
+			if !SetCost(r.Context(), 4) {
+				t.Error("SetCost should return true in the middleware")
+			}
+		case "/cached":
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		case "/free":
+			SetCost(r.Context(), 0)
+		}
+		w.Write([]byte("ok"))
+	}))
+
+	do := func(path string) int {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	steps := []struct {
+		path   string
+		status int
+		tokens int
+	}{
+		{"/", 200, 9},
+		{"/search", 200, 5},
+		{"/cached", 304, 5},
+		{"/fail", 500, 5},
+		{"/free", 200, 5},
+		{"/search", 200, 1},
+		// Only the available token is charged.
+		{"/search", 200, 0},
+		{"/", 429, 0},
+	}
+
+	for _, s := range steps {
+		if status := do(s.path); status != s.status {
+			t.Errorf("%s: status should be %d but was %d", s.path, s.status, status)
+		}
+		if tokens, _ := kl.Tokens("10.0.0.1"); tokens != s.tokens {
+			t.Errorf("%s: tokens should be %d but were %d", s.path, s.tokens, tokens)
+		}
+	}
+}
+
+// scriptedReserver denies reservations with the
+// given remaining tokens until they run out.
+type scriptedReserver struct {
+	remaining []int
+	calls     []int
+}
+
+func (r *scriptedReserver) ReserveNContext(_ context.Context, _ string, n int) (bool, ratelimit.Reservation, error) {
+	r.calls = append(r.calls, n)
+	if len(r.remaining) == 0 {
+		return true, ratelimit.Reservation{}, nil
+	}
+	rem := r.remaining[0]
+	r.remaining = r.remaining[1:]
+	return false, ratelimit.Reservation{Remaining: rem}, nil
+}
+
+func TestChargeConcurrent(t *testing.T) {
+	l := New(nil, RemoteIP())
+	l.SetCostFunc(func(*http.Request, Response) int { return 20 })
+
+	// The available tokens reported by the first denial
+	// are taken by concurrent requests, so the charge
+	// is retried with the tokens of the second denial.
+	rr := &scriptedReserver{remaining: []int{9, 7}}
+	l.charge(httptest.NewRequest("GET", "/", nil), rr, "a", Response{Status: http.StatusOK})
+
+	if len(rr.calls) != 3 || rr.calls[0] != 19 || rr.calls[1] != 9 || rr.calls[2] != 7 {
+		t.Errorf("charges should be %v but were %v", []int{19, 9, 7}, rr.calls)
+	}
+
+	rr = &scriptedReserver{remaining: []int{3, 0}}
+	l.charge(httptest.NewRequest("GET", "/", nil), rr, "a", Response{Status: http.StatusOK})
+
+	if len(rr.calls) != 2 {
+		t.Errorf("charge should stop when no tokens are available but was tried %v", rr.calls)
+	}
+}
+
+func TestMiddlewareBytesCost(t *testing.T) {
+	kl := ratelimit.NewKeyedLimiter(time.Hour, 10)
+	l := New(kl, RemoteIP())
+	l.SetCostFunc(BytesCost(10))
+	l.SetFreeStatus(nil)
+
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(strings.Repeat("x", 25)))
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("response writer should implement http.Flusher")
+		}
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if tokens, _ := kl.Tokens("10.0.0.1"); tokens != 7 {
+		t.Errorf("tokens should be %d but were %d", 7, tokens)
+	}
+}
@@ -0,0 +1,7 @@
+module github.com/zekroTJA/ratelimit/httplimit
+
+go 1.23
+
+require github.com/zekroTJA/ratelimit v0.0.0
+
+replace github.com/zekroTJA/ratelimit => ../
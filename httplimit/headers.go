@@ -0,0 +1,156 @@
+package httplimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+// DefaultPolicyName is the name of the quota
+// policy used in the IETF RateLimit headers
+// when the Decision has no policy name.
+const DefaultPolicyName = "default"
+
+// Decision contains all information about the
+// limiting of a request which is required to
+// write the rate limit headers.
+type Decision struct {
+	// Allowed is true if the request
+	// has been allowed.
+	Allowed bool
+	// Reservation is the reservation
+	// of the request.
+	Reservation ratelimit.Reservation
+	// Policy is the name of the policy which
+	// has been applied, if available.
+	Policy string
+	// Limit is the limit duration after which a
+	// new token is generated, if available.
+	Limit time.Duration
+	// Now is the time of the decision.
+	Now time.Time
+}
+
+// HeaderWriter writes rate limit headers
+// derived from a Decision.
+type HeaderWriter func(h http.Header, d Decision)
+
+// Headers returns a HeaderWriter which
+// applies all given writers.
+func Headers(writers ...HeaderWriter) HeaderWriter {
+	return func(h http.Header, d Decision) {
+		for _, w := range writers {
+			w(h, d)
+		}
+	}
+}
+
+// DefaultHeaders returns the HeaderWriter which is
+// used by Limiter when no other writer was set. It
+// writes the XRateLimitHeaders and the RetryAfter
+// header as delta seconds.
+func DefaultHeaders() HeaderWriter {
+	return Headers(XRateLimitHeaders(), RetryAfter(false))
+}
+
+// XRateLimitHeaders returns a HeaderWriter which
+// writes the widely used (for example by GitHub)
+// headers X-RateLimit-Limit, X-RateLimit-Remaining,
+// X-RateLimit-Used and X-RateLimit-Reset, where
+// Reset is a Unix timestamp in seconds. Reset is 0
+// as long as tokens are remaining.
+//
+// If a policy name is available, it is written as
+// X-RateLimit-Resource.
+func XRateLimitHeaders() HeaderWriter {
+	return func(h http.Header, d Decision) {
+		res := d.Reservation
+		reset := int64(0)
+		if !res.Reset.IsNil() {
+			reset = int64(math.Ceil(float64(res.Reset.UnixNano()) / float64(time.Second)))
+		}
+
+		h.Set("X-RateLimit-Limit", strconv.Itoa(res.Burst))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("X-RateLimit-Used", strconv.Itoa(res.Burst-res.Remaining))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+		if d.Policy != "" {
+			h.Set("X-RateLimit-Resource", d.Policy)
+		}
+	}
+}
+
+// IETFHeaders returns a HeaderWriter which writes
+// the RateLimit and RateLimit-Policy header fields
+// as defined by the IETF draft "RateLimit header
+// fields for HTTP" (draft-ietf-httpapi-ratelimit-headers).
+//
+// The quota of the policy is the burst rate and its
+// window is the time which is required to refill
+// the full bucket. The reset of the RateLimit field
+// is the amount of seconds until the next token
+// is generated, which is 0 as long as tokens are
+// remaining. If the Decision has no limit, the
+// window is omitted.
+//
+//	RateLimit-Policy: "default";q=3;w=30
+//	RateLimit: "default";r=0;t=8
+func IETFHeaders() HeaderWriter {
+	return func(h http.Header, d Decision) {
+		res := d.Reservation
+		name := d.Policy
+		if name == "" {
+			name = DefaultPolicyName
+		}
+		name = strconv.Quote(name)
+
+		policy := name + ";q=" + strconv.Itoa(res.Burst)
+		if d.Limit > 0 {
+			w := deltaSeconds(d.Limit * time.Duration(res.Burst))
+			policy += ";w=" + strconv.FormatInt(w, 10)
+		}
+
+		var t int64
+		if !res.Reset.IsNil() {
+			t = deltaSeconds(res.Reset.Sub(d.Now))
+		}
+
+		h.Set("RateLimit-Policy", policy)
+		h.Set("RateLimit", name+";r="+strconv.Itoa(res.Remaining)+";t="+strconv.FormatInt(t, 10))
+	}
+}
+
+// RetryAfter returns a HeaderWriter which writes
+// the Retry-After header for denied requests. If
+// httpDate is true, the value is written as HTTP
+// date. Otherwise, it is written as delta seconds.
+func RetryAfter(httpDate bool) HeaderWriter {
+	return func(h http.Header, d Decision) {
+		res := d.Reservation
+		if d.Allowed || res.Reset.IsNil() {
+			return
+		}
+
+		if httpDate {
+			// HTTP dates have a resolution of seconds, so
+			// the time is rounded up to not retry too early.
+			t := res.Reset.Add(time.Second - 1).Truncate(time.Second)
+			h.Set("Retry-After", t.UTC().Format(http.TimeFormat))
+			return
+		}
+
+		h.Set("Retry-After", strconv.FormatInt(deltaSeconds(res.Reset.Sub(d.Now)), 10))
+	}
+}
+
+// deltaSeconds returns d in whole seconds,
+// rounded up and never negative.
+func deltaSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,112 @@
+package httplimit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestHeaderWriters(t *testing.T) {
+	allowed := Decision{
+		Allowed:     true,
+		Reservation: ratelimit.NewReservation(3, 2, time.Time{}),
+		Limit:       10 * time.Second,
+		Now:         epoch,
+	}
+	denied := Decision{
+		Allowed:     false,
+		Reservation: ratelimit.NewReservation(3, 0, epoch.Add(7500*time.Millisecond)),
+		Policy:      "api",
+		Limit:       10 * time.Second,
+		Now:         epoch,
+	}
+
+	cases := []struct {
+		name   string
+		writer HeaderWriter
+		d      Decision
+		want   map[string]string
+	}{
+		{"x-ratelimit allowed", XRateLimitHeaders(), allowed, map[string]string{
+			"X-RateLimit-Limit":     "3",
+			"X-RateLimit-Remaining": "2",
+			"X-RateLimit-Used":      "1",
+			"X-RateLimit-Reset":     "0",
+			"X-RateLimit-Resource":  "",
+		}},
+		{"x-ratelimit denied", XRateLimitHeaders(), denied, map[string]string{
+			"X-RateLimit-Remaining": "0",
+			"X-RateLimit-Used":      "3",
+			"X-RateLimit-Reset":     "1577836808",
+			"X-RateLimit-Resource":  "api",
+		}},
+		{"ietf allowed", IETFHeaders(), allowed, map[string]string{
+			"RateLimit-Policy": `"default";q=3;w=30`,
+			"RateLimit":        `"default";r=2;t=0`,
+		}},
+		{"ietf denied", IETFHeaders(), denied, map[string]string{
+			"RateLimit-Policy": `"api";q=3;w=30`,
+			"RateLimit":        `"api";r=0;t=8`,
+		}},
+		{"ietf without limit", IETFHeaders(), Decision{Reservation: allowed.Reservation}, map[string]string{
+			"RateLimit-Policy": `"default";q=3`,
+		}},
+		{"retry-after allowed", RetryAfter(false), allowed, map[string]string{
+			"Retry-After": "",
+		}},
+		{"retry-after seconds", RetryAfter(false), denied, map[string]string{
+			"Retry-After": "8",
+		}},
+		{"retry-after date", RetryAfter(true), denied, map[string]string{
+			"Retry-After": "Wed, 01 Jan 2020 00:00:08 GMT",
+		}},
+		{"combined", Headers(IETFHeaders(), RetryAfter(false)), denied, map[string]string{
+			"RateLimit":   `"api";r=0;t=8`,
+			"Retry-After": "8",
+		}},
+	}
+
+	for _, c := range cases {
+		h := http.Header{}
+		c.writer(h, c.d)
+		for k, want := range c.want {
+			if v := h.Get(k); v != want {
+				t.Errorf("%s: %s should be %q but was %q", c.name, k, want, v)
+			}
+		}
+	}
+}
+
+func TestMiddlewareHeaderWriter(t *testing.T) {
+	now := epoch
+	kl := ratelimit.NewKeyedLimiterWithStore(
+		ratelimit.NewMemoryStoreWithTimeSource(func() time.Time { return now }), time.Second, 1)
+
+	l := NewWithTimeSource(func() time.Time { return now }, kl, RemoteIP())
+	l.SetHeaderWriter(Headers(IETFHeaders(), RetryAfter(false)))
+	h := l.Middleware(http.NotFoundHandler())
+
+	serve(h, "10.0.0.1:1234")
+	rec := serve(h, "10.0.0.1:1234")
+	if v := rec.Header().Get("RateLimit"); v != `"default";r=0;t=1` {
+		t.Errorf("RateLimit should be %q but was %q", `"default";r=0;t=1`, v)
+	}
+	if v := rec.Header().Get("RateLimit-Policy"); v != `"default";q=1;w=1` {
+		t.Errorf("RateLimit-Policy should be %q but was %q", `"default";q=1;w=1`, v)
+	}
+	if v := rec.Header().Get("Retry-After"); v != "1" {
+		t.Errorf("Retry-After should be %q but was %q", "1", v)
+	}
+	if v := rec.Header().Get("X-RateLimit-Limit"); v != "" {
+		t.Errorf("X-RateLimit-Limit should not be set but was %q", v)
+	}
+
+	l.SetHeaderWriter(nil)
+	if rec = serve(h, "10.0.0.1:1234"); rec.Header().Get("RateLimit") != "" {
+		t.Errorf("no rate limit headers should be set but got %v", rec.Header())
+	}
+}
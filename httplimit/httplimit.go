@@ -0,0 +1,218 @@
+// Package httplimit provides a net/http middleware
+// which limits requests using a keyed limiter.
+//
+// For each request, a key (like the remote IP, an
+// API key or the authenticated user) is extracted
+// using a KeyFunc. If the bucket of the key has no
+// tokens left, the request is denied with the status
+// 429 Too Many Requests. The state of the bucket is
+// attached to the response as headers.
+package httplimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+// KeyedReserver is implemented by limiters which
+// manage a bucket per key, like
+// ratelimit.KeyedLimiter.
+type KeyedReserver interface {
+	ReserveNContext(ctx context.Context, key string, n int) (bool, ratelimit.Reservation, error)
+}
+
+// ErrorHandler handles errors of the KeyFunc
+// or the limiter.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+type contextKey int
+
+const (
+	reservationKey contextKey = iota
+	costKey
+	patternKey
+)
+
+// ReservationFromContext returns the Reservation
+// of the current request, if available.
+func ReservationFromContext(ctx context.Context) (ratelimit.Reservation, bool) {
+	res, ok := ctx.Value(reservationKey).(ratelimit.Reservation)
+	return res, ok
+}
+
+// Limiter limits requests using a KeyedReserver
+// and a KeyFunc.
+type Limiter struct {
+	now     ratelimit.TimeSource
+	limiter KeyedReserver
+	router  *Router
+	keyFunc KeyFunc
+
+	headers HeaderWriter
+	cost    CostFunc
+	free    func(status int) bool
+	denied  http.Handler
+	onError ErrorHandler
+}
+
+// NewWithTimeSource returns a new Limiter with the
+// given TimeSource which limits requests using the
+// given limiter per key extracted by keyFunc.
+func NewWithTimeSource(timeSource ratelimit.TimeSource, limiter KeyedReserver, keyFunc KeyFunc) *Limiter {
+	return &Limiter{
+		now:     timeSource,
+		limiter: limiter,
+		keyFunc: keyFunc,
+		headers: DefaultHeaders(),
+		free:    FreeStatus,
+		denied:  http.HandlerFunc(defaultDenied),
+		onError: defaultError,
+	}
+}
+
+// New returns a new Limiter which limits requests
+// using the given limiter per key extracted by
+// keyFunc.
+func New(limiter KeyedReserver, keyFunc KeyFunc) *Limiter {
+	return NewWithTimeSource(time.Now, limiter, keyFunc)
+}
+
+// NewRoutedWithTimeSource returns a new Limiter with
+// the given TimeSource which limits requests using
+// the policies of router. The key of the request is
+// extracted by keyFunc, unless the matched policy
+// has its own KeyFunc.
+func NewRoutedWithTimeSource(timeSource ratelimit.TimeSource, router *Router, keyFunc KeyFunc) *Limiter {
+	l := NewWithTimeSource(timeSource, nil, keyFunc)
+	l.router = router
+	return l
+}
+
+// NewRouted returns a new Limiter which limits
+// requests using the policies of router. The key
+// of the request is extracted by keyFunc, unless
+// the matched policy has its own KeyFunc.
+func NewRouted(router *Router, keyFunc KeyFunc) *Limiter {
+	return NewRoutedWithTimeSource(time.Now, router, keyFunc)
+}
+
+// SetHeaderWriter sets the HeaderWriter which
+// writes the rate limit headers of each response.
+// By default, DefaultHeaders is used. If w is
+// nil, no headers are written.
+func (l *Limiter) SetHeaderWriter(w HeaderWriter) {
+	l.headers = w
+}
+
+// SetDeniedHandler sets the handler which writes
+// the response when a request is denied. The
+// rate limit headers are already set and the
+// Reservation is available using
+// ReservationFromContext. The handler must write
+// the status code itself.
+//
+// By default, the status 429 Too Many Requests
+// is written with its status text as body.
+func (l *Limiter) SetDeniedHandler(h http.Handler) {
+	l.denied = h
+}
+
+// SetErrorHandler sets the handler which writes
+// the response when the KeyFunc or the limiter
+// fails. By default, the status 400 Bad Request
+// is written for ErrNoKey and 500 Internal Server
+// Error for all other errors.
+func (l *Limiter) SetErrorHandler(h ErrorHandler) {
+	l.onError = h
+}
+
+// Middleware returns a handler which limits the
+// requests before they are passed to next.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter, keyFunc := l.limiter, l.keyFunc
+
+		var (
+			policy  Policy
+			pattern string
+		)
+		if l.router != nil {
+			var matched bool
+			if policy, pattern, matched = l.router.Match(r); !matched {
+				next.ServeHTTP(w, r)
+				return
+			}
+			limiter = policy.Limiter
+			if policy.KeyFunc != nil {
+				keyFunc = policy.KeyFunc
+			}
+			r = r.WithContext(context.WithValue(r.Context(), patternKey, pattern))
+		}
+
+		key, err := keyFunc(r)
+		if err != nil {
+			l.onError(w, r, err)
+			return
+		}
+		if l.router != nil {
+			key = policy.bucketKey(pattern, key)
+		}
+
+		ok, res, err := limiter.ReserveNContext(r.Context(), key, 1)
+		if err != nil {
+			l.onError(w, r, err)
+			return
+		}
+
+		if l.headers != nil {
+			l.headers(w.Header(), Decision{
+				Allowed:     ok,
+				Reservation: res,
+				Policy:      policy.Name,
+				Limit:       limitOf(limiter),
+				Now:         l.now(),
+			})
+		}
+		r = r.WithContext(context.WithValue(r.Context(), reservationKey, res))
+
+		if !ok {
+			l.denied.ServeHTTP(w, r)
+			return
+		}
+
+		if l.cost == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &costWriter{ResponseWriter: w}
+		r = r.WithContext(context.WithValue(r.Context(), costKey, &costHolder{}))
+		next.ServeHTTP(cw, r)
+		l.charge(r, limiter, key, cw.response())
+	})
+}
+
+// limitOf returns the limit duration of the
+// limiter, if it exposes it.
+func limitOf(limiter KeyedReserver) time.Duration {
+	if l, ok := limiter.(interface{ Limit() time.Duration }); ok {
+		return l.Limit()
+	}
+	return 0
+}
+
+func defaultDenied(w http.ResponseWriter, _ *http.Request) {
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+func defaultError(w http.ResponseWriter, _ *http.Request, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, ErrNoKey) {
+		status = http.StatusBadRequest
+	}
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,139 @@
+package httplimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+type failingReserver struct{}
+
+func (failingReserver) ReserveNContext(context.Context, string, int) (bool, ratelimit.Reservation, error) {
+	return false, ratelimit.Reservation{}, errors.New("store error")
+}
+
+func serve(h http.Handler, remoteAddr string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestMiddleware(t *testing.T) {
+	const burst = 2
+
+	kl := ratelimit.NewKeyedLimiter(time.Hour, burst)
+
+	var got ratelimit.Reservation
+	h := New(kl, RemoteIP()).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = ReservationFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < burst; i++ {
+		rec := serve(h, "10.0.0.1:1234")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status should be %d but was %d", http.StatusOK, rec.Code)
+		}
+		if v := rec.Header().Get("X-RateLimit-Limit"); v != "2" {
+			t.Errorf("X-RateLimit-Limit should be %q but was %q", "2", v)
+		}
+		if got.Remaining != burst-i-1 {
+			t.Errorf("reservation in context should have %d remaining but had %d", burst-i-1, got.Remaining)
+		}
+	}
+
+	rec := serve(h, "10.0.0.1:4321")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status should be %d but was %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if v := rec.Header().Get("X-RateLimit-Remaining"); v != "0" {
+		t.Errorf("X-RateLimit-Remaining should be %q but was %q", "0", v)
+	}
+	if v := rec.Header().Get("X-RateLimit-Reset"); v == "0" || v == "" {
+		t.Errorf("X-RateLimit-Reset should be set but was %q", v)
+	}
+
+	if rec = serve(h, "10.0.0.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("other client: status should be %d but was %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestMiddlewareDeniedHandler(t *testing.T) {
+	l := New(ratelimit.NewKeyedLimiter(time.Hour, 1), RemoteIP())
+	l.SetDeniedHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, _ := ReservationFromContext(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"burst":` + strconv.Itoa(res.Burst) + `}`))
+	}))
+	h := l.Middleware(http.NotFoundHandler())
+
+	serve(h, "10.0.0.1:1234")
+	rec := serve(h, "10.0.0.1:1234")
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status should be %d but was %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if b := rec.Body.String(); b != `{"burst":1}` {
+		t.Errorf("body should be %q but was %q", `{"burst":1}`, b)
+	}
+}
+
+func TestMiddlewareErrors(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("next handler should not be called")
+	})
+
+	h := New(ratelimit.NewKeyedLimiter(time.Hour, 1), Header("X-Client")).Middleware(next)
+	if rec := serve(h, "10.0.0.1:1234"); rec.Code != http.StatusBadRequest {
+		t.Errorf("status should be %d but was %d", http.StatusBadRequest, rec.Code)
+	}
+
+	h = New(failingReserver{}, RemoteIP()).Middleware(next)
+	if rec := serve(h, "10.0.0.1:1234"); rec.Code != http.StatusInternalServerError {
+		t.Errorf("status should be %d but was %d", http.StatusInternalServerError, rec.Code)
+	}
+
+	var called bool
+	l := New(failingReserver{}, RemoteIP())
+	l.SetErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		called = true
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	if rec := serve(l.Middleware(next), "10.0.0.1:1234"); rec.Code != http.StatusServiceUnavailable || !called {
+		t.Errorf("custom error handler should be called")
+	}
+}
+
+func TestMiddlewareRoute(t *testing.T) {
+	kl := ratelimit.NewKeyedLimiter(time.Hour, 1)
+	l := New(kl, Route())
+
+	mux := http.NewServeMux()
+	mux.Handle("/files/{name}", l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	for i, path := range []string{"/files/a", "/files/b", "/files/c"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		want := http.StatusOK
+		if i > 0 {
+			want = http.StatusTooManyRequests
+		}
+		if rec.Code != want {
+			t.Errorf("%s: status should be %d but was %d", path, want, rec.Code)
+		}
+	}
+
+	if n := kl.Store().(*ratelimit.MemoryStore).Len(); n != 1 {
+		t.Errorf("all paths should share one bucket but %d buckets were created", n)
+	}
+}
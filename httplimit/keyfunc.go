@@ -0,0 +1,150 @@
+package httplimit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrNoKey is returned by a KeyFunc when no
+// key could be extracted from the request.
+var ErrNoKey = errors.New("no key could be extracted from the request")
+
+// KeyFunc extracts the key from a request by
+// which the request is limited.
+type KeyFunc func(r *http.Request) (string, error)
+
+// RemoteIP returns a KeyFunc which uses the IP
+// address of the remote address of the request,
+// without its port. Forwarding headers are not
+// evaluated, use ClientIP when the service runs
+// behind a proxy.
+func RemoteIP() KeyFunc {
+	return func(r *http.Request) (string, error) {
+		addr, err := parseAddr(r.RemoteAddr)
+		if err != nil {
+			return "", ErrNoKey
+		}
+		return addr.String(), nil
+	}
+}
+
+// Header returns a KeyFunc which uses the value
+// of the header with the given name.
+func Header(name string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		v := r.Header.Get(name)
+		if v == "" {
+			return "", ErrNoKey
+		}
+		return v, nil
+	}
+}
+
+// APIKey returns a KeyFunc which uses an API key
+// passed either in the header with the given name
+// or, if query is not empty, in the query parameter
+// with the given name. A "Bearer " prefix of the
+// header value is removed.
+func APIKey(header, query string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		v := r.Header.Get(header)
+		if len(v) > 7 && strings.EqualFold(v[:7], "bearer ") {
+			v = v[7:]
+		}
+		if v == "" && query != "" {
+			v = r.URL.Query().Get(query)
+		}
+		if v == "" {
+			return "", ErrNoKey
+		}
+		return v, nil
+	}
+}
+
+// ContextValue returns a KeyFunc which uses the
+// value of the request context with the given key,
+// like an authenticated user set by a previous
+// middleware. The value must be a string or
+// implement fmt.Stringer.
+func ContextValue(key any) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		switch v := r.Context().Value(key).(type) {
+		case string:
+			if v != "" {
+				return v, nil
+			}
+		case fmt.Stringer:
+			if s := v.String(); s != "" {
+				return s, nil
+			}
+		}
+		return "", ErrNoKey
+	}
+}
+
+// Route returns a KeyFunc which uses the method and
+// the matched route pattern of the request, so that
+// each route has its own bucket. The pattern is the
+// one matched by the Router of the Limiter or, when
+// the middleware wraps a handler of an
+// http.ServeMux, the one matched by the ServeMux.
+// Requests matching the default policy of a Router
+// share one bucket.
+//
+// The raw path is never used, so that clients can
+// not create buckets by requesting arbitrary paths.
+// If no pattern is known, ErrNoKey is returned.
+func Route() KeyFunc {
+	return func(r *http.Request) (string, error) {
+		pattern, ok := r.Context().Value(patternKey).(string)
+		if !ok {
+			if r.Pattern == "" {
+				return "", ErrNoKey
+			}
+			pattern = r.Pattern
+		}
+		return r.Method + " " + pattern, nil
+	}
+}
+
+// Join returns a KeyFunc which joins the keys of
+// all given KeyFuncs, for example to limit each
+// client per route. If any KeyFunc fails, its
+// error is returned.
+func Join(fns ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		keys := make([]string, len(fns))
+		for i, fn := range fns {
+			k, err := fn(r)
+			if err != nil {
+				return "", err
+			}
+			keys[i] = k
+		}
+		return strings.Join(keys, "|"), nil
+	}
+}
+
+// FirstOf returns a KeyFunc which uses the key of
+// the first given KeyFunc which does not return
+// ErrNoKey, for example the authenticated user and
+// the remote IP for anonymous requests. The keys
+// are prefixed with the index of the KeyFunc, so
+// that keys of different KeyFuncs do not collide.
+func FirstOf(fns ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		for i, fn := range fns {
+			k, err := fn(r)
+			if err == ErrNoKey {
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d:%s", i, k), nil
+		}
+		return "", ErrNoKey
+	}
+}
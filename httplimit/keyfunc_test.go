@@ -0,0 +1,68 @@
+package httplimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type stringer string
+
+func (s stringer) String() string { return string(s) }
+
+type ctxKey struct{}
+
+func TestKeyFuncs(t *testing.T) {
+	newReq := func(mod func(r *http.Request)) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/api/test?key=q", nil)
+		if mod != nil {
+			mod(r)
+		}
+		return r
+	}
+
+	cases := []struct {
+		name string
+		fn   KeyFunc
+		req  *http.Request
+		key  string
+		err  error
+	}{
+		{"RemoteIP IPv4", RemoteIP(), newReq(func(r *http.Request) { r.RemoteAddr = "10.0.0.1:1234" }), "10.0.0.1", nil},
+		{"RemoteIP IPv6", RemoteIP(), newReq(func(r *http.Request) { r.RemoteAddr = "[::1]:1234" }), "::1", nil},
+		{"RemoteIP no port", RemoteIP(), newReq(func(r *http.Request) { r.RemoteAddr = "10.0.0.1" }), "10.0.0.1", nil},
+		{"RemoteIP invalid", RemoteIP(), newReq(func(r *http.Request) { r.RemoteAddr = "invalid" }), "", ErrNoKey},
+		{"Header", Header("X-Client"), newReq(func(r *http.Request) { r.Header.Set("X-Client", "c") }), "c", nil},
+		{"Header missing", Header("X-Client"), newReq(nil), "", ErrNoKey},
+		{"APIKey header", APIKey("Authorization", "key"), newReq(func(r *http.Request) { r.Header.Set("Authorization", "Bearer k") }), "k", nil},
+		{"APIKey query", APIKey("Authorization", "key"), newReq(nil), "q", nil},
+		{"APIKey missing", APIKey("Authorization", ""), newReq(nil), "", ErrNoKey},
+		{"ContextValue string", ContextValue(ctxKey{}), newReq(func(r *http.Request) {
+			*r = *r.WithContext(context.WithValue(r.Context(), ctxKey{}, "user"))
+		}), "user", nil},
+		{"ContextValue Stringer", ContextValue(ctxKey{}), newReq(func(r *http.Request) {
+			*r = *r.WithContext(context.WithValue(r.Context(), ctxKey{}, stringer("user")))
+		}), "user", nil},
+		{"ContextValue missing", ContextValue(ctxKey{}), newReq(nil), "", ErrNoKey},
+		{"Route", Route(), newReq(func(r *http.Request) { r.Pattern = "/api/{name}" }), "GET /api/{name}", nil},
+		{"Route router", Route(), newReq(func(r *http.Request) {
+			*r = *r.WithContext(context.WithValue(r.Context(), patternKey, "/api/"))
+		}), "GET /api/", nil},
+		{"Route unmatched", Route(), newReq(nil), "", ErrNoKey},
+		{"Join", Join(Route(), RemoteIP()), newReq(func(r *http.Request) { r.Pattern = "/api/{name}" }), "GET /api/{name}|192.0.2.1", nil},
+		{"Join error", Join(RemoteIP(), Header("X-Client")), newReq(nil), "", ErrNoKey},
+		{"FirstOf", FirstOf(Header("X-Client"), RemoteIP()), newReq(nil), "1:192.0.2.1", nil},
+		{"FirstOf none", FirstOf(Header("X-Client")), newReq(nil), "", ErrNoKey},
+	}
+
+	for _, c := range cases {
+		key, err := c.fn(c.req)
+		if err != c.err {
+			t.Errorf("%s: error should be %v but was %v", c.name, c.err, err)
+		}
+		if key != c.key {
+			t.Errorf("%s: key should be %q but was %q", c.name, c.key, key)
+		}
+	}
+}
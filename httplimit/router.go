@@ -0,0 +1,104 @@
+package httplimit
+
+import (
+	"net/http"
+	"sync"
+)
+
+// A Policy is a named set of limits which
+// is applied to the requests of one or more
+// routes of a Router.
+type Policy struct {
+	// Name identifies the policy. It is part of the
+	// bucket keys and reported in the rate limit
+	// headers, so it must be unique per Router.
+	Name string
+	// Limiter limits the requests matching
+	// the policy.
+	Limiter KeyedReserver
+	// KeyFunc extracts the key of the request. If
+	// nil, the KeyFunc of the Limiter is used.
+	KeyFunc KeyFunc
+	// PerRoute gives each route pattern using the
+	// policy its own bucket. Otherwise, all routes
+	// using the policy share the same bucket per key.
+	PerRoute bool
+}
+
+// A Router maps routes to policies. Routes are
+// matched by patterns like the ones of
+// http.ServeMux, for example "POST /login",
+// "GET /users/{id}" or "/static/".
+//
+// Requests which match no route are limited by the
+// default policy, if one is set. Otherwise, they
+// are not limited.
+type Router struct {
+	mu       sync.RWMutex
+	mux      *http.ServeMux
+	routes   map[string]Policy
+	fallback *Policy
+}
+
+// NewRouter returns a new and empty Router.
+func NewRouter() *Router {
+	return &Router{
+		mux:    http.NewServeMux(),
+		routes: make(map[string]Policy),
+	}
+}
+
+// Handle applies the policy p to all requests
+// matching the given pattern. Like
+// http.ServeMux#Handle, it panics if the pattern
+// is invalid or conflicts with another pattern.
+func (rt *Router) Handle(pattern string, p Policy) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.mux.Handle(pattern, http.NotFoundHandler())
+	rt.routes[pattern] = p
+}
+
+// SetDefault sets the policy which is applied to
+// requests not matching any route.
+func (rt *Router) SetDefault(p Policy) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.fallback = &p
+}
+
+// Match returns the policy for the request and the
+// pattern of the matched route. If the request
+// matches no route, the default policy and an empty
+// pattern are returned. If no default policy is set,
+// false is returned.
+func (rt *Router) Match(r *http.Request) (Policy, string, bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	// ServeMux returns an empty pattern for requests
+	// which match no route as well as for requests
+	// which would be redirected or answered with
+	// 405 Method Not Allowed.
+	_, pattern := rt.mux.Handler(r)
+	if p, ok := rt.routes[pattern]; ok && pattern != "" {
+		return p, pattern, true
+	}
+
+	if rt.fallback != nil {
+		return *rt.fallback, "", true
+	}
+
+	return Policy{}, "", false
+}
+
+// bucketKey returns the key of the bucket for
+// key on the route pattern using policy p.
+func (p Policy) bucketKey(pattern, key string) string {
+	if p.PerRoute && pattern != "" {
+		return p.Name + "|" + pattern + "|" + key
+	}
+	return p.Name + "|" + key
+}
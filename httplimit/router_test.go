@@ -0,0 +1,129 @@
+package httplimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+func TestRouterMatch(t *testing.T) {
+	rt := NewRouter()
+	rt.Handle("POST /login", Policy{Name: "login"})
+	rt.Handle("GET /users/{id}", Policy{Name: "users"})
+	rt.Handle("/static/", Policy{Name: "static"})
+
+	cases := []struct {
+		method, path string
+		policy       string
+		pattern      string
+		ok           bool
+	}{
+		{"POST", "/login", "login", "POST /login", true},
+		{"GET", "/login", "", "", false},
+		{"GET", "/users/42", "users", "GET /users/{id}", true},
+		{"HEAD", "/users/42", "users", "GET /users/{id}", true},
+		{"GET", "/static/css/main.css", "static", "/static/", true},
+		{"GET", "/status", "", "", false},
+	}
+
+	for _, c := range cases {
+		p, pattern, ok := rt.Match(httptest.NewRequest(c.method, c.path, nil))
+		if ok != c.ok || p.Name != c.policy || pattern != c.pattern {
+			t.Errorf("%s %s: match should be (%q, %q, %t) but was (%q, %q, %t)",
+				c.method, c.path, c.policy, c.pattern, c.ok, p.Name, pattern, ok)
+		}
+	}
+
+	rt.SetDefault(Policy{Name: "default"})
+	if p, pattern, ok := rt.Match(httptest.NewRequest("GET", "/status", nil)); !ok || p.Name != "default" || pattern != "" {
+		t.Errorf("unmatched request should use the default policy but got (%q, %q, %t)", p.Name, pattern, ok)
+	}
+}
+
+func TestRoutedMiddleware(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+
+	rt := NewRouter()
+	login := Policy{
+		Name:    "login",
+		Limiter: ratelimit.NewKeyedLimiterWithStore(store, time.Hour, 1),
+	}
+	rt.Handle("POST /login", login)
+	rt.Handle("POST /password-reset", login)
+
+	users := Policy{
+		Name:     "users",
+		Limiter:  ratelimit.NewKeyedLimiterWithStore(store, time.Hour, 1),
+		PerRoute: true,
+	}
+	rt.Handle("GET /users/{id}", users)
+	rt.Handle("GET /groups/{id}", users)
+
+	h := NewRouted(rt, RemoteIP()).Middleware(http.NotFoundHandler())
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	if rec := do("POST", "/login"); rec.Code != http.StatusNotFound {
+		t.Errorf("login should pass but got %d", rec.Code)
+	} else if v := rec.Header().Get("X-RateLimit-Resource"); v != "login" {
+		t.Errorf("X-RateLimit-Resource should be %q but was %q", "login", v)
+	}
+	if rec := do("POST", "/password-reset"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("shared policy should be limited but got %d", rec.Code)
+	}
+
+	if rec := do("GET", "/users/1"); rec.Code != http.StatusNotFound {
+		t.Errorf("users should pass but got %d", rec.Code)
+	}
+	if rec := do("GET", "/users/2"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second request to the same route should be limited but got %d", rec.Code)
+	}
+	if rec := do("GET", "/groups/1"); rec.Code != http.StatusNotFound {
+		t.Errorf("per-route policy should have its own bucket but got %d", rec.Code)
+	}
+
+	for i := 0; i < 3; i++ {
+		if rec := do("GET", "/status"); rec.Code != http.StatusNotFound || rec.Header().Get("X-RateLimit-Limit") != "" {
+			t.Errorf("unmatched route should not be limited but got %d", rec.Code)
+		}
+	}
+}
+
+func TestRoutedMiddlewarePolicyKeyFunc(t *testing.T) {
+	rt := NewRouter()
+	rt.SetDefault(Policy{
+		Name:    "api",
+		Limiter: ratelimit.NewKeyedLimiter(time.Hour, 1),
+		KeyFunc: Header("X-API-Key"),
+	})
+
+	h := NewRouted(rt, RemoteIP()).Middleware(http.NotFoundHandler())
+
+	r := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("missing key should result in %d but got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	for i, want := range []int{http.StatusNotFound, http.StatusTooManyRequests} {
+		r = httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-API-Key", "secret")
+		r.RemoteAddr = "10.0.0." + strconv.Itoa(i+1) + ":1234"
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != want {
+			t.Errorf("request %d: status should be %d but was %d", i, want, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package httplimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+// DefaultMaxRetries is the default amount of
+// retries of a Transport for requests which are
+// answered with 429 Too Many Requests.
+const DefaultMaxRetries = 3
+
+// DefaultMaxDelay is the default maximum delay until
+// the next token reported by a server which is
+// adopted by a Transport.
+const DefaultMaxDelay = time.Hour
+
+// A Transport is an http.RoundTripper which limits
+// outbound requests using a ratelimit.Limiter per
+// host.
+//
+// The rate limit headers of the responses, like
+// X-RateLimit-Remaining, X-RateLimit-Reset, the
+// IETF RateLimit field and Retry-After, are used
+// to synchronize the local bucket of the host with
+// the state of the server. The responses may only
+// make the local bucket stricter, so a server with
+// a larger quota does not disable local throttling.
+//
+// Requests which are answered with 429 Too Many
+// Requests are retried after the time reported by
+// the server, as long as the retry is possible
+// before the deadline of the request context.
+// Otherwise, the 429 response is returned.
+type Transport struct {
+	mu  sync.Mutex
+	now ratelimit.TimeSource
+
+	base  http.RoundTripper
+	limit time.Duration
+	burst int
+
+	maxRetries int
+	maxDelay   time.Duration
+	limiters   map[string]*ratelimit.Limiter
+}
+
+var _ http.RoundTripper = (*Transport)(nil)
+
+// NewTransportWithTimeSource returns a new Transport
+// with the given TimeSource which passes requests to
+// base. Each host has its own Limiter with a burst
+// rate of b and a limit time of l until a new token
+// will be generated. If base is nil,
+// http.DefaultTransport is used.
+func NewTransportWithTimeSource(timeSource ratelimit.TimeSource, base http.RoundTripper, l time.Duration, b int) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &Transport{
+		now:        timeSource,
+		base:       base,
+		limit:      l,
+		burst:      b,
+		maxRetries: DefaultMaxRetries,
+		maxDelay:   DefaultMaxDelay,
+		limiters:   make(map[string]*ratelimit.Limiter),
+	}
+}
+
+// NewTransport returns a new Transport which passes
+// requests to base. Each host has its own Limiter
+// with a burst rate of b and a limit time of l until
+// a new token will be generated. If base is nil,
+// http.DefaultTransport is used.
+func NewTransport(base http.RoundTripper, l time.Duration, b int) *Transport {
+	return NewTransportWithTimeSource(time.Now, base, l, b)
+}
+
+// SetMaxRetries sets the maximum amount of retries
+// of requests which are answered with 429 Too Many
+// Requests. If n is 0, requests are not retried.
+func (t *Transport) SetMaxRetries(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maxRetries = n
+}
+
+// SetMaxDelay sets the maximum delay until the next
+// token reported by a server which is adopted. Reset
+// times and Retry-After values further in the future
+// are ignored as invalid, so that a misbehaving
+// server can not block requests indefinitely.
+func (t *Transport) SetMaxDelay(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maxDelay = d
+}
+
+// Limiter returns the Limiter of the given host.
+func (t *Transport) Limiter(host string) *ratelimit.Limiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.limiters[host]
+	if !ok {
+		l = ratelimit.NewLimiterWithTimeSource(t.now, t.limit, t.burst)
+		t.limiters[host] = l
+	}
+
+	return l
+}
+
+// RoundTrip implements http.RoundTripper. It waits
+// until the Limiter of the host of the request has
+// a token available. If the token would not be
+// available until the deadline of the request
+// context, ratelimit.ErrExceedsDeadline is returned.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	l := t.Limiter(req.URL.Host)
+
+	t.mu.Lock()
+	retries, maxDelay := t.maxRetries, t.maxDelay
+	t.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if err := l.Wait(ctx); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		state, ok := parseState(resp.Header, t.now(), maxDelay)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			state.remaining = 0
+			if !ok || state.next.IsZero() {
+				state.next = t.now().Add(l.Limit())
+			}
+			ok = true
+		}
+		if ok {
+			l.Sync(state.remaining, state.next)
+		}
+
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= retries || !canRetry(ctx, req, state.next) {
+			return resp, nil
+		}
+
+		next, err := rewind(req)
+		if err != nil {
+			return resp, nil
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		req = next
+	}
+}
+
+// canRetry returns true if req can be retried
+// at retryAt before the deadline of ctx.
+func canRetry(ctx context.Context, req *http.Request, retryAt time.Time) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	dl, ok := ctx.Deadline()
+	return !ok || !retryAt.After(dl)
+}
+
+// rewind returns a copy of req with a fresh body.
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.GetBody == nil {
+		return req, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	next := req.Clone(req.Context())
+	next.Body = body
+	return next, nil
+}
+
+// serverState is the state of the
+// bucket reported by a server.
+type serverState struct {
+	remaining int
+	next      time.Time
+}
+
+var errInvalidHeader = errors.New("invalid rate limit header")
+
+// parseState parses the state of the bucket from the
+// rate limit headers of a response received at now.
+// The IETF RateLimit field takes precedence over the
+// X-RateLimit-* headers. Retry-After overrides the
+// time of the next token. Times of the next token
+// later than maxDelay after now are ignored.
+func parseState(h http.Header, now time.Time, maxDelay time.Duration) (serverState, bool) {
+	var (
+		s  serverState
+		ok bool
+	)
+
+	if v := h.Get("RateLimit"); v != "" {
+		if r, t, err := parseIETF(v); err == nil {
+			s.remaining, ok = r, true
+			if next, err := afterSeconds(int64(t), now, maxDelay); err == nil && r == 0 {
+				s.next = next
+			}
+		}
+	} else if v := h.Get("X-RateLimit-Remaining"); v != "" {
+		if r, err := strconv.Atoi(v); err == nil {
+			s.remaining, ok = r, true
+			if reset, err := parseReset(h.Get("X-RateLimit-Reset"), now, maxDelay); err == nil && r == 0 {
+				s.next = reset
+			}
+		}
+	}
+
+	if v := h.Get("Retry-After"); v != "" {
+		if t, err := parseRetryAfter(v, now, maxDelay); err == nil {
+			s.remaining, s.next, ok = 0, t, true
+		}
+	}
+
+	return s, ok
+}
+
+// parseIETF parses the remaining tokens r and the
+// reset seconds t of the first item of an IETF
+// RateLimit field value like `"default";r=0;t=8`.
+func parseIETF(v string) (r, t int, err error) {
+	item := strings.SplitN(v, ",", 2)[0]
+	found := false
+	for _, param := range strings.Split(item, ";")[1:] {
+		k, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch k {
+		case "r":
+			if r, err = strconv.Atoi(val); err != nil {
+				return 0, 0, err
+			}
+			found = true
+		case "t":
+			if t, err = strconv.Atoi(val); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	if !found {
+		return 0, 0, errInvalidHeader
+	}
+	return r, t, nil
+}
+
+// parseReset parses the value of an X-RateLimit-Reset
+// header. Servers report it as delta seconds or as
+// Unix timestamp in seconds, milliseconds,
+// microseconds or nanoseconds, which are told apart
+// by their magnitude. Values between these ranges and
+// times later than maxDelay after now are invalid. A
+// value of 0 results in a zero time.
+func parseReset(v string, now time.Time, maxDelay time.Duration) (time.Time, error) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var t time.Time
+	switch {
+	case n <= 0:
+		return time.Time{}, nil
+	case n < 1e9:
+		return afterSeconds(n, now, maxDelay)
+	case n < 1e11:
+		t = time.Unix(n, 0)
+	case n >= 1e12 && n < 1e14:
+		t = time.Unix(0, n*int64(time.Millisecond))
+	case n >= 1e15 && n < 1e17:
+		t = time.Unix(0, n*int64(time.Microsecond))
+	case n >= 1e18:
+		t = time.Unix(0, n)
+	default:
+		return time.Time{}, errInvalidHeader
+	}
+
+	if t.Sub(now) > maxDelay {
+		return time.Time{}, errInvalidHeader
+	}
+	return t, nil
+}
+
+// parseRetryAfter parses the value of a Retry-After
+// header as delta seconds or HTTP date. Times later
+// than maxDelay after now are invalid.
+func parseRetryAfter(v string, now time.Time, maxDelay time.Duration) (time.Time, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return afterSeconds(n, now, maxDelay)
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if t.Sub(now) > maxDelay {
+		return time.Time{}, errInvalidHeader
+	}
+	return t, nil
+}
+
+// afterSeconds returns the time n seconds after now.
+// Negative values and values exceeding maxDelay
+// are invalid.
+func afterSeconds(n int64, now time.Time, maxDelay time.Duration) (time.Time, error) {
+	if n < 0 || n > int64(maxDelay/time.Second) {
+		return time.Time{}, errInvalidHeader
+	}
+	return now.Add(time.Duration(n) * time.Second), nil
+}
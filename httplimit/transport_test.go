@@ -0,0 +1,220 @@
+package httplimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+func TestParseState(t *testing.T) {
+	now := epoch
+
+	cases := []struct {
+		name      string
+		header    http.Header
+		remaining int
+		next      time.Time
+		ok        bool
+	}{
+		{"none", http.Header{}, 0, time.Time{}, false},
+		{"x-ratelimit remaining", http.Header{
+			"X-Ratelimit-Remaining": {"4"},
+			"X-Ratelimit-Reset":     {"1577836900"},
+		}, 4, time.Time{}, true},
+		{"x-ratelimit unix seconds", http.Header{
+			"X-Ratelimit-Remaining": {"0"},
+			"X-Ratelimit-Reset":     {"1577836810"},
+		}, 0, now.Add(10 * time.Second), true},
+		{"x-ratelimit unix nanoseconds", http.Header{
+			"X-Ratelimit-Remaining": {"0"},
+			"X-Ratelimit-Reset":     {"1577836800500000000"},
+		}, 0, now.Add(500 * time.Millisecond), true},
+		{"x-ratelimit unix milliseconds", http.Header{
+			"X-Ratelimit-Remaining": {"0"},
+			"X-Ratelimit-Reset":     {"1577836802000"},
+		}, 0, now.Add(2 * time.Second), true},
+		{"x-ratelimit unix microseconds", http.Header{
+			"X-Ratelimit-Remaining": {"0"},
+			"X-Ratelimit-Reset":     {"1577836803000000"},
+		}, 0, now.Add(3 * time.Second), true},
+		{"x-ratelimit reset too late", http.Header{
+			"X-Ratelimit-Remaining": {"0"},
+			"X-Ratelimit-Reset":     {"99999999999"},
+		}, 0, time.Time{}, true},
+		{"x-ratelimit reset out of range", http.Header{
+			"X-Ratelimit-Remaining": {"0"},
+			"X-Ratelimit-Reset":     {"500000000000"},
+		}, 0, time.Time{}, true},
+		{"x-ratelimit delta seconds too late", http.Header{
+			"X-Ratelimit-Remaining": {"0"},
+			"X-Ratelimit-Reset":     {"86400"},
+		}, 0, time.Time{}, true},
+		{"x-ratelimit delta seconds", http.Header{
+			"X-Ratelimit-Remaining": {"0"},
+			"X-Ratelimit-Reset":     {"30"},
+		}, 0, now.Add(30 * time.Second), true},
+		{"ietf", http.Header{
+			"Ratelimit": {`"default";r=0;t=8`},
+		}, 0, now.Add(8 * time.Second), true},
+		{"ietf remaining", http.Header{
+			"Ratelimit": {`"api";r=7;t=0, "burst";r=1;t=1`},
+		}, 7, time.Time{}, true},
+		{"retry-after seconds", http.Header{
+			"X-Ratelimit-Remaining": {"3"},
+			"Retry-After":           {"5"},
+		}, 0, now.Add(5 * time.Second), true},
+		{"retry-after too late", http.Header{
+			"X-Ratelimit-Remaining": {"3"},
+			"Retry-After":           {"999999999999"},
+		}, 3, time.Time{}, true},
+		{"retry-after date too late", http.Header{
+			"Retry-After": {"Fri, 01 Jan 2100 00:00:00 GMT"},
+		}, 0, time.Time{}, false},
+		{"ietf too late", http.Header{
+			"Ratelimit": {`"default";r=0;t=7200`},
+		}, 0, time.Time{}, true},
+		{"retry-after date", http.Header{
+			"Retry-After": {"Wed, 01 Jan 2020 00:01:00 GMT"},
+		}, 0, now.Add(time.Minute), true},
+		{"invalid", http.Header{
+			"X-Ratelimit-Remaining": {"many"},
+			"Ratelimit":             {`"default"`},
+			"Retry-After":           {"soon"},
+		}, 0, time.Time{}, false},
+	}
+
+	for _, c := range cases {
+		s, ok := parseState(c.header, now, DefaultMaxDelay)
+		if ok != c.ok || s.remaining != c.remaining || !s.next.Equal(c.next) {
+			t.Errorf("%s: state should be (%d, %v, %t) but was (%d, %v, %t)",
+				c.name, c.remaining, c.next, c.ok, s.remaining, s.next, ok)
+		}
+	}
+}
+
+func TestTransportSync(t *testing.T) {
+	var remaining int32 = 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&remaining, -1)
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(n)))
+		w.Header().Set("X-RateLimit-Reset", "3600")
+	}))
+	defer srv.Close()
+
+	tr := NewTransport(nil, time.Hour, 5)
+	c := &http.Client{Transport: tr}
+
+	resp, err := c.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	l := tr.Limiter(resp.Request.URL.Host)
+	if tokens := l.Tokens(); tokens != 1 {
+		t.Errorf("tokens should be synchronized to %d but were %d", 1, tokens)
+	}
+
+	if resp, err = c.Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	if _, err = c.Do(req); !errors.Is(err, ratelimit.ErrExceedsDeadline) {
+		t.Errorf("request should fail with %v but got %v", ratelimit.ErrExceedsDeadline, err)
+	}
+}
+
+func TestTransportSyncLargerQuota(t *testing.T) {
+	// A server with a larger quota than the local
+	// limiter must not disable local throttling.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "4999")
+		w.Header().Set("X-RateLimit-Reset", "3600")
+	}))
+	defer srv.Close()
+
+	tr := NewTransport(nil, time.Hour, 2)
+	c := &http.Client{Transport: tr}
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	if _, err := c.Do(req); !errors.Is(err, ratelimit.ErrExceedsDeadline) {
+		t.Errorf("request should fail with %v but got %v", ratelimit.ErrExceedsDeadline, err)
+	}
+}
+
+func TestTransportRetry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil || string(body) != "payload" {
+			t.Errorf("body should be %q but was %q", "payload", body)
+		}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: NewTransport(nil, time.Millisecond, 10)}
+
+	start := time.Now()
+	resp, err := c.Post(srv.URL, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("status should be %d but was %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("server should be called %d times but was called %d times", 2, n)
+	}
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Errorf("retry should wait for Retry-After but took %v", d)
+	}
+
+	// The retry is not possible within the
+	// deadline, so the 429 is returned.
+	atomic.StoreInt32(&calls, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", srv.URL, strings.NewReader("payload"))
+	if resp, err = c.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("status should be %d but was %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("server should be called %d times but was called %d times", 1, n)
+	}
+}
@@ -0,0 +1,154 @@
+// Package iolimit provides io.Reader and io.Writer
+// wrappers which limit the bandwidth of streams
+// by treating each byte as a token of a limiter.
+//
+// A limiter can be shared across many streams to
+// cap their aggregated bandwidth. Because reads and
+// writes are split into chunks of at most the burst
+// rate of the limiter, concurrent streams take
+// turns instead of one stream blocking the others
+// with a large transfer.
+package iolimit
+
+import (
+	"context"
+	"io"
+)
+
+// Limiter is implemented by limiters which
+// are able to block until n tokens are
+// available, like ratelimit.Limiter.
+type Limiter interface {
+	WaitN(ctx context.Context, n int) error
+	Burst() int
+}
+
+// All returns a Limiter which waits for the tokens
+// of all given limiters, for example to combine a
+// per-stream limit with a limit shared by all
+// streams. Its burst rate is the lowest burst
+// rate of the limiters.
+func All(limiters ...Limiter) Limiter {
+	return all(limiters)
+}
+
+type all []Limiter
+
+func (a all) WaitN(ctx context.Context, n int) error {
+	for _, l := range a {
+		if err := l.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a all) Burst() int {
+	b := 0
+	for i, l := range a {
+		if lb := l.Burst(); i == 0 || lb < b {
+			b = lb
+		}
+	}
+	return b
+}
+
+// Reader limits the bandwidth of
+// an underlying io.Reader.
+type Reader struct {
+	ctx context.Context
+	r   io.Reader
+	l   Limiter
+}
+
+// NewReader returns a new Reader which reads from
+// r using the limiter l. Each read waits for the
+// read bytes using ctx. Reads are limited to the
+// burst rate of l.
+func NewReader(ctx context.Context, r io.Reader, l Limiter) *Reader {
+	return &Reader{ctx: ctx, r: r, l: l}
+}
+
+// Read implements io.Reader. The bytes are read from
+// the underlying reader first and the tokens are
+// taken afterwards, so the wait is applied to the
+// following read. If the wait fails, the read bytes
+// are returned together with the error.
+func (r *Reader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if c := chunk(r.l); len(p) > c {
+		p = p[:c]
+	}
+
+	n, err := r.r.Read(p)
+	if n <= 0 {
+		return n, err
+	}
+
+	if werr := r.l.WaitN(r.ctx, n); werr != nil {
+		return n, werr
+	}
+
+	return n, err
+}
+
+// Writer limits the bandwidth of
+// an underlying io.Writer.
+type Writer struct {
+	ctx context.Context
+	w   io.Writer
+	l   Limiter
+}
+
+// NewWriter returns a new Writer which writes to
+// w using the limiter l. Each write waits for the
+// bytes to write using ctx. Writes are split into
+// chunks of at most the burst rate of l.
+func NewWriter(ctx context.Context, w io.Writer, l Limiter) *Writer {
+	return &Writer{ctx: ctx, w: w, l: l}
+}
+
+// Write implements io.Writer. The tokens are taken
+// before each chunk is written. If a wait fails,
+// the amount of bytes written until then is
+// returned together with the error.
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	c := chunk(w.l)
+
+	for len(p) > 0 {
+		b := p
+		if len(b) > c {
+			b = b[:c]
+		}
+
+		if err := w.l.WaitN(w.ctx, len(b)); err != nil {
+			return written, err
+		}
+
+		n, err := w.w.Write(b)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n < len(b) {
+			return written, io.ErrShortWrite
+		}
+
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// chunk returns the maximum amount of
+// bytes transferred at once using l.
+func chunk(l Limiter) int {
+	if b := l.Burst(); b > 0 {
+		return b
+	}
+	return 1
+}
@@ -0,0 +1,172 @@
+package iolimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+func TestReader(t *testing.T) {
+	const limit = time.Millisecond
+	const burst = 10
+
+	data := bytes.Repeat([]byte("x"), 50)
+	l := ratelimit.NewLimiter(limit, burst)
+	r := NewReader(context.Background(), bytes.NewReader(data), l)
+
+	buf := make([]byte, 100)
+	n, err := r.Read(buf)
+	if err != nil || n != burst {
+		t.Fatalf("Read() should return (%d, nil) but returned (%d, %v)", burst, n, err)
+	}
+
+	start := time.Now()
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != len(data)-burst {
+		t.Errorf("%d bytes should be read but were %d", len(data)-burst, len(rest))
+	}
+	if d := time.Since(start); d < 30*limit {
+		t.Errorf("reading should take at least %v but took %v", 30*limit, d)
+	}
+}
+
+func TestReaderContext(t *testing.T) {
+	l := ratelimit.NewLimiter(time.Hour, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewReader(ctx, bytes.NewReader(make([]byte, 10)), l)
+
+	buf := make([]byte, 4)
+	if n, err := r.Read(buf); n != 4 || err != nil {
+		t.Fatalf("Read() should return (4, nil) but returned (%d, %v)", n, err)
+	}
+
+	// The bucket is empty and the next token is
+	// generated after the deadline.
+	dctx, dcancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer dcancel()
+	r = NewReader(dctx, bytes.NewReader(make([]byte, 10)), l)
+	if n, err := r.Read(buf); n != 4 || err != ratelimit.ErrExceedsDeadline {
+		t.Errorf("Read() should return (4, %v) but returned (%d, %v)", ratelimit.ErrExceedsDeadline, n, err)
+	}
+
+	cancel()
+	r = NewReader(ctx, bytes.NewReader(make([]byte, 10)), l)
+	if n, err := r.Read(buf); n != 0 || err != context.Canceled {
+		t.Errorf("Read() should return (0, %v) but returned (%d, %v)", context.Canceled, n, err)
+	}
+}
+
+// chunkWriter records the sizes of the writes.
+type chunkWriter struct {
+	mu     sync.Mutex
+	chunks []int
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.chunks = append(w.chunks, len(p))
+	return len(p), nil
+}
+
+func TestWriter(t *testing.T) {
+	const limit = time.Millisecond
+	const burst = 10
+
+	cw := &chunkWriter{}
+	w := NewWriter(context.Background(), cw, ratelimit.NewLimiter(limit, burst))
+
+	start := time.Now()
+	n, err := w.Write(make([]byte, 25))
+	if err != nil || n != 25 {
+		t.Fatalf("Write() should return (25, nil) but returned (%d, %v)", n, err)
+	}
+	if d := time.Since(start); d < 15*limit {
+		t.Errorf("writing should take at least %v but took %v", 15*limit, d)
+	}
+
+	want := []int{10, 10, 5}
+	if len(cw.chunks) != len(want) {
+		t.Fatalf("chunks should be %v but were %v", want, cw.chunks)
+	}
+	for i := range want {
+		if cw.chunks[i] != want[i] {
+			t.Errorf("chunks should be %v but were %v", want, cw.chunks)
+		}
+	}
+}
+
+func TestWriterContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	var buf bytes.Buffer
+	w := NewWriter(ctx, &buf, ratelimit.NewLimiter(time.Hour, 4))
+
+	n, err := w.Write(make([]byte, 10))
+	if n != 4 || err != ratelimit.ErrExceedsDeadline {
+		t.Errorf("Write() should return (4, %v) but returned (%d, %v)", ratelimit.ErrExceedsDeadline, n, err)
+	}
+	if buf.Len() != 4 {
+		t.Errorf("%d bytes should be written but were %d", 4, buf.Len())
+	}
+}
+
+func TestSharedLimiter(t *testing.T) {
+	const limit = 100 * time.Microsecond
+	const burst = 8
+	const streams = 4
+	const size = 100
+
+	l := ratelimit.NewLimiter(limit, burst)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := NewWriter(context.Background(), ioutil.Discard, l)
+			if _, err := io.Copy(w, bytes.NewReader(make([]byte, size))); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if d, want := time.Since(start), (streams*size-burst)*limit; d < want {
+		t.Errorf("the shared limiter should cap the aggregate bandwidth, took %v but should take at least %v", d, want)
+	}
+}
+
+func TestAll(t *testing.T) {
+	a := ratelimit.NewLimiter(time.Hour, 10)
+	b := ratelimit.NewLimiter(time.Hour, 4)
+	l := All(a, b)
+
+	if burst := l.Burst(); burst != 4 {
+		t.Errorf("burst should be %d but was %d", 4, burst)
+	}
+
+	if err := l.WaitN(context.Background(), 3); err != nil {
+		t.Fatal(err)
+	}
+	if ta, tb := a.Tokens(), b.Tokens(); ta != 7 || tb != 1 {
+		t.Errorf("tokens should be (7, 1) but were (%d, %d)", ta, tb)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.WaitN(ctx, 2); err != ratelimit.ErrExceedsDeadline {
+		t.Errorf("WaitN() should return %v but returned %v", ratelimit.ErrExceedsDeadline, err)
+	}
+}
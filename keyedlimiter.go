@@ -0,0 +1,193 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultPruneInterval is the default interval in
+// which a KeyedLimiter and a FailoverStore remove
+// refilled buckets from the MemoryStore they have
+// created themselves.
+const DefaultPruneInterval = time.Minute
+
+// A KeyedLimiter controls how frequently accesses
+// should be allowed to happen per key. Each key
+// has its own token bucket with the same limit
+// and burst rate, which state is held by a Store.
+type KeyedLimiter struct {
+	store Store
+
+	limit time.Duration
+	burst int
+
+	// owned is the MemoryStore created by the limiter,
+	// which is pruned by the limiter itself.
+	owned         *MemoryStore
+	pruneMu       sync.Mutex
+	pruneInterval time.Duration
+	nextPrune     time.Time
+}
+
+// NewKeyedLimiterWithStore returns a new instance
+// of KeyedLimiter using the given Store with a burst
+// rate of b and a limit time of l until a new token
+// will be generated.
+//
+// The limiter does not remove buckets from store, so
+// a MemoryStore passed here must be pruned by the
+// caller using MemoryStore#Prune.
+func NewKeyedLimiterWithStore(store Store, l time.Duration, b int) *KeyedLimiter {
+	return &KeyedLimiter{
+		store: store,
+		limit: l,
+		burst: b,
+	}
+}
+
+// NewKeyedLimiterWithTimeSource returns a new
+// instance of KeyedLimiter backed by a MemoryStore
+// using the given TimeSource with a burst rate of b
+// and a limit time of l until a new token will be
+// generated.
+//
+// Buckets which are completely refilled are removed
+// from the MemoryStore every DefaultPruneInterval,
+// so that the amount of buckets is bounded by the
+// amount of recently used keys.
+func NewKeyedLimiterWithTimeSource(timeSource TimeSource, l time.Duration, b int) *KeyedLimiter {
+	store := NewMemoryStoreWithTimeSource(timeSource)
+	kl := NewKeyedLimiterWithStore(store, l, b)
+	kl.owned = store
+	kl.pruneInterval = DefaultPruneInterval
+	return kl
+}
+
+// NewKeyedLimiter returns a new instance of
+// KeyedLimiter backed by a MemoryStore with
+// a burst rate of b and a limit time of l
+// until a new token will be generated.
+//
+// Buckets which are completely refilled are removed
+// from the MemoryStore every DefaultPruneInterval,
+// so that the amount of buckets is bounded by the
+// amount of recently used keys.
+func NewKeyedLimiter(l time.Duration, b int) *KeyedLimiter {
+	return NewKeyedLimiterWithTimeSource(time.Now, l, b)
+}
+
+// SetPruneInterval sets the interval in which
+// refilled buckets are removed from the MemoryStore
+// created by the limiter. If d is 0, no buckets are
+// removed. It has no effect on limiters created
+// with NewKeyedLimiterWithStore.
+func (kl *KeyedLimiter) SetPruneInterval(d time.Duration) {
+	kl.pruneMu.Lock()
+	defer kl.pruneMu.Unlock()
+	kl.pruneInterval = d
+	kl.nextPrune = time.Time{}
+}
+
+// ReserveNContext behaves like Limiter#ReserveN
+// for the bucket identified by key. When the
+// store fails, the error is returned.
+func (kl *KeyedLimiter) ReserveNContext(ctx context.Context, key string, n int) (bool, Reservation, error) {
+	kl.prune()
+	return kl.store.TakeN(ctx, key, n, kl.limit, kl.burst)
+}
+
+// ReturnNContext gives n previously taken tokens
+// back to the bucket identified by key, if the
+// store implements Returner. Otherwise, the
+// tokens are dropped.
+func (kl *KeyedLimiter) ReturnNContext(ctx context.Context, key string, n int) error {
+	if r, ok := kl.store.(Returner); ok && n > 0 {
+		return r.ReturnN(ctx, key, n, kl.limit, kl.burst)
+	}
+	return nil
+}
+
+// ReserveN is shorthand for ReserveNContext
+// with context.Background().
+func (kl *KeyedLimiter) ReserveN(key string, n int) (bool, Reservation, error) {
+	return kl.ReserveNContext(context.Background(), key, n)
+}
+
+// Reserve is shorthand for ReserveN(key, 1).
+func (kl *KeyedLimiter) Reserve(key string) (bool, Reservation, error) {
+	return kl.ReserveN(key, 1)
+}
+
+// AllowN is shorthand for ReserveN(key, n) but
+// only returning a boolean which exposes the
+// succeed of the reservation. If the store
+// fails, false is returned.
+func (kl *KeyedLimiter) AllowN(key string, n int) bool {
+	ok, _, err := kl.ReserveN(key, n)
+	return ok && err == nil
+}
+
+// Allow is shorthand for AllowN(key, 1).
+func (kl *KeyedLimiter) Allow(key string) bool {
+	return kl.AllowN(key, 1)
+}
+
+// Limit returns the defined limit duration
+// after which a new token will be generated.
+func (kl *KeyedLimiter) Limit() time.Duration {
+	return kl.limit
+}
+
+// Burst returns the defined burst value.
+func (kl *KeyedLimiter) Burst() int {
+	return kl.burst
+}
+
+// Store returns the Store which holds
+// the state of the buckets.
+func (kl *KeyedLimiter) Store() Store {
+	return kl.store
+}
+
+// Tokens returns the current available
+// tokens of the bucket identified by key.
+//
+// This function does not consume tokens.
+func (kl *KeyedLimiter) Tokens(key string) (int, error) {
+	return kl.store.Tokens(context.Background(), key, kl.limit, kl.burst)
+}
+
+// Reset sets the bucket identified by
+// key back to its initial state.
+func (kl *KeyedLimiter) Reset(key string) error {
+	return kl.store.Reset(context.Background(), key)
+}
+
+// prune removes the refilled buckets from the
+// MemoryStore created by the limiter if the
+// prune interval has elapsed.
+func (kl *KeyedLimiter) prune() {
+	if kl.owned == nil {
+		return
+	}
+
+	kl.pruneMu.Lock()
+	now := kl.owned.now()
+	if kl.pruneInterval <= 0 {
+		kl.pruneMu.Unlock()
+		return
+	}
+	if kl.nextPrune.IsZero() {
+		kl.nextPrune = now.Add(kl.pruneInterval)
+	}
+	due := !now.Before(kl.nextPrune)
+	if due {
+		kl.nextPrune = now.Add(kl.pruneInterval)
+	}
+	kl.pruneMu.Unlock()
+
+	if due {
+		kl.owned.Prune(kl.limit, kl.burst)
+	}
+}
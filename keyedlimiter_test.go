@@ -0,0 +1,175 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewKeyedLimiter(t *testing.T) {
+	const limit = 5 * time.Second
+	const burst = 4
+
+	kl := NewKeyedLimiter(limit, burst)
+	if kl == nil {
+		t.Fatal("NewKeyedLimiter() should not return nil")
+	}
+
+	if kl.Limit() != limit {
+		t.Errorf("limit should be %v but was %v", limit, kl.Limit())
+	}
+
+	if kl.Burst() != burst {
+		t.Errorf("burst should be %d but was %d", burst, kl.Burst())
+	}
+
+	if _, ok := kl.Store().(*MemoryStore); !ok {
+		t.Errorf("store should be *MemoryStore but was %T", kl.Store())
+	}
+}
+
+func TestKeyedLimiterReserveN(t *testing.T) {
+	const limit = 100 * time.Millisecond
+	const burst = 3
+
+	ts := &testTimeSource{}
+	kl := NewKeyedLimiterWithStore(NewMemoryStoreWithTimeSource(ts.Now), limit, burst)
+	l := NewLimiterWithTimeSource(ts.Now, limit, burst)
+
+	for i, n := range []int{0, 2, 1, 1, -1, 3} {
+		if i == 4 {
+			ts.Advance(2 * limit)
+		}
+
+		expOk, expRes := l.ReserveN(n)
+		ok, res, err := kl.ReserveN("a", n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != expOk || res != expRes {
+			t.Errorf("ReserveN(%d) should return (%t, %+v) but returned (%t, %+v)",
+				n, expOk, expRes, ok, res)
+		}
+	}
+}
+
+func TestKeyedLimiterAllow(t *testing.T) {
+	const limit = 100 * time.Millisecond
+	const burst = 1
+
+	ts := &testTimeSource{}
+	kl := NewKeyedLimiterWithStore(NewMemoryStoreWithTimeSource(ts.Now), limit, burst)
+
+	if !kl.Allow("a") {
+		t.Fatal("Reservation was not successful")
+	}
+	if kl.Allow("a") {
+		t.Fatal("Reservation was successful even though it should not")
+	}
+	if !kl.Allow("b") {
+		t.Fatal("Reservation of other key was not successful")
+	}
+
+	ts.Advance(limit)
+	if !kl.Allow("a") {
+		t.Fatal("Reservation was not successful")
+	}
+}
+
+func TestKeyedLimiterTokensReset(t *testing.T) {
+	const limit = time.Hour
+	const burst = 3
+
+	kl := NewKeyedLimiter(limit, burst)
+
+	if tg, _ := kl.Tokens("a"); tg != burst {
+		t.Errorf("kl.Tokens() should be %d but was %d", burst, tg)
+	}
+
+	kl.AllowN("a", 2)
+
+	if tg, _ := kl.Tokens("a"); tg != burst-2 {
+		t.Errorf("kl.Tokens() should be %d but was %d", burst-2, tg)
+	}
+
+	if err := kl.Reset("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if tg, _ := kl.Tokens("a"); tg != burst {
+		t.Errorf("kl.Tokens() should be %d but was %d", burst, tg)
+	}
+}
+
+func TestKeyedLimiterReturnN(t *testing.T) {
+	const limit = time.Hour
+	const burst = 3
+
+	kl := NewKeyedLimiter(limit, burst)
+	kl.AllowN("a", 3)
+
+	if err := kl.ReturnNContext(context.Background(), "a", 2); err != nil {
+		t.Fatal(err)
+	}
+	if tg, _ := kl.Tokens("a"); tg != 2 {
+		t.Errorf("kl.Tokens() should be %d but was %d", 2, tg)
+	}
+
+	// Stores which do not implement Returner
+	// silently drop returned tokens.
+	kl = NewKeyedLimiterWithStore(struct{ Store }{NewMemoryStore()}, limit, burst)
+	kl.AllowN("a", 3)
+
+	if err := kl.ReturnNContext(context.Background(), "a", 2); err != nil {
+		t.Fatal(err)
+	}
+	if tg, _ := kl.Tokens("a"); tg != 0 {
+		t.Errorf("kl.Tokens() should be %d but was %d", 0, tg)
+	}
+}
+
+func TestKeyedLimiterPrune(t *testing.T) {
+	const limit = time.Second
+	const burst = 2
+
+	ts := &testTimeSource{}
+	kl := NewKeyedLimiterWithTimeSource(ts.Now, limit, burst)
+	store := kl.Store().(*MemoryStore)
+
+	for i := 0; i < 10; i++ {
+		kl.Allow(string(rune('a' + i)))
+	}
+	if n := store.Len(); n != 10 {
+		t.Fatalf("store should hold %d buckets but held %d", 10, n)
+	}
+
+	// The buckets are refilled, but the
+	// prune interval has not elapsed yet.
+	ts.Advance(DefaultPruneInterval / 2)
+	kl.Allow("x")
+	if n := store.Len(); n != 11 {
+		t.Fatalf("store should hold %d buckets but held %d", 11, n)
+	}
+
+	ts.Advance(DefaultPruneInterval / 2)
+	kl.Allow("y")
+	if n := store.Len(); n != 1 {
+		t.Errorf("refilled buckets should be pruned but store held %d", n)
+	}
+
+	kl.SetPruneInterval(0)
+	ts.Advance(2 * DefaultPruneInterval)
+	kl.Allow("z")
+	if n := store.Len(); n != 2 {
+		t.Errorf("buckets should not be pruned but store held %d", n)
+	}
+
+	store = NewMemoryStoreWithTimeSource(ts.Now)
+	kl = NewKeyedLimiterWithStore(store, limit, burst)
+	kl.Allow("a")
+	ts.Advance(2 * DefaultPruneInterval)
+	kl.Allow("b")
+	if n := store.Len(); n != 2 {
+		t.Errorf("passed store should not be pruned but held %d", n)
+	}
+}
@@ -0,0 +1,332 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A LeasingLimiter is a Limiter for a single key of
+// a shared Store which reduces the round trips to
+// the store by taking blocks of tokens (leases) at
+// once and serving them locally.
+//
+// Tokens which were leased but not consumed until
+// the lease expires are returned to the store, if
+// the store implements Returner. Otherwise, they
+// are dropped.
+//
+// Because leased tokens are taken from the store
+// before they are consumed, each LeasingLimiter can
+// exceed the rate of the shared bucket by at most
+// the lease size. So, with N instances sharing the
+// same key, the worst-case overshoot is N times the
+// lease size.
+type LeasingLimiter struct {
+	mu  sync.Mutex
+	now TimeSource
+
+	store Store
+	key   string
+	limit time.Duration
+	burst int
+
+	leaseSize int
+	leaseTTL  time.Duration
+
+	leased  int
+	expires time.Time
+	last    Reservation
+	timer   *time.Timer
+}
+
+// NewLeasingLimiterWithTimeSource returns a new
+// instance of LeasingLimiter with the given
+// TimeSource for the bucket key of the store with
+// a burst rate of b and a limit time of l until a
+// new token will be generated.
+//
+// The limiter takes leaseSize tokens at once from
+// the store, which are returned after leaseTTL if
+// they have not been consumed until then. If
+// leaseTTL is <= 0, leases never expire and are
+// only returned on Release.
+func NewLeasingLimiterWithTimeSource(
+	timeSource TimeSource,
+	store Store,
+	key string,
+	l time.Duration,
+	b int,
+	leaseSize int,
+	leaseTTL time.Duration,
+) *LeasingLimiter {
+	if leaseSize < 1 {
+		leaseSize = 1
+	}
+
+	return &LeasingLimiter{
+		now:       timeSource,
+		store:     store,
+		key:       key,
+		limit:     l,
+		burst:     b,
+		leaseSize: leaseSize,
+		leaseTTL:  leaseTTL,
+	}
+}
+
+// NewLeasingLimiter returns a new instance of
+// LeasingLimiter for the bucket key of the store
+// with a burst rate of b and a limit time of l
+// until a new token will be generated.
+//
+// The limiter takes leaseSize tokens at once from
+// the store, which are returned after leaseTTL if
+// they have not been consumed until then.
+func NewLeasingLimiter(
+	store Store,
+	key string,
+	l time.Duration,
+	b int,
+	leaseSize int,
+	leaseTTL time.Duration,
+) *LeasingLimiter {
+	return NewLeasingLimiterWithTimeSource(time.Now, store, key, l, b, leaseSize, leaseTTL)
+}
+
+// ReserveNContext behaves like Limiter#ReserveN.
+// The tokens are consumed from the current lease.
+// If the lease does not contain enough tokens, a
+// new lease is taken from the store. When the
+// store fails, the error is returned.
+//
+// The Remaining value of the returned Reservation
+// is the sum of the locally leased tokens and the
+// remaining tokens of the store at the time the
+// last lease was taken.
+func (l *LeasingLimiter) ReserveNContext(ctx context.Context, n int) (bool, Reservation, error) {
+	if n <= 0 {
+		return true, Reservation{}, nil
+	}
+
+	if l.burst <= 0 || l.limit <= 0 {
+		return false, Reservation{}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	if err := l.releaseExpired(ctx, now); err != nil {
+		return false, Reservation{}, err
+	}
+
+	if l.leased < n {
+		ok, err := l.lease(ctx, now, n-l.leased)
+		if err != nil {
+			return false, Reservation{}, err
+		}
+		if !ok {
+			res := l.last
+			res.Remaining += l.leased
+			return false, res, nil
+		}
+	}
+
+	l.leased -= n
+
+	return true, l.reservation(now), nil
+}
+
+// ReserveN is shorthand for ReserveNContext with
+// context.Background(). If the store fails, false
+// is returned with an empty Reservation.
+func (l *LeasingLimiter) ReserveN(n int) (bool, Reservation) {
+	ok, res, err := l.ReserveNContext(context.Background(), n)
+	if err != nil {
+		return false, Reservation{}
+	}
+	return ok, res
+}
+
+// Reserve is shorthand for ReserveN(1).
+func (l *LeasingLimiter) Reserve() (bool, Reservation) {
+	return l.ReserveN(1)
+}
+
+// AllowN is shorthand for reserveN(n) but only
+// returning a boolean which exposes the
+// succeed of the reservation.
+func (l *LeasingLimiter) AllowN(n int) bool {
+	ok, _ := l.ReserveN(n)
+	return ok
+}
+
+// Allow is shorthand for AllowN(1).
+func (l *LeasingLimiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+// Limit returns the defined limit duration
+// after which a new token will be generated.
+func (l *LeasingLimiter) Limit() time.Duration {
+	return l.limit
+}
+
+// Burst returns the defined burst value.
+func (l *LeasingLimiter) Burst() int {
+	return l.burst
+}
+
+// Leased returns the amount of tokens
+// currently held by the local lease.
+func (l *LeasingLimiter) Leased() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leased
+}
+
+// Tokens returns the sum of the locally leased
+// tokens and the available tokens of the store.
+// If the store fails, only the leased tokens
+// are returned.
+//
+// This function does not consume tokens.
+func (l *LeasingLimiter) Tokens() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t, err := l.store.Tokens(context.Background(), l.key, l.limit, l.burst)
+	if err != nil {
+		return l.leased
+	}
+
+	return l.leased + t
+}
+
+// Release returns all currently leased tokens to
+// the store. It should be called before the
+// limiter is discarded.
+func (l *LeasingLimiter) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.release(ctx)
+}
+
+// Reset drops the current lease and sets the
+// bucket of the store back to its initial state.
+func (l *LeasingLimiter) Reset() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.drop()
+	return l.store.Reset(context.Background(), l.key)
+}
+
+// lease takes a new lease of at least n tokens
+// from the store. If the store can not provide a
+// full lease, only n tokens are requested.
+func (l *LeasingLimiter) lease(ctx context.Context, now time.Time, n int) (bool, error) {
+	sizes := []int{n}
+	if l.leaseSize > n {
+		size := l.leaseSize
+		if size > l.burst {
+			size = l.burst
+		}
+		if size > n {
+			sizes = []int{size, n}
+		}
+	}
+
+	for _, size := range sizes {
+		ok, res, err := l.store.TakeN(ctx, l.key, size, l.limit, l.burst)
+		if err != nil {
+			return false, err
+		}
+
+		l.last = res
+		if ok {
+			l.leased += size
+			l.expires = now.Add(l.leaseTTL)
+			l.schedule()
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// reservation returns the local view on the
+// state of the bucket.
+func (l *LeasingLimiter) reservation(now time.Time) Reservation {
+	res := Reservation{
+		Burst:     l.burst,
+		Remaining: l.leased + l.last.Remaining,
+		Reset: ResetTime{
+			isNil: true,
+		},
+	}
+
+	if res.Remaining == 0 {
+		res.Reset = l.last.Reset
+		if res.Reset.IsNil() {
+			res.Reset = NewResetTime(now.Add(l.limit))
+		}
+	}
+
+	return res
+}
+
+// schedule starts a timer which releases the
+// lease after it expired, so that idle limiters
+// do not hold back tokens from the store.
+func (l *LeasingLimiter) schedule() {
+	if l.leaseTTL <= 0 {
+		return
+	}
+
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+
+	l.timer = time.AfterFunc(l.leaseTTL, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.releaseExpired(context.Background(), l.now())
+	})
+}
+
+// releaseExpired returns the leased tokens
+// if the lease has been expired at now.
+func (l *LeasingLimiter) releaseExpired(ctx context.Context, now time.Time) error {
+	if l.leased == 0 || l.leaseTTL <= 0 || now.Before(l.expires) {
+		return nil
+	}
+
+	return l.release(ctx)
+}
+
+// release returns the leased tokens to
+// the store, if it implements Returner.
+func (l *LeasingLimiter) release(ctx context.Context) error {
+	n := l.leased
+	l.drop()
+
+	if r, ok := l.store.(Returner); ok && n > 0 {
+		return r.ReturnN(ctx, l.key, n, l.limit, l.burst)
+	}
+
+	return nil
+}
+
+// drop discards the current lease.
+func (l *LeasingLimiter) drop() {
+	l.leased = 0
+	l.expires = time.Time{}
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+}
@@ -0,0 +1,187 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingStore counts the calls of TakeN
+// and optionally fails all operations.
+type countingStore struct {
+	*MemoryStore
+
+	takes int
+	err   error
+}
+
+func (s *countingStore) TakeN(ctx context.Context, key string, n int, l time.Duration, b int) (bool, Reservation, error) {
+	s.takes++
+	if s.err != nil {
+		return false, Reservation{}, s.err
+	}
+	return s.MemoryStore.TakeN(ctx, key, n, l, b)
+}
+
+func TestLeasingLimiterReserveN(t *testing.T) {
+	const limit = time.Hour
+	const burst = 10
+	const leaseSize = 4
+
+	ts := &testTimeSource{}
+	s := &countingStore{MemoryStore: NewMemoryStoreWithTimeSource(ts.Now)}
+	l := NewLeasingLimiterWithTimeSource(ts.Now, s, "a", limit, burst, leaseSize, time.Minute)
+
+	ok, res := l.ReserveN(0)
+	if !ok || (res != Reservation{}) {
+		t.Errorf("ReserveN(0) should return (true, nil) but returned (%t, %+v)", ok, res)
+	}
+
+	for i := 0; i < leaseSize; i++ {
+		if !l.Allow() {
+			t.Fatal("Reservation was not successful")
+		}
+	}
+
+	if s.takes != 1 {
+		t.Errorf("store should be called %d times but was called %d times", 1, s.takes)
+	}
+
+	ok, res = l.Reserve()
+	if !ok {
+		t.Fatal("Reservation was not successful")
+	}
+	if s.takes != 2 {
+		t.Errorf("store should be called %d times but was called %d times", 2, s.takes)
+	}
+	if res.Burst != burst {
+		t.Errorf("res.Burst should be %d but was %d", burst, res.Burst)
+	}
+	if res.Remaining != burst-leaseSize-1 {
+		t.Errorf("res.Remaining should be %d but was %d", burst-leaseSize-1, res.Remaining)
+	}
+	if l.Leased() != leaseSize-1 {
+		t.Errorf("l.Leased() should be %d but was %d", leaseSize-1, l.Leased())
+	}
+}
+
+func TestLeasingLimiterPartialLease(t *testing.T) {
+	const limit = time.Hour
+	const burst = 5
+	const leaseSize = 4
+
+	ts := &testTimeSource{}
+	s := NewMemoryStoreWithTimeSource(ts.Now)
+	l := NewLeasingLimiterWithTimeSource(ts.Now, s, "a", limit, burst, leaseSize, 0)
+
+	// The first lease takes 4 tokens, so only one token
+	// is left in the store. The second lease can not be
+	// fully satisfied, so only the required token is taken.
+	if !l.AllowN(leaseSize) {
+		t.Fatal("Reservation was not successful")
+	}
+
+	ok, res := l.Reserve()
+	if !ok {
+		t.Fatal("Reservation was not successful")
+	}
+	if res.Remaining != 0 {
+		t.Errorf("res.Remaining should be %d but was %d", 0, res.Remaining)
+	}
+	if res.Reset.IsNil() {
+		t.Error("res.Reset.IsNil should be false but was true")
+	}
+
+	ok, res = l.Reserve()
+	if ok {
+		t.Fatal("Reservation was successful even though it should not")
+	}
+	if res.Remaining != 0 {
+		t.Errorf("res.Remaining should be %d but was %d", 0, res.Remaining)
+	}
+}
+
+func TestLeasingLimiterExpiry(t *testing.T) {
+	const limit = time.Hour
+	const burst = 10
+	const leaseSize = 5
+	const leaseTTL = time.Second
+
+	ts := &testTimeSource{}
+	s := NewMemoryStoreWithTimeSource(ts.Now)
+	l1 := NewLeasingLimiterWithTimeSource(ts.Now, s, "a", limit, burst, leaseSize, leaseTTL)
+	l2 := NewLeasingLimiterWithTimeSource(ts.Now, s, "a", limit, burst, leaseSize, leaseTTL)
+
+	l1.Allow()
+	l2.Allow()
+
+	// Both limiters hold 4 tokens, so the store is empty.
+	if tk := l1.Tokens(); tk != leaseSize-1 {
+		t.Errorf("l1.Tokens() should be %d but was %d", leaseSize-1, tk)
+	}
+
+	ts.Advance(leaseTTL)
+
+	// The lease of l1 expired, so its 4 tokens are
+	// returned before a new lease is taken. Because
+	// the store only contains these 4 tokens, a full
+	// lease can not be taken and only 1 token is
+	// requested.
+	if !l1.Allow() {
+		t.Fatal("Reservation was not successful")
+	}
+	if lt := l1.Leased(); lt != 0 {
+		t.Errorf("l1.Leased() should be %d but was %d", 0, lt)
+	}
+
+	if err := l2.Release(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if lt := l2.Leased(); lt != 0 {
+		t.Errorf("l2.Leased() should be %d but was %d", 0, lt)
+	}
+
+	if tk, _ := s.Tokens(context.Background(), "a", limit, burst); tk != burst-3 {
+		t.Errorf("store tokens should be %d but were %d", burst-3, tk)
+	}
+}
+
+func TestLeasingLimiterError(t *testing.T) {
+	errStore := errors.New("store error")
+
+	ts := &testTimeSource{}
+	s := &countingStore{MemoryStore: NewMemoryStoreWithTimeSource(ts.Now), err: errStore}
+	l := NewLeasingLimiterWithTimeSource(ts.Now, s, "a", time.Second, 10, 5, time.Minute)
+
+	_, _, err := l.ReserveNContext(context.Background(), 1)
+	if err != errStore {
+		t.Errorf("error should be %v but was %v", errStore, err)
+	}
+
+	if l.Allow() {
+		t.Error("Allow should return false when the store fails")
+	}
+}
+
+func TestLeasingLimiterReset(t *testing.T) {
+	const limit = time.Hour
+	const burst = 10
+
+	ts := &testTimeSource{}
+	s := NewMemoryStoreWithTimeSource(ts.Now)
+	l := NewLeasingLimiterWithTimeSource(ts.Now, s, "a", limit, burst, 5, time.Minute)
+
+	l.AllowN(3)
+
+	if err := l.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	if lt := l.Leased(); lt != 0 {
+		t.Errorf("l.Leased() should be %d but was %d", 0, lt)
+	}
+	if tk := l.Tokens(); tk != burst {
+		t.Errorf("l.Tokens() should be %d but was %d", burst, tk)
+	}
+}
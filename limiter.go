@@ -1,10 +1,21 @@
 package ratelimit
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"time"
 )
 
+// ErrExceedsBurst is returned by Limiter#WaitN when
+// more tokens are requested than the bucket can hold.
+var ErrExceedsBurst = errors.New("n exceeds the burst rate")
+
+// ErrExceedsDeadline is returned by Limiter#WaitN
+// when the tokens would not be available until the
+// deadline of the context.
+var ErrExceedsDeadline = errors.New("wait would exceed the context deadline")
+
 // A Limiter controls how frequently accesses
 // should be allowed to happen. It implements
 // the principle of the token bucket, which
@@ -23,8 +34,7 @@ type Limiter struct {
 	limit time.Duration
 	burst int
 
-	tokens int
-	last   time.Time
+	bucket
 }
 
 // NewLimiterWithTimeSource returns a new instance of
@@ -35,7 +45,7 @@ func NewLimiterWithTimeSource(timeSource TimeSource, l time.Duration, b int) *Li
 		now:    timeSource,
 		limit:  l,
 		burst:  b,
-		tokens: b,
+		bucket: newBucket(b),
 	}
 }
 
@@ -57,49 +67,57 @@ func NewLimiter(l time.Duration, b int) *Limiter {
 // status containing the time until next token
 // generation.
 func (l *Limiter) ReserveN(n int) (bool, Reservation) {
-	if n <= 0 {
-		return true, Reservation{}
-	}
-
-	if l.burst <= 0 || l.limit <= 0 {
-		return false, Reservation{}
-	}
-
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	res := Reservation{
-		Burst: l.burst,
-		Reset: ResetTime{
-			isNil: true,
-		},
-	}
-
-	tokensSinceLast := int(l.now().Sub(l.last) / l.limit)
-	tokens := l.tokens + tokensSinceLast
-	if tokens > l.burst {
-		tokens = l.burst
-	}
-
-	if tokens >= n {
-		l.tokens = tokens - n
-		l.last = l.now()
+	return l.takeN(l.now(), n, l.limit, l.burst)
+}
 
-		res.Remaining = l.tokens
+// WaitN blocks until n tokens are available and
+// consumes them. If n exceeds the burst rate,
+// ErrExceedsBurst is returned. If the tokens would
+// not be available until the deadline of ctx,
+// ErrExceedsDeadline is returned immediately. When
+// ctx is done while waiting, its error is returned.
+// In all error cases, no tokens are consumed.
+//
+// The wait and the deadline of ctx are measured on
+// the wall clock, so WaitN only supports TimeSources
+// which advance with the wall clock, like time.Now
+// with a fixed offset.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	for {
+		l.mu.Lock()
+		now := l.now()
+		ok, _ := l.takeN(now, n, l.limit, l.burst)
+		if ok {
+			l.mu.Unlock()
+			return nil
+		}
+		if n > l.burst || l.limit <= 0 {
+			l.mu.Unlock()
+			return ErrExceedsBurst
+		}
+		d := l.wait(now, n, l.limit, l.burst)
+		l.mu.Unlock()
 
-		if l.tokens == 0 {
-			res.Reset.Time = l.last.Add(l.limit)
-			res.Reset.isNil = false
+		if dl, ok := ctx.Deadline(); ok && d > time.Until(dl) {
+			return ErrExceedsDeadline
 		}
 
-		return true, res
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
 	}
+}
 
-	res.Remaining = l.tokens
-	res.Reset.Time = l.last.Add(l.limit)
-	res.Reset.isNil = false
-
-	return false, res
+// Wait is shorthand for WaitN(ctx, 1).
+func (l *Limiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
 }
 
 // Reserve is shorthand for ReserveN(1).
@@ -172,12 +190,20 @@ func (l *Limiter) Tokens() int {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	t := l.tokens + int(l.now().Sub(l.last)/l.limit)
-	if t > l.burst {
-		return l.burst
-	}
+	return l.available(l.now(), l.limit, l.burst)
+}
+
+// Sync adopts the state reported by a remote
+// server, which may only make the limiter stricter.
+// The amount of available tokens is lowered to n
+// if more are available. If next is not zero and
+// later than the time the next token would be
+// generated, the next token is generated at next.
+func (l *Limiter) Sync(n int, next time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	return t
+	l.lower(l.now(), n, next, l.limit, l.burst)
 }
 
 // Reset sets the state of the limiter to
@@ -187,6 +213,5 @@ func (l *Limiter) Reset() {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	l.tokens = l.burst
-	l.last = time.Time{}
+	l.bucket = newBucket(l.burst)
 }
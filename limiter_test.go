@@ -1,6 +1,7 @@
 package ratelimit
 
 import (
+	"context"
 	"testing"
 	"time"
 )
@@ -371,3 +372,79 @@ func TestReserve_reset(t *testing.T) {
 		t.Fatal("Third Reserve should return true")
 	}
 }
+
+func TestWaitN(t *testing.T) {
+	const limit = 20 * time.Millisecond
+	const burst = 2
+
+	l := NewLimiter(limit, burst)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 2*limit {
+		t.Errorf("waiting for 4 tokens should take at least %v but took %v", 2*limit, d)
+	}
+
+	if err := l.WaitN(ctx, burst+1); err != ErrExceedsBurst {
+		t.Errorf("WaitN(burst+1) should return %v but returned %v", ErrExceedsBurst, err)
+	}
+
+	dctx, cancel := context.WithTimeout(ctx, limit/2)
+	defer cancel()
+	if err := l.WaitN(dctx, burst); err != ErrExceedsDeadline {
+		t.Errorf("WaitN() should return %v but returned %v", ErrExceedsDeadline, err)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := l.WaitN(cctx, burst); err != context.Canceled {
+		t.Errorf("WaitN() should return %v but returned %v", context.Canceled, err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	const limit = time.Second
+	const burst = 5
+
+	ts := &testTimeSource{}
+	l := NewLimiterWithTimeSource(ts.Now, limit, burst)
+
+	l.Sync(2, time.Time{})
+	if tg := l.Tokens(); tg != 2 {
+		t.Errorf("l.Tokens() should be %d but was %d", 2, tg)
+	}
+
+	l.Sync(0, ts.Now().Add(10*time.Second))
+	if tg := l.Tokens(); tg != 0 {
+		t.Errorf("l.Tokens() should be %d but was %d", 0, tg)
+	}
+	ts.Advance(9 * time.Second)
+	if ok, res := l.Reserve(); ok || res.Reset.Time != ts.Now().Add(time.Second) {
+		t.Errorf("Reserve() should fail until the next token but returned (%t, %v)", ok, res.Reset.Time)
+	}
+	ts.Advance(time.Second)
+	if !l.Allow() {
+		t.Error("Allow() should succeed after the next token was generated")
+	}
+
+	l.Sync(100, time.Time{})
+	if tg := l.Tokens(); tg != 0 {
+		t.Errorf("l.Tokens() should not be raised but was %d", tg)
+	}
+
+	// The next token must not be moved earlier.
+	l.Sync(0, ts.Now().Add(500*time.Millisecond))
+	ts.Advance(500 * time.Millisecond)
+	if l.Allow() {
+		t.Error("Allow() should fail until the next token")
+	}
+	ts.Advance(500 * time.Millisecond)
+	if !l.Allow() {
+		t.Error("Allow() should succeed after the next token was generated")
+	}
+}
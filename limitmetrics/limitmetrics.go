@@ -0,0 +1,326 @@
+// Package limitmetrics records metrics of named
+// limiters, like the amount of allowed and denied
+// reservations, the tokens left and the time spent
+// waiting for tokens.
+//
+// Limiters are registered in a Registry, which wraps
+// them so that each decision is recorded. The metrics
+// can be exposed in the Prometheus text format using
+// WriteText or Handler without further dependencies,
+// or as prometheus.Collector using the module promlimit.
+package limitmetrics
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+// DefaultBuckets are the upper bounds of the
+// buckets of the wait time histograms
+// in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds the metrics of named limiters.
+type Registry struct {
+	mu      sync.Mutex
+	buckets []float64
+	entries map[string]entry
+}
+
+type entry interface {
+	snapshot() Snapshot
+}
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		buckets: DefaultBuckets,
+		entries: make(map[string]entry),
+	}
+}
+
+// SetBuckets sets the upper bounds of the buckets
+// of the wait time histograms in seconds, sorted
+// in increasing order. It only applies to limiters
+// registered afterwards.
+func (r *Registry) SetBuckets(buckets []float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buckets = buckets
+}
+
+// Limiter registers l with the given name and returns
+// a wrapper recording its decisions. It panics if a
+// limiter with the name is already registered.
+func (r *Registry) Limiter(name string, l *ratelimit.Limiter) *Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ml := &Limiter{Limiter: l, stats: newStats(name, r.buckets)}
+	r.register(name, ml)
+	return ml
+}
+
+// KeyedLimiter registers kl with the given name and
+// returns a wrapper recording its decisions. It panics
+// if a limiter with the name is already registered.
+func (r *Registry) KeyedLimiter(name string, kl *ratelimit.KeyedLimiter) *KeyedLimiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mkl := &KeyedLimiter{KeyedLimiter: kl, stats: newStats(name, r.buckets)}
+	r.register(name, mkl)
+	return mkl
+}
+
+// Unregister removes the limiter with the
+// given name from the registry.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, name)
+}
+
+// Snapshot returns the current metrics of all
+// registered limiters sorted by their names.
+func (r *Registry) Snapshot() []Snapshot {
+	r.mu.Lock()
+	entries := make([]entry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, e)
+	}
+	r.mu.Unlock()
+
+	snaps := make([]Snapshot, len(entries))
+	for i, e := range entries {
+		snaps[i] = e.snapshot()
+	}
+	sort.Slice(snaps, func(i, j int) bool {
+		return snaps[i].Name < snaps[j].Name
+	})
+	return snaps
+}
+
+func (r *Registry) register(name string, e entry) {
+	if _, ok := r.entries[name]; ok {
+		panic("limitmetrics: limiter " + name + " is already registered")
+	}
+	r.entries[name] = e
+}
+
+// Snapshot contains the metrics of a
+// limiter at one point in time.
+type Snapshot struct {
+	// Name is the name of the limiter.
+	Name string
+	// Keyed is true for keyed limiters.
+	Keyed bool
+
+	// Allowed is the amount of allowed
+	// reservations and waits.
+	Allowed uint64
+	// Denied is the amount of denied reservations
+	// and waits which have failed.
+	Denied uint64
+
+	// Tokens is the amount of tokens left. It is
+	// only set for limiters which are not keyed.
+	Tokens int
+	// ActiveKeys is the amount of keys with a
+	// bucket in the store of a keyed limiter. It
+	// is -1 if the store does not expose it.
+	ActiveKeys int
+
+	// Wait is the histogram of the time
+	// spent waiting for tokens.
+	Wait Histogram
+}
+
+// Histogram is a snapshot of a histogram.
+type Histogram struct {
+	// Buckets are the upper bounds of
+	// the buckets in seconds.
+	Buckets []float64
+	// Counts are the cumulative counts
+	// of the buckets.
+	Counts []uint64
+	// Count is the total amount of
+	// observations.
+	Count uint64
+	// Sum is the sum of all observations
+	// in seconds.
+	Sum float64
+}
+
+// stats records the metrics of a limiter.
+type stats struct {
+	name string
+
+	mu      sync.Mutex
+	allowed uint64
+	denied  uint64
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newStats(name string, buckets []float64) *stats {
+	return &stats{
+		name:    name,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (s *stats) record(ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ok {
+		s.allowed++
+	} else {
+		s.denied++
+	}
+}
+
+func (s *stats) observe(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v := d.Seconds()
+	for i, b := range s.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (s *stats) snapshot() Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return Snapshot{
+		Name:    s.name,
+		Allowed: s.allowed,
+		Denied:  s.denied,
+		Wait: Histogram{
+			Buckets: s.buckets,
+			Counts:  append([]uint64(nil), s.counts...),
+			Count:   s.count,
+			Sum:     s.sum,
+		},
+	}
+}
+
+// Limiter wraps a ratelimit.Limiter and
+// records its decisions.
+type Limiter struct {
+	*ratelimit.Limiter
+	stats *stats
+}
+
+// ReserveN behaves like ratelimit.Limiter#ReserveN.
+func (l *Limiter) ReserveN(n int) (bool, ratelimit.Reservation) {
+	ok, res := l.Limiter.ReserveN(n)
+	l.stats.record(ok)
+	return ok, res
+}
+
+// Reserve behaves like ratelimit.Limiter#Reserve.
+func (l *Limiter) Reserve() (bool, ratelimit.Reservation) {
+	return l.ReserveN(1)
+}
+
+// AllowN behaves like ratelimit.Limiter#AllowN.
+func (l *Limiter) AllowN(n int) bool {
+	ok, _ := l.ReserveN(n)
+	return ok
+}
+
+// Allow behaves like ratelimit.Limiter#Allow.
+func (l *Limiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+// WaitN behaves like ratelimit.Limiter#WaitN. The
+// time spent waiting is recorded in the histogram
+// if the wait succeeds.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	start := time.Now()
+	err := l.Limiter.WaitN(ctx, n)
+	l.stats.record(err == nil)
+	if err == nil {
+		l.stats.observe(time.Since(start))
+	}
+	return err
+}
+
+// Wait behaves like ratelimit.Limiter#Wait.
+func (l *Limiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+func (l *Limiter) snapshot() Snapshot {
+	s := l.stats.snapshot()
+	s.Tokens = l.Limiter.Tokens()
+	return s
+}
+
+// KeyedLimiter wraps a ratelimit.KeyedLimiter
+// and records its decisions.
+type KeyedLimiter struct {
+	*ratelimit.KeyedLimiter
+	stats *stats
+}
+
+// ReserveNContext behaves like
+// ratelimit.KeyedLimiter#ReserveNContext. Failed
+// reservations are not recorded.
+func (kl *KeyedLimiter) ReserveNContext(ctx context.Context, key string, n int) (bool, ratelimit.Reservation, error) {
+	ok, res, err := kl.KeyedLimiter.ReserveNContext(ctx, key, n)
+	if err == nil {
+		kl.stats.record(ok)
+	}
+	return ok, res, err
+}
+
+// ReserveN behaves like
+// ratelimit.KeyedLimiter#ReserveN.
+func (kl *KeyedLimiter) ReserveN(key string, n int) (bool, ratelimit.Reservation, error) {
+	return kl.ReserveNContext(context.Background(), key, n)
+}
+
+// Reserve behaves like
+// ratelimit.KeyedLimiter#Reserve.
+func (kl *KeyedLimiter) Reserve(key string) (bool, ratelimit.Reservation, error) {
+	return kl.ReserveN(key, 1)
+}
+
+// AllowN behaves like
+// ratelimit.KeyedLimiter#AllowN.
+func (kl *KeyedLimiter) AllowN(key string, n int) bool {
+	ok, _, err := kl.ReserveN(key, n)
+	return ok && err == nil
+}
+
+// Allow behaves like
+// ratelimit.KeyedLimiter#Allow.
+func (kl *KeyedLimiter) Allow(key string) bool {
+	return kl.AllowN(key, 1)
+}
+
+func (kl *KeyedLimiter) snapshot() Snapshot {
+	s := kl.stats.snapshot()
+	s.Keyed = true
+	s.ActiveKeys = -1
+	if st, ok := kl.Store().(interface{ Len() int }); ok {
+		s.ActiveKeys = st.Len()
+	}
+	return s
+}
@@ -0,0 +1,107 @@
+package limitmetrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+func TestLimiter(t *testing.T) {
+	r := NewRegistry()
+	l := r.Limiter("api", ratelimit.NewLimiter(5*time.Millisecond, 2))
+
+	l.Allow()
+	l.Reserve()
+	l.Allow()
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	snaps := r.Snapshot()
+	if len(snaps) != 1 {
+		t.Fatalf("snapshot should contain 1 limiter but contained %d", len(snaps))
+	}
+	s := snaps[0]
+	if s.Name != "api" || s.Keyed {
+		t.Errorf("snapshot should be of limiter api but was %+v", s)
+	}
+	if s.Allowed != 3 || s.Denied != 1 {
+		t.Errorf("allowed and denied should be 3 and 1 but were %d and %d", s.Allowed, s.Denied)
+	}
+	if s.Tokens != 0 {
+		t.Errorf("tokens should be 0 but were %d", s.Tokens)
+	}
+	if s.Wait.Count != 1 || s.Wait.Sum <= 0 {
+		t.Errorf("one wait should be observed but histogram was %+v", s.Wait)
+	}
+	if s.Wait.Counts[len(s.Wait.Counts)-1] != 1 {
+		t.Errorf("wait should be counted in the last bucket but counts were %v", s.Wait.Counts)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	l.SetLimit(time.Hour)
+	if err := l.Wait(ctx); err == nil {
+		t.Fatal("wait should fail")
+	}
+	if s = r.Snapshot()[0]; s.Denied != 2 || s.Wait.Count != 1 {
+		t.Errorf("failed wait should be counted as denied but snapshot was %+v", s)
+	}
+}
+
+func TestKeyedLimiter(t *testing.T) {
+	r := NewRegistry()
+	kl := r.KeyedLimiter("users", ratelimit.NewKeyedLimiter(time.Hour, 1))
+
+	kl.Allow("a")
+	kl.Allow("a")
+	kl.Allow("b")
+
+	s := r.Snapshot()[0]
+	if !s.Keyed {
+		t.Error("snapshot should be keyed")
+	}
+	if s.Allowed != 2 || s.Denied != 1 {
+		t.Errorf("allowed and denied should be 2 and 1 but were %d and %d", s.Allowed, s.Denied)
+	}
+	if s.ActiveKeys != 2 {
+		t.Errorf("active keys should be 2 but were %d", s.ActiveKeys)
+	}
+
+	r.KeyedLimiter("other", ratelimit.NewKeyedLimiterWithStore(
+		struct{ ratelimit.Store }{ratelimit.NewMemoryStore()}, time.Hour, 1))
+	if s = r.Snapshot()[0]; s.ActiveKeys != -1 {
+		t.Errorf("active keys should be -1 but were %d", s.ActiveKeys)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.SetBuckets([]float64{1})
+	r.Limiter("b", ratelimit.NewLimiter(time.Second, 1))
+	r.Limiter("a", ratelimit.NewLimiter(time.Second, 1))
+
+	snaps := r.Snapshot()
+	if len(snaps) != 2 || snaps[0].Name != "a" || snaps[1].Name != "b" {
+		t.Fatalf("snapshots should be sorted by name but were %+v", snaps)
+	}
+	if len(snaps[0].Wait.Buckets) != 1 {
+		t.Errorf("histogram should have 1 bucket but had %d", len(snaps[0].Wait.Buckets))
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("registering a duplicate name should panic")
+			}
+		}()
+		r.Limiter("a", ratelimit.NewLimiter(time.Second, 1))
+	}()
+
+	r.Unregister("a")
+	if snaps = r.Snapshot(); len(snaps) != 1 || snaps[0].Name != "b" {
+		t.Errorf("only limiter b should be left but snapshots were %+v", snaps)
+	}
+}
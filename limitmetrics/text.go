@@ -0,0 +1,142 @@
+package limitmetrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Names of the exposed metrics. All metrics
+// have the label "limiter" containing the
+// name of the limiter.
+const (
+	AllowedName    = "ratelimit_allowed_total"
+	DeniedName     = "ratelimit_denied_total"
+	TokensName     = "ratelimit_tokens"
+	WaitName       = "ratelimit_wait_seconds"
+	ActiveKeysName = "ratelimit_active_keys"
+)
+
+// Help texts of the exposed metrics.
+const (
+	AllowedHelp    = "Amount of allowed reservations and waits."
+	DeniedHelp     = "Amount of denied reservations and failed waits."
+	TokensHelp     = "Amount of tokens left."
+	WaitHelp       = "Time spent waiting for tokens."
+	ActiveKeysHelp = "Amount of keys with a bucket in the store."
+)
+
+// ContentType is the content type of
+// the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WriteText writes the metrics of all limiters of r
+// to w in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	return WriteText(w, r.Snapshot())
+}
+
+// Handler returns a handler which serves the
+// metrics of all limiters of r in the Prometheus
+// text exposition format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteText(w)
+	})
+}
+
+// WriteText writes the given snapshots to w in the
+// Prometheus text exposition format.
+func WriteText(w io.Writer, snaps []Snapshot) error {
+	bw := bufio.NewWriter(w)
+
+	writeFamily(bw, AllowedName, AllowedHelp, "counter", snaps, nil, func(s Snapshot) {
+		writeSample(bw, AllowedName, s.Name, "", float64(s.Allowed))
+	})
+	writeFamily(bw, DeniedName, DeniedHelp, "counter", snaps, nil, func(s Snapshot) {
+		writeSample(bw, DeniedName, s.Name, "", float64(s.Denied))
+	})
+	writeFamily(bw, TokensName, TokensHelp, "gauge", snaps, isLimiter, func(s Snapshot) {
+		writeSample(bw, TokensName, s.Name, "", float64(s.Tokens))
+	})
+	writeFamily(bw, WaitName, WaitHelp, "histogram", snaps, isLimiter, func(s Snapshot) {
+		for i, b := range s.Wait.Buckets {
+			writeSample(bw, WaitName+"_bucket", s.Name, formatFloat(b), float64(s.Wait.Counts[i]))
+		}
+		writeSample(bw, WaitName+"_bucket", s.Name, "+Inf", float64(s.Wait.Count))
+		writeSample(bw, WaitName+"_sum", s.Name, "", s.Wait.Sum)
+		writeSample(bw, WaitName+"_count", s.Name, "", float64(s.Wait.Count))
+	})
+	writeFamily(bw, ActiveKeysName, ActiveKeysHelp, "gauge", snaps, hasActiveKeys, func(s Snapshot) {
+		writeSample(bw, ActiveKeysName, s.Name, "", float64(s.ActiveKeys))
+	})
+
+	return bw.Flush()
+}
+
+func isLimiter(s Snapshot) bool {
+	return !s.Keyed
+}
+
+func hasActiveKeys(s Snapshot) bool {
+	return s.Keyed && s.ActiveKeys >= 0
+}
+
+// writeFamily writes the samples of all snapshots
+// matching filter, preceded by the HELP and TYPE
+// lines if there are any.
+func writeFamily(
+	w *bufio.Writer,
+	name, help, typ string,
+	snaps []Snapshot,
+	filter func(Snapshot) bool,
+	write func(Snapshot),
+) {
+	header := false
+	for _, s := range snaps {
+		if filter != nil && !filter(s) {
+			continue
+		}
+		if !header {
+			w.WriteString("# HELP " + name + " " + help + "\n")
+			w.WriteString("# TYPE " + name + " " + typ + "\n")
+			header = true
+		}
+		write(s)
+	}
+}
+
+func writeSample(w *bufio.Writer, name, limiter, le string, v float64) {
+	w.WriteString(name)
+	w.WriteString(`{limiter="`)
+	w.WriteString(escapeLabel(limiter))
+	if le != "" {
+		w.WriteString(`",le="`)
+		w.WriteString(le)
+	}
+	w.WriteString(`"} `)
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
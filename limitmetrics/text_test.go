@@ -0,0 +1,91 @@
+package limitmetrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+func TestWriteText(t *testing.T) {
+	snaps := []Snapshot{
+		{
+			Name:    "api",
+			Allowed: 10,
+			Denied:  2,
+			Tokens:  3,
+			Wait: Histogram{
+				Buckets: []float64{0.1, 1},
+				Counts:  []uint64{1, 2},
+				Count:   3,
+				Sum:     4.5,
+			},
+		},
+		{
+			Name:       `us"ers`,
+			Keyed:      true,
+			Allowed:    1,
+			ActiveKeys: 7,
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteText(&buf, snaps); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `# HELP ratelimit_allowed_total Amount of allowed reservations and waits.
+# TYPE ratelimit_allowed_total counter
+ratelimit_allowed_total{limiter="api"} 10
+ratelimit_allowed_total{limiter="us\"ers"} 1
+# HELP ratelimit_denied_total Amount of denied reservations and failed waits.
+# TYPE ratelimit_denied_total counter
+ratelimit_denied_total{limiter="api"} 2
+ratelimit_denied_total{limiter="us\"ers"} 0
+# HELP ratelimit_tokens Amount of tokens left.
+# TYPE ratelimit_tokens gauge
+ratelimit_tokens{limiter="api"} 3
+# HELP ratelimit_wait_seconds Time spent waiting for tokens.
+# TYPE ratelimit_wait_seconds histogram
+ratelimit_wait_seconds_bucket{limiter="api",le="0.1"} 1
+ratelimit_wait_seconds_bucket{limiter="api",le="1"} 2
+ratelimit_wait_seconds_bucket{limiter="api",le="+Inf"} 3
+ratelimit_wait_seconds_sum{limiter="api"} 4.5
+ratelimit_wait_seconds_count{limiter="api"} 3
+# HELP ratelimit_active_keys Amount of keys with a bucket in the store.
+# TYPE ratelimit_active_keys gauge
+ratelimit_active_keys{limiter="us\"ers"} 7
+`
+	if buf.String() != exp {
+		t.Errorf("output should be\n%s\nbut was\n%s", exp, buf.String())
+	}
+}
+
+func TestWriteTextEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteText(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("output should be empty but was %q", buf.String())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Limiter("api", ratelimit.NewLimiter(time.Second, 1)).Allow()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("content type should be %q but was %q", ContentType, ct)
+	}
+	if line := `ratelimit_allowed_total{limiter="api"} 1`; !strings.Contains(rec.Body.String(), line) {
+		t.Errorf("body should contain %q but was %q", line, rec.Body.String())
+	}
+}
@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory implementation
+// of Store. Its buckets behave exactly like
+// the bucket of a Limiter.
+//
+// Buckets which are completely refilled are
+// equal to newly created buckets, so they are
+// removed from the store on Prune.
+type MemoryStore struct {
+	mu  sync.Mutex
+	now TimeSource
+
+	buckets map[string]*bucket
+}
+
+var _ Returner = (*MemoryStore)(nil)
+
+// NewMemoryStoreWithTimeSource returns a new
+// and empty instance of MemoryStore using the
+// given TimeSource.
+func NewMemoryStoreWithTimeSource(timeSource TimeSource) *MemoryStore {
+	return &MemoryStore{
+		now:     timeSource,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// NewMemoryStore returns a new and empty
+// instance of MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return NewMemoryStoreWithTimeSource(time.Now)
+}
+
+// TakeN implements Store#TakeN.
+func (s *MemoryStore) TakeN(_ context.Context, key string, n int, l time.Duration, b int) (bool, Reservation, error) {
+	if n <= 0 {
+		return true, Reservation{}, nil
+	}
+
+	if b <= 0 || l <= 0 {
+		return false, Reservation{}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bk, ok := s.buckets[key]
+	if !ok {
+		nb := newBucket(b)
+		bk = &nb
+		s.buckets[key] = bk
+	}
+
+	ok, res := bk.takeN(s.now(), n, l, b)
+	return ok, res, nil
+}
+
+// Tokens implements Store#Tokens.
+func (s *MemoryStore) Tokens(_ context.Context, key string, l time.Duration, b int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bk, ok := s.buckets[key]
+	if !ok {
+		return b, nil
+	}
+
+	return bk.available(s.now(), l, b), nil
+}
+
+// Reset implements Store#Reset.
+func (s *MemoryStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.buckets, key)
+	return nil
+}
+
+// ReturnN implements Returner#ReturnN.
+func (s *MemoryStore) ReturnN(_ context.Context, key string, n int, _ time.Duration, b int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bk, ok := s.buckets[key]; ok {
+		bk.returnN(n, b)
+	}
+
+	return nil
+}
+
+// Prune removes all buckets from the store
+// which would be completely refilled using
+// the limit l and burst rate b.
+func (s *MemoryStore) Prune(l time.Duration, b int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for key, bk := range s.buckets {
+		if bk.expired(now, l, b) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// Len returns the amount of buckets
+// currently held by the store.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.buckets)
+}
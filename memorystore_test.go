@@ -0,0 +1,45 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+	"github.com/zekroTJA/ratelimit/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, ts ratelimit.TimeSource) ratelimit.Store {
+		return ratelimit.NewMemoryStoreWithTimeSource(ts)
+	})
+}
+
+func TestMemoryStorePrune(t *testing.T) {
+	const limit = 100 * time.Millisecond
+	const burst = 2
+
+	c := storetest.NewClock(time.Now())
+	s := ratelimit.NewMemoryStoreWithTimeSource(c.Now)
+	kl := ratelimit.NewKeyedLimiterWithStore(s, limit, burst)
+
+	kl.AllowN("a", 2)
+	kl.Allow("b")
+
+	if n := s.Len(); n != 2 {
+		t.Fatalf("s.Len() should be %d but was %d", 2, n)
+	}
+
+	c.Advance(limit)
+	s.Prune(limit, burst)
+
+	if n := s.Len(); n != 1 {
+		t.Errorf("s.Len() should be %d but was %d", 1, n)
+	}
+
+	c.Advance(limit)
+	s.Prune(limit, burst)
+
+	if n := s.Len(); n != 0 {
+		t.Errorf("s.Len() should be %d but was %d", 0, n)
+	}
+}
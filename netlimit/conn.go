@@ -0,0 +1,61 @@
+package netlimit
+
+import (
+	"context"
+	"net"
+
+	"github.com/zekroTJA/ratelimit/iolimit"
+)
+
+// A Conn limits the read and write
+// bandwidth of a connection.
+type Conn struct {
+	net.Conn
+
+	r      *iolimit.Reader
+	w      *iolimit.Writer
+	cancel context.CancelFunc
+}
+
+// NewConn returns a new Conn which limits the reads
+// from c by read and the writes to c by write. Both
+// limiters are optional and can be nil. To combine a
+// per-connection limit with a limit shared by many
+// connections, use iolimit.All.
+//
+// Waits are aborted when the connection is closed.
+func NewConn(c net.Conn, read, write iolimit.Limiter) *Conn {
+	ctx, cancel := context.WithCancel(context.Background())
+	lc := &Conn{Conn: c, cancel: cancel}
+
+	if read != nil {
+		lc.r = iolimit.NewReader(ctx, c, read)
+	}
+	if write != nil {
+		lc.w = iolimit.NewWriter(ctx, c, write)
+	}
+
+	return lc
+}
+
+// Read implements net.Conn.
+func (c *Conn) Read(p []byte) (int, error) {
+	if c.r == nil {
+		return c.Conn.Read(p)
+	}
+	return c.r.Read(p)
+}
+
+// Write implements net.Conn.
+func (c *Conn) Write(p []byte) (int, error) {
+	if c.w == nil {
+		return c.Conn.Write(p)
+	}
+	return c.w.Write(p)
+}
+
+// Close implements net.Conn.
+func (c *Conn) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
@@ -0,0 +1,73 @@
+package netlimit
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+	"github.com/zekroTJA/ratelimit/iolimit"
+)
+
+func TestConn(t *testing.T) {
+	const limit = time.Millisecond
+	const burst = 10
+
+	shared := ratelimit.NewLimiter(limit, burst)
+
+	server, client := net.Pipe()
+	defer server.Close()
+
+	c := NewConn(client, nil, iolimit.All(ratelimit.NewLimiter(limit, 100), shared))
+
+	done := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(server)
+		done <- b
+	}()
+
+	start := time.Now()
+	if n, err := c.Write(make([]byte, 50)); n != 50 || err != nil {
+		t.Fatalf("Write() should return (50, nil) but returned (%d, %v)", n, err)
+	}
+	if d := time.Since(start); d < 40*limit {
+		t.Errorf("writing should take at least %v but took %v", 40*limit, d)
+	}
+	if tokens := shared.Tokens(); tokens > 1 {
+		t.Errorf("shared limiter should be exhausted but had %d tokens", tokens)
+	}
+
+	c.Close()
+	if b := <-done; len(b) != 50 {
+		t.Errorf("%d bytes should be received but were %d", 50, len(b))
+	}
+}
+
+func TestConnClose(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	go io.Copy(ioutil.Discard, server)
+
+	c := NewConn(client, ratelimit.NewLimiter(time.Hour, 1), ratelimit.NewLimiter(time.Hour, 1))
+	c.Write([]byte{1})
+
+	errc := make(chan error)
+	go func() {
+		_, err := c.Write([]byte{2})
+		errc <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	c.Close()
+
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Error("Write() should fail after Close")
+		}
+	case <-time.After(time.Second):
+		t.Error("Write() should be aborted by Close")
+	}
+}
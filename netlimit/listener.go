@@ -0,0 +1,330 @@
+// Package netlimit provides net.Listener and net.Conn
+// wrappers which limit the rate of accepted
+// connections and the bandwidth of connections,
+// so that TCP services can be protected without
+// changing their protocol code.
+package netlimit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+// ErrClosed is returned by Listener#Accept
+// after the listener has been closed.
+var ErrClosed = errors.New("listener closed")
+
+// DefaultMaxDelayed is the default maximum amount of
+// connections which are delayed at the same time.
+const DefaultMaxDelayed = 64
+
+// Mode defines how a Listener handles
+// connections exceeding the accept rate.
+type Mode int
+
+const (
+	// Reject closes excess connections
+	// immediately after they are accepted.
+	Reject Mode = iota
+	// Delay holds back excess connections
+	// until the rate allows to accept them.
+	Delay
+)
+
+// A Listener limits the rate of accepted connections
+// globally and per source IP address.
+//
+// In the Delay mode, the listener does not accept new
+// connections until the global limiter has a token
+// available, so that excess connections queue up in
+// the backlog of the operating system. Connections
+// exceeding the rate of their source IP are held
+// back until their bucket has a token available,
+// while connections of other source IPs are passed
+// on. When the maximum amount of held back
+// connections is reached, excess connections are
+// rejected.
+type Listener struct {
+	net.Listener
+
+	now    ratelimit.TimeSource
+	global *ratelimit.Limiter
+	perIP  *ratelimit.KeyedLimiter
+	mode   Mode
+
+	mu         sync.Mutex
+	onReject   func(net.Conn)
+	wrap       func(net.Conn) net.Conn
+	maxDelayed int
+	delayed    int
+
+	start     sync.Once
+	conns     chan net.Conn
+	errs      chan error
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+// NewListenerWithTimeSource returns a new Listener
+// with the given TimeSource which accepts connections
+// from l. The accept rate is limited by global and
+// per source IP by perIP. Both limiters are optional
+// and can be nil. timeSource must be the TimeSource
+// of perIP.
+func NewListenerWithTimeSource(timeSource ratelimit.TimeSource, l net.Listener, global *ratelimit.Limiter, perIP *ratelimit.KeyedLimiter, mode Mode) *Listener {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Listener{
+		Listener:   l,
+		now:        timeSource,
+		global:     global,
+		perIP:      perIP,
+		mode:       mode,
+		maxDelayed: DefaultMaxDelayed,
+		conns:      make(chan net.Conn),
+		errs:       make(chan error),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// NewListener returns a new Listener which accepts
+// connections from l. The accept rate is limited
+// by global and per source IP by perIP. Both
+// limiters are optional and can be nil.
+func NewListener(l net.Listener, global *ratelimit.Limiter, perIP *ratelimit.KeyedLimiter, mode Mode) *Listener {
+	return NewListenerWithTimeSource(time.Now, l, global, perIP, mode)
+}
+
+// SetRejectHandler sets a function which is called
+// with each rejected connection before it is closed,
+// for example to write an error message.
+func (l *Listener) SetRejectHandler(h func(net.Conn)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onReject = h
+}
+
+// SetConnWrapper sets a function which wraps each
+// accepted connection, for example with NewConn to
+// limit its bandwidth.
+func (l *Listener) SetConnWrapper(wrap func(net.Conn) net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.wrap = wrap
+}
+
+// SetMaxDelayed sets the maximum amount of
+// connections which are held back at the same
+// time in the Delay mode. By default,
+// DefaultMaxDelayed is used.
+func (l *Listener) SetMaxDelayed(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxDelayed = n
+}
+
+// Accept implements net.Listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	if l.mode == Delay {
+		l.start.Do(func() { go l.run() })
+
+		select {
+		case c := <-l.conns:
+			return c, nil
+		case err := <-l.errs:
+			return nil, err
+		case <-l.ctx.Done():
+			return nil, ErrClosed
+		}
+	}
+
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := remoteIP(c)
+		if l.perIP == nil || l.perIP.Allow(ip) {
+			if l.global == nil || l.global.Allow() {
+				return l.wrapConn(c), nil
+			}
+			// The connection is rejected by the global
+			// limiter, so the token of ip is given back.
+			if l.perIP != nil {
+				l.perIP.ReturnNContext(context.Background(), ip, 1)
+			}
+		}
+
+		l.reject(c)
+	}
+}
+
+// Close implements net.Listener. Connections
+// which are held back are closed.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(l.cancel)
+	return l.Listener.Close()
+}
+
+// run accepts connections in the Delay mode
+// until the listener is closed. If the global
+// limiter never generates tokens, like with a
+// limit or burst rate <= 0, its error is returned
+// by Accept until the listener is closed.
+func (l *Listener) run() {
+	for {
+		if l.global != nil {
+			if err := l.global.Wait(l.ctx); err != nil {
+				if l.ctx.Err() == nil {
+					l.fail(err)
+				}
+				return
+			}
+		}
+
+		c, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+				continue
+			case <-l.ctx.Done():
+				return
+			}
+		}
+
+		ip := remoteIP(c)
+		if l.perIP == nil || l.perIP.Allow(ip) {
+			l.deliver(c)
+			continue
+		}
+
+		if !l.acquireDelayed() {
+			l.reject(c)
+			continue
+		}
+
+		go func() {
+			defer l.releaseDelayed()
+			if l.waitIP(ip) {
+				l.deliver(c)
+			} else if l.ctx.Err() == nil {
+				l.reject(c)
+			} else {
+				c.Close()
+			}
+		}()
+	}
+}
+
+// waitIP blocks until the bucket of ip has a
+// token available and consumes it. False is
+// returned if the listener has been closed, the
+// limiter failed or the bucket never generates
+// tokens, like with a limit or burst rate <= 0.
+func (l *Listener) waitIP(ip string) bool {
+	for {
+		ok, res, err := l.perIP.Reserve(ip)
+		if err != nil {
+			return false
+		}
+		if ok {
+			return true
+		}
+		if res.Reset.Time.IsZero() {
+			return false
+		}
+
+		t := time.NewTimer(res.Reset.Sub(l.now()))
+		select {
+		case <-t.C:
+		case <-l.ctx.Done():
+			t.Stop()
+			return false
+		}
+	}
+}
+
+// fail passes err to each call of Accept
+// until the listener is closed.
+func (l *Listener) fail(err error) {
+	for {
+		select {
+		case l.errs <- err:
+		case <-l.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver passes c to Accept. If the listener
+// is closed in the meantime, c is closed.
+func (l *Listener) deliver(c net.Conn) {
+	select {
+	case l.conns <- l.wrapConn(c):
+	case <-l.ctx.Done():
+		c.Close()
+	}
+}
+
+func (l *Listener) acquireDelayed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.delayed >= l.maxDelayed {
+		return false
+	}
+	l.delayed++
+	return true
+}
+
+func (l *Listener) releaseDelayed() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.delayed--
+}
+
+func (l *Listener) wrapConn(c net.Conn) net.Conn {
+	l.mu.Lock()
+	wrap := l.wrap
+	l.mu.Unlock()
+
+	if wrap == nil {
+		return c
+	}
+	return wrap(c)
+}
+
+func (l *Listener) reject(c net.Conn) {
+	l.mu.Lock()
+	h := l.onReject
+	l.mu.Unlock()
+
+	if h != nil {
+		h(c)
+	}
+	c.Close()
+}
+
+// remoteIP returns the IP address of
+// the remote address of c.
+func remoteIP(c net.Conn) string {
+	switch addr := c.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case nil:
+		return ""
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return addr.String()
+		}
+		return host
+	}
+}
@@ -0,0 +1,255 @@
+package netlimit
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+// listen returns a new Listener on a local TCP
+// port and a channel of the accepted connections.
+func listen(t *testing.T, global *ratelimit.Limiter, perIP *ratelimit.KeyedLimiter, mode Mode) (*Listener, <-chan net.Conn) {
+	return listenWithTimeSource(t, time.Now, global, perIP, mode)
+}
+
+func listenWithTimeSource(
+	t *testing.T,
+	timeSource ratelimit.TimeSource,
+	global *ratelimit.Limiter,
+	perIP *ratelimit.KeyedLimiter,
+	mode Mode,
+) (*Listener, <-chan net.Conn) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewListenerWithTimeSource(timeSource, inner, global, perIP, mode)
+	t.Cleanup(func() { l.Close() })
+
+	conns := make(chan net.Conn, 16)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- c
+		}
+	}()
+
+	return l, conns
+}
+
+func dial(t *testing.T, l net.Listener) net.Conn {
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func accepted(conns <-chan net.Conn, timeout time.Duration) bool {
+	select {
+	case c := <-conns:
+		c.Close()
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestListenerReject(t *testing.T) {
+	l, conns := listen(t, nil, ratelimit.NewKeyedLimiter(time.Hour, 2), Reject)
+
+	rejected := make(chan struct{}, 1)
+	l.SetRejectHandler(func(c net.Conn) {
+		c.Write([]byte("busy"))
+		rejected <- struct{}{}
+	})
+
+	for i := 0; i < 2; i++ {
+		dial(t, l)
+		if !accepted(conns, time.Second) {
+			t.Fatalf("connection %d should be accepted", i)
+		}
+	}
+
+	c := dial(t, l)
+	select {
+	case <-rejected:
+	case <-time.After(time.Second):
+		t.Fatal("connection should be rejected")
+	}
+	if msg, _ := ioutil.ReadAll(c); string(msg) != "busy" {
+		t.Errorf("rejected connection should receive %q but received %q", "busy", msg)
+	}
+	if accepted(conns, 50*time.Millisecond) {
+		t.Error("rejected connection should not be accepted")
+	}
+}
+
+func TestListenerGlobalReject(t *testing.T) {
+	l, conns := listen(t, ratelimit.NewLimiter(time.Hour, 1), nil, Reject)
+
+	dial(t, l)
+	if !accepted(conns, time.Second) {
+		t.Fatal("connection should be accepted")
+	}
+
+	dial(t, l)
+	if accepted(conns, 50*time.Millisecond) {
+		t.Error("connection exceeding the global rate should not be accepted")
+	}
+}
+
+func TestListenerGlobalRejectKeepsIPToken(t *testing.T) {
+	perIP := ratelimit.NewKeyedLimiter(time.Hour, 2)
+	l, conns := listen(t, ratelimit.NewLimiter(time.Hour, 1), perIP, Reject)
+
+	dial(t, l)
+	if !accepted(conns, time.Second) {
+		t.Fatal("connection should be accepted")
+	}
+
+	rejected := make(chan struct{}, 1)
+	l.SetRejectHandler(func(net.Conn) { rejected <- struct{}{} })
+
+	dial(t, l)
+	select {
+	case <-rejected:
+	case <-time.After(time.Second):
+		t.Fatal("connection should be rejected")
+	}
+
+	// The connection rejected by the global limiter
+	// must not take a token of its source IP.
+	if n, _ := perIP.Tokens("127.0.0.1"); n != 1 {
+		t.Errorf("tokens of the source IP should be %d but were %d", 1, n)
+	}
+}
+
+func TestListenerDelay(t *testing.T) {
+	const limit = 100 * time.Millisecond
+
+	l, conns := listen(t, nil, ratelimit.NewKeyedLimiter(limit, 1), Delay)
+
+	start := time.Now()
+	dial(t, l)
+	dial(t, l)
+
+	for i := 0; i < 2; i++ {
+		if !accepted(conns, time.Second) {
+			t.Fatalf("connection %d should be accepted", i)
+		}
+	}
+	if d := time.Since(start); d < limit-10*time.Millisecond {
+		t.Errorf("second connection should be delayed by %v but took %v", limit, d)
+	}
+
+	l.SetMaxDelayed(0)
+	time.Sleep(limit)
+	dial(t, l)
+	dial(t, l)
+	if !accepted(conns, time.Second) {
+		t.Fatal("connection should be accepted")
+	}
+	if accepted(conns, 2*limit) {
+		t.Error("connection exceeding the maximum delayed connections should be rejected")
+	}
+}
+
+func TestListenerDelayNoTokens(t *testing.T) {
+	// A bucket without burst never generates tokens,
+	// so delayed connections must be rejected instead
+	// of being retried endlessly.
+	l, conns := listen(t, nil, ratelimit.NewKeyedLimiter(time.Second, 0), Delay)
+
+	rejected := make(chan struct{}, 1)
+	l.SetRejectHandler(func(net.Conn) { rejected <- struct{}{} })
+
+	dial(t, l)
+	select {
+	case <-rejected:
+	case <-time.After(time.Second):
+		t.Fatal("connection should be rejected")
+	}
+	if accepted(conns, 50*time.Millisecond) {
+		t.Error("connection should not be accepted")
+	}
+}
+
+func TestListenerDelayTimeSource(t *testing.T) {
+	const limit = 100 * time.Millisecond
+
+	// The time source of the limiter is far ahead of
+	// the wall clock, so the delay must be computed
+	// using the time source.
+	skewed := func() time.Time { return time.Now().Add(24 * time.Hour) }
+	l, conns := listenWithTimeSource(t, skewed, nil,
+		ratelimit.NewKeyedLimiterWithTimeSource(skewed, limit, 1), Delay)
+
+	dial(t, l)
+	dial(t, l)
+
+	for i := 0; i < 2; i++ {
+		if !accepted(conns, time.Second) {
+			t.Fatalf("connection %d should be accepted", i)
+		}
+	}
+}
+
+func TestListenerGlobalDelayNoTokens(t *testing.T) {
+	// A global limiter without burst never generates
+	// tokens, so Accept must fail instead of blocking
+	// until the listener is closed.
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(inner, ratelimit.NewLimiter(time.Second, 0), nil, Delay)
+	defer l.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		errs <- err
+	}()
+
+	select {
+	case err := <-errs:
+		if err != ratelimit.ErrExceedsBurst {
+			t.Errorf("Accept() should return %v but returned %v", ratelimit.ErrExceedsBurst, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept() should not block")
+	}
+}
+
+func TestListenerGlobalDelay(t *testing.T) {
+	const limit = 100 * time.Millisecond
+
+	l, conns := listen(t, ratelimit.NewLimiter(limit, 1), nil, Delay)
+
+	start := time.Now()
+	dial(t, l)
+	dial(t, l)
+
+	for i := 0; i < 2; i++ {
+		if !accepted(conns, time.Second) {
+			t.Fatalf("connection %d should be accepted", i)
+		}
+	}
+	if d := time.Since(start); d < limit-10*time.Millisecond {
+		t.Errorf("second connection should be delayed by %v but took %v", limit, d)
+	}
+
+	l.Close()
+	if _, err := l.Accept(); err != ErrClosed {
+		t.Errorf("Accept() should return %v after Close but returned %v", ErrClosed, err)
+	}
+}
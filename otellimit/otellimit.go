@@ -0,0 +1,275 @@
+// Package otellimit instruments limiters using the
+// OpenTelemetry API.
+//
+// Denied reservations are recorded as events on the
+// span of the context and counted by a metric, and
+// waits blocking for tokens are recorded as spans and
+// in a histogram, so that throttling shows up in
+// distributed traces. Without configured providers,
+// the OpenTelemetry API is a no-op and so is the
+// instrumentation.
+package otellimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the name of the instrumentation
+// scope of the tracer and the meter.
+const ScopeName = "github.com/zekroTJA/ratelimit/otellimit"
+
+// DefaultWaitThreshold is the default duration after
+// which a wait is recorded as span.
+const DefaultWaitThreshold = time.Millisecond
+
+// Attribute keys of the spans, events and metrics.
+// Only the policy is attached to metrics.
+const (
+	KeyKey       = attribute.Key("ratelimit.key")
+	PolicyKey    = attribute.Key("ratelimit.policy")
+	TokensKey    = attribute.Key("ratelimit.tokens")
+	RemainingKey = attribute.Key("ratelimit.remaining")
+	ResetKey     = attribute.Key("ratelimit.reset")
+)
+
+// Instrumentation creates instrumented limiters
+// using a tracer and a meter.
+type Instrumentation struct {
+	tracer    trace.Tracer
+	denied    metric.Int64Counter
+	wait      metric.Float64Histogram
+	threshold time.Duration
+}
+
+// NewWithProviders returns a new Instrumentation
+// using the given tracer and meter providers.
+func NewWithProviders(tp trace.TracerProvider, mp metric.MeterProvider) *Instrumentation {
+	meter := mp.Meter(ScopeName)
+	noop := metricnoop.Meter{}
+
+	denied, err := meter.Int64Counter("ratelimit.denied",
+		metric.WithDescription("Amount of denied reservations."),
+		metric.WithUnit("{reservation}"))
+	if err != nil {
+		otel.Handle(err)
+		denied, _ = noop.Int64Counter("")
+	}
+
+	wait, err := meter.Float64Histogram("ratelimit.wait.duration",
+		metric.WithDescription("Time spent waiting for tokens."),
+		metric.WithUnit("s"))
+	if err != nil {
+		otel.Handle(err)
+		wait, _ = noop.Float64Histogram("")
+	}
+
+	return &Instrumentation{
+		tracer:    tp.Tracer(ScopeName),
+		denied:    denied,
+		wait:      wait,
+		threshold: DefaultWaitThreshold,
+	}
+}
+
+// New returns a new Instrumentation using the global
+// tracer and meter providers. If they are not
+// configured, the instrumentation is a no-op.
+func New() *Instrumentation {
+	return NewWithProviders(otel.GetTracerProvider(), otel.GetMeterProvider())
+}
+
+// SetWaitThreshold sets the duration after which a wait
+// is recorded as span, so that waits which do not block
+// do not clutter traces. Failed waits are always
+// recorded. It only applies to limiters created
+// afterwards.
+func (i *Instrumentation) SetWaitThreshold(d time.Duration) {
+	i.threshold = d
+}
+
+// Limiter returns a wrapper of l which is instrumented
+// using the given policy name.
+func (i *Instrumentation) Limiter(policy string, l *ratelimit.Limiter) *Limiter {
+	return &Limiter{Limiter: l, r: i.recorder(policy)}
+}
+
+// KeyedLimiter returns a wrapper of kl which is
+// instrumented using the given policy name.
+func (i *Instrumentation) KeyedLimiter(policy string, kl *ratelimit.KeyedLimiter) *KeyedLimiter {
+	return &KeyedLimiter{KeyedLimiter: kl, r: i.recorder(policy)}
+}
+
+func (i *Instrumentation) recorder(policy string) *recorder {
+	return &recorder{
+		i:         i,
+		threshold: i.threshold,
+		policy:    PolicyKey.String(policy),
+		metricSet: metric.WithAttributeSet(attribute.NewSet(PolicyKey.String(policy))),
+	}
+}
+
+// recorder records the decisions of a limiter.
+type recorder struct {
+	i         *Instrumentation
+	threshold time.Duration
+	policy    attribute.KeyValue
+	metricSet metric.MeasurementOption
+}
+
+// denied counts a denied reservation and adds an
+// event to the span of ctx. key is empty for
+// limiters which are not keyed.
+func (r *recorder) denied(ctx context.Context, key string, n int, res ratelimit.Reservation) {
+	r.i.denied.Add(ctx, 1, r.metricSet)
+
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	attrs := append(r.attrs(key, n), reservationAttrs(res)...)
+	span.AddEvent("ratelimit.denied", trace.WithAttributes(attrs...))
+}
+
+// waited records a wait for n tokens which has
+// started at start and ended with err.
+func (r *recorder) waited(ctx context.Context, n int, start time.Time, err error) {
+	end := time.Now()
+	d := end.Sub(start)
+	r.i.wait.Record(ctx, d.Seconds(), r.metricSet)
+
+	if err == nil && d < r.threshold {
+		return
+	}
+
+	_, span := r.i.tracer.Start(ctx, "ratelimit.Wait",
+		trace.WithTimestamp(start),
+		trace.WithAttributes(r.attrs("", n)...))
+	if err != nil {
+		span.RecordError(err, trace.WithTimestamp(end))
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+func (r *recorder) attrs(key string, n int) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{r.policy, TokensKey.Int(n)}
+	if key != "" {
+		attrs = append(attrs, KeyKey.String(key))
+	}
+	return attrs
+}
+
+func reservationAttrs(res ratelimit.Reservation) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{RemainingKey.Int(res.Remaining)}
+	if !res.Reset.IsNil() {
+		attrs = append(attrs, ResetKey.String(res.Reset.Time.Format(time.RFC3339Nano)))
+	}
+	return attrs
+}
+
+// Limiter wraps a ratelimit.Limiter
+// and records its decisions.
+type Limiter struct {
+	*ratelimit.Limiter
+	r *recorder
+}
+
+// ReserveNContext behaves like
+// ratelimit.Limiter#ReserveN. If the reservation
+// is denied, an event is added to the span of ctx.
+func (l *Limiter) ReserveNContext(ctx context.Context, n int) (bool, ratelimit.Reservation) {
+	ok, res := l.Limiter.ReserveN(n)
+	if !ok {
+		l.r.denied(ctx, "", n, res)
+	}
+	return ok, res
+}
+
+// ReserveN behaves like ratelimit.Limiter#ReserveN.
+func (l *Limiter) ReserveN(n int) (bool, ratelimit.Reservation) {
+	return l.ReserveNContext(context.Background(), n)
+}
+
+// Reserve behaves like ratelimit.Limiter#Reserve.
+func (l *Limiter) Reserve() (bool, ratelimit.Reservation) {
+	return l.ReserveN(1)
+}
+
+// AllowN behaves like ratelimit.Limiter#AllowN.
+func (l *Limiter) AllowN(n int) bool {
+	ok, _ := l.ReserveN(n)
+	return ok
+}
+
+// Allow behaves like ratelimit.Limiter#Allow.
+func (l *Limiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+// WaitN behaves like ratelimit.Limiter#WaitN. If the
+// wait takes longer than the wait threshold or fails,
+// it is recorded as child span of the span of ctx.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	start := time.Now()
+	err := l.Limiter.WaitN(ctx, n)
+	l.r.waited(ctx, n, start, err)
+	return err
+}
+
+// Wait behaves like ratelimit.Limiter#Wait.
+func (l *Limiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// KeyedLimiter wraps a ratelimit.KeyedLimiter
+// and records its decisions.
+type KeyedLimiter struct {
+	*ratelimit.KeyedLimiter
+	r *recorder
+}
+
+// ReserveNContext behaves like
+// ratelimit.KeyedLimiter#ReserveNContext. If the
+// reservation is denied, an event is added to the
+// span of ctx.
+func (kl *KeyedLimiter) ReserveNContext(ctx context.Context, key string, n int) (bool, ratelimit.Reservation, error) {
+	ok, res, err := kl.KeyedLimiter.ReserveNContext(ctx, key, n)
+	if err == nil && !ok {
+		kl.r.denied(ctx, key, n, res)
+	}
+	return ok, res, err
+}
+
+// ReserveN behaves like
+// ratelimit.KeyedLimiter#ReserveN.
+func (kl *KeyedLimiter) ReserveN(key string, n int) (bool, ratelimit.Reservation, error) {
+	return kl.ReserveNContext(context.Background(), key, n)
+}
+
+// Reserve behaves like
+// ratelimit.KeyedLimiter#Reserve.
+func (kl *KeyedLimiter) Reserve(key string) (bool, ratelimit.Reservation, error) {
+	return kl.ReserveN(key, 1)
+}
+
+// AllowN behaves like
+// ratelimit.KeyedLimiter#AllowN.
+func (kl *KeyedLimiter) AllowN(key string, n int) bool {
+	ok, _, err := kl.ReserveN(key, n)
+	return ok && err == nil
+}
+
+// Allow behaves like
+// ratelimit.KeyedLimiter#Allow.
+func (kl *KeyedLimiter) Allow(key string) bool {
+	return kl.AllowN(key, 1)
+}
@@ -0,0 +1,171 @@
+package otellimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type providers struct {
+	spans   *tracetest.SpanRecorder
+	tracer  *sdktrace.TracerProvider
+	metrics *sdkmetric.ManualReader
+}
+
+func newInstrumentation(t *testing.T) (*Instrumentation, *providers) {
+	p := &providers{
+		spans:   tracetest.NewSpanRecorder(),
+		metrics: sdkmetric.NewManualReader(),
+	}
+	p.tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(p.spans))
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(p.metrics))
+	t.Cleanup(func() {
+		p.tracer.Shutdown(context.Background())
+		mp.Shutdown(context.Background())
+	})
+	return NewWithProviders(p.tracer, mp), p
+}
+
+func (p *providers) metric(t *testing.T, name string) metricdata.Aggregation {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := p.metrics.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return m.Data
+			}
+		}
+	}
+	t.Fatalf("metric %s was not recorded", name)
+	return nil
+}
+
+func attrMap(attrs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value, len(attrs))
+	for _, a := range attrs {
+		m[a.Key] = a.Value
+	}
+	return m
+}
+
+func TestKeyedLimiterDenied(t *testing.T) {
+	i, p := newInstrumentation(t)
+	kl := i.KeyedLimiter("users", ratelimit.NewKeyedLimiter(time.Hour, 1))
+
+	ctx, span := p.tracer.Tracer("test").Start(context.Background(), "request")
+	if ok, _, _ := kl.ReserveNContext(ctx, "alice", 1); !ok {
+		t.Fatal("first reservation should be allowed")
+	}
+	if ok, _, _ := kl.ReserveNContext(ctx, "alice", 1); ok {
+		t.Fatal("second reservation should be denied")
+	}
+	span.End()
+
+	ended := p.spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("1 span should be ended but were %d", len(ended))
+	}
+	events := ended[0].Events()
+	if len(events) != 1 || events[0].Name != "ratelimit.denied" {
+		t.Fatalf("span should have one denied event but had %+v", events)
+	}
+	attrs := attrMap(events[0].Attributes)
+	if v := attrs[KeyKey].AsString(); v != "alice" {
+		t.Errorf("key should be alice but was %q", v)
+	}
+	if v := attrs[PolicyKey].AsString(); v != "users" {
+		t.Errorf("policy should be users but was %q", v)
+	}
+	if v := attrs[RemainingKey].AsInt64(); v != 0 {
+		t.Errorf("remaining should be 0 but was %d", v)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, attrs[ResetKey].AsString()); err != nil {
+		t.Errorf("reset should be a timestamp: %v", err)
+	}
+
+	sum := p.metric(t, "ratelimit.denied").(metricdata.Sum[int64])
+	if len(sum.DataPoints) != 1 || sum.DataPoints[0].Value != 1 {
+		t.Fatalf("one denied reservation should be counted but were %+v", sum.DataPoints)
+	}
+	if v, _ := sum.DataPoints[0].Attributes.Value(PolicyKey); v.AsString() != "users" {
+		t.Errorf("policy of metric should be users but was %q", v.AsString())
+	}
+	if sum.DataPoints[0].Attributes.HasValue(KeyKey) {
+		t.Error("metric must not have the key attribute")
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	i, p := newInstrumentation(t)
+	l := i.Limiter("upstream", ratelimit.NewLimiter(10*time.Millisecond, 1))
+
+	ctx := context.Background()
+	if err := l.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(p.spans.Ended()); n != 0 {
+		t.Fatalf("wait without blocking should not be recorded but %d spans were", n)
+	}
+
+	if err := l.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ended := p.spans.Ended()
+	if len(ended) != 1 || ended[0].Name() != "ratelimit.Wait" {
+		t.Fatalf("blocking wait should be recorded as span but spans were %+v", ended)
+	}
+	if d := ended[0].EndTime().Sub(ended[0].StartTime()); d < 5*time.Millisecond {
+		t.Errorf("span should last the wait but lasted %v", d)
+	}
+
+	l.SetLimit(time.Hour)
+	dctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if err := l.Wait(dctx); err == nil {
+		t.Fatal("wait should fail")
+	}
+	ended = p.spans.Ended()
+	if len(ended) != 2 || ended[1].Status().Code != codes.Error {
+		t.Fatalf("failed wait should be recorded as failed span but spans were %+v", ended)
+	}
+
+	hist := p.metric(t, "ratelimit.wait.duration").(metricdata.Histogram[float64])
+	if len(hist.DataPoints) != 1 || hist.DataPoints[0].Count != 3 {
+		t.Fatalf("3 waits should be recorded but were %+v", hist.DataPoints)
+	}
+}
+
+func TestLimiterDenied(t *testing.T) {
+	i, p := newInstrumentation(t)
+	l := i.Limiter("upstream", ratelimit.NewLimiter(time.Hour, 1))
+
+	l.Allow()
+	l.Allow()
+	l.Reserve()
+
+	sum := p.metric(t, "ratelimit.denied").(metricdata.Sum[int64])
+	if len(sum.DataPoints) != 1 || sum.DataPoints[0].Value != 2 {
+		t.Fatalf("2 denied reservations should be counted but were %+v", sum.DataPoints)
+	}
+}
+
+func TestNoop(t *testing.T) {
+	// Without configured global providers,
+	// the instrumentation does nothing.
+	kl := New().KeyedLimiter("users", ratelimit.NewKeyedLimiter(time.Hour, 1))
+	kl.Allow("a")
+	if kl.Allow("a") {
+		t.Error("second reservation should be denied")
+	}
+}
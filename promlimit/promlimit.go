@@ -0,0 +1,74 @@
+// Package promlimit exposes the metrics of a
+// limitmetrics.Registry as prometheus.Collector.
+package promlimit
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zekroTJA/ratelimit/limitmetrics"
+)
+
+var labels = []string{"limiter"}
+
+var (
+	allowedDesc = prometheus.NewDesc(
+		limitmetrics.AllowedName, limitmetrics.AllowedHelp, labels, nil)
+	deniedDesc = prometheus.NewDesc(
+		limitmetrics.DeniedName, limitmetrics.DeniedHelp, labels, nil)
+	tokensDesc = prometheus.NewDesc(
+		limitmetrics.TokensName, limitmetrics.TokensHelp, labels, nil)
+	waitDesc = prometheus.NewDesc(
+		limitmetrics.WaitName, limitmetrics.WaitHelp, labels, nil)
+	activeKeysDesc = prometheus.NewDesc(
+		limitmetrics.ActiveKeysName, limitmetrics.ActiveKeysHelp, labels, nil)
+)
+
+// Collector collects the metrics of all
+// limiters of a limitmetrics.Registry.
+type Collector struct {
+	r *limitmetrics.Registry
+}
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector returns a new Collector
+// collecting the metrics of r.
+func NewCollector(r *limitmetrics.Registry) *Collector {
+	return &Collector{r: r}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- allowedDesc
+	ch <- deniedDesc
+	ch <- tokensDesc
+	ch <- waitDesc
+	ch <- activeKeysDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.r.Snapshot() {
+		ch <- prometheus.MustNewConstMetric(
+			allowedDesc, prometheus.CounterValue, float64(s.Allowed), s.Name)
+		ch <- prometheus.MustNewConstMetric(
+			deniedDesc, prometheus.CounterValue, float64(s.Denied), s.Name)
+
+		if !s.Keyed {
+			ch <- prometheus.MustNewConstMetric(
+				tokensDesc, prometheus.GaugeValue, float64(s.Tokens), s.Name)
+			ch <- prometheus.MustNewConstHistogram(
+				waitDesc, s.Wait.Count, s.Wait.Sum, buckets(s.Wait), s.Name)
+		} else if s.ActiveKeys >= 0 {
+			ch <- prometheus.MustNewConstMetric(
+				activeKeysDesc, prometheus.GaugeValue, float64(s.ActiveKeys), s.Name)
+		}
+	}
+}
+
+func buckets(h limitmetrics.Histogram) map[float64]uint64 {
+	b := make(map[float64]uint64, len(h.Buckets))
+	for i, ub := range h.Buckets {
+		b[ub] = h.Counts[i]
+	}
+	return b
+}
@@ -0,0 +1,73 @@
+package promlimit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/zekroTJA/ratelimit"
+	"github.com/zekroTJA/ratelimit/limitmetrics"
+)
+
+func TestCollector(t *testing.T) {
+	r := limitmetrics.NewRegistry()
+	r.SetBuckets([]float64{1})
+
+	l := r.Limiter("api", ratelimit.NewLimiter(time.Hour, 2))
+	l.Allow()
+	l.Allow()
+	l.Allow()
+
+	kl := r.KeyedLimiter("users", ratelimit.NewKeyedLimiter(time.Hour, 1))
+	kl.Allow("a")
+	kl.Allow("b")
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewCollector(r))
+
+	exp := `# HELP ratelimit_active_keys Amount of keys with a bucket in the store.
+# TYPE ratelimit_active_keys gauge
+ratelimit_active_keys{limiter="users"} 2
+# HELP ratelimit_allowed_total Amount of allowed reservations and waits.
+# TYPE ratelimit_allowed_total counter
+ratelimit_allowed_total{limiter="api"} 2
+ratelimit_allowed_total{limiter="users"} 2
+# HELP ratelimit_denied_total Amount of denied reservations and failed waits.
+# TYPE ratelimit_denied_total counter
+ratelimit_denied_total{limiter="api"} 1
+ratelimit_denied_total{limiter="users"} 0
+# HELP ratelimit_tokens Amount of tokens left.
+# TYPE ratelimit_tokens gauge
+ratelimit_tokens{limiter="api"} 0
+# HELP ratelimit_wait_seconds Time spent waiting for tokens.
+# TYPE ratelimit_wait_seconds histogram
+ratelimit_wait_seconds_bucket{limiter="api",le="1"} 0
+ratelimit_wait_seconds_bucket{limiter="api",le="+Inf"} 0
+ratelimit_wait_seconds_sum{limiter="api"} 0
+ratelimit_wait_seconds_count{limiter="api"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(exp)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectorText(t *testing.T) {
+	// The collector and the text writer of
+	// limitmetrics must expose the same metrics.
+	r := limitmetrics.NewRegistry()
+	r.Limiter("api", ratelimit.NewLimiter(time.Hour, 2)).Allow()
+	r.KeyedLimiter("users", ratelimit.NewKeyedLimiter(time.Hour, 1)).Allow("a")
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewCollector(r))
+
+	var text strings.Builder
+	if err := r.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(text.String())); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,155 @@
+// Package redisstore provides an implementation of
+// ratelimit.Store which keeps the state of the token
+// buckets in Redis, so that multiple instances of a
+// service can share the same limits.
+//
+// Each consumption is performed atomically in a
+// single Lua script using the time of the Redis
+// server, so the clocks of the clients do not
+// need to be synchronized. Keys expire as soon as
+// their bucket would be completely refilled.
+package redisstore
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zekroTJA/ratelimit"
+)
+
+// DefaultPrefix is the prefix which is
+// prepended to all keys when no prefix
+// was passed to New.
+const DefaultPrefix = "ratelimit:"
+
+var (
+	//go:embed take.lua
+	takeSource string
+	//go:embed return.lua
+	returnSource string
+
+	takeScript   = redis.NewScript(takeSource)
+	returnScript = redis.NewScript(returnSource)
+)
+
+// ErrInvalidResponse is returned when the
+// response of the script could not be parsed.
+var ErrInvalidResponse = errors.New("invalid script response")
+
+// Store implements ratelimit.Store using Redis.
+type Store struct {
+	client redis.Cmdable
+	prefix string
+}
+
+var _ ratelimit.Returner = (*Store)(nil)
+
+// New returns a new instance of Store using the
+// given Redis client. The prefix is prepended to
+// all keys. If prefix is empty, DefaultPrefix is
+// used.
+func New(client redis.Cmdable, prefix string) *Store {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+
+	return &Store{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// TakeN implements ratelimit.Store#TakeN.
+func (s *Store) TakeN(ctx context.Context, key string, n int, l time.Duration, b int) (bool, ratelimit.Reservation, error) {
+	if n <= 0 {
+		return true, ratelimit.Reservation{}, nil
+	}
+
+	if b <= 0 || l <= 0 {
+		return false, ratelimit.Reservation{}, nil
+	}
+
+	ok, tokens, last, err := s.run(ctx, key, n, l, b)
+	if err != nil {
+		return false, ratelimit.Reservation{}, err
+	}
+
+	var reset time.Time
+	if !ok || tokens == 0 {
+		reset = last.Add(l)
+	}
+
+	return ok, ratelimit.NewReservation(b, tokens, reset), nil
+}
+
+// Tokens implements ratelimit.Store#Tokens.
+func (s *Store) Tokens(ctx context.Context, key string, l time.Duration, b int) (int, error) {
+	_, tokens, _, err := s.run(ctx, key, 0, l, b)
+	return tokens, err
+}
+
+// Reset implements ratelimit.Store#Reset.
+func (s *Store) Reset(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.prefix+key).Err()
+}
+
+// ReturnN implements ratelimit.Returner#ReturnN.
+func (s *Store) ReturnN(ctx context.Context, key string, n int, _ time.Duration, b int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	return returnScript.Run(ctx, s.client, []string{s.prefix + key}, n, b).Err()
+}
+
+// run executes the take script for the given key and
+// returns the result of the consumption, the
+// remaining tokens and the time of the last
+// consumption.
+func (s *Store) run(ctx context.Context, key string, n int, l time.Duration, b int) (bool, int, time.Time, error) {
+	limit := l.Microseconds()
+	if limit < 1 {
+		limit = 1
+	}
+
+	ttl := (l*time.Duration(b) + time.Millisecond - 1) / time.Millisecond
+	if ttl < 1 {
+		ttl = 1
+	}
+
+	v, err := takeScript.Run(ctx, s.client, []string{s.prefix + key},
+		n, limit, b, int64(ttl)).Slice()
+	if err != nil {
+		return false, 0, time.Time{}, err
+	}
+
+	if len(v) != 3 {
+		return false, 0, time.Time{}, ErrInvalidResponse
+	}
+
+	ok, okOk := v[0].(int64)
+	tokens, tokensOk := v[1].(int64)
+	lastStr, lastOk := v[2].(string)
+	if !okOk || !tokensOk || !lastOk {
+		return false, 0, time.Time{}, ErrInvalidResponse
+	}
+
+	lastUs, err := strconv.ParseInt(lastStr, 10, 64)
+	if err != nil {
+		return false, 0, time.Time{}, fmt.Errorf("%w: %s", ErrInvalidResponse, err)
+	}
+
+	// A bucket which was never consumed has the last
+	// consumption time time.Time{}, like a Limiter.
+	var last time.Time
+	if lastUs != 0 {
+		last = time.UnixMicro(lastUs)
+	}
+
+	return ok == 1, int(tokens), last, nil
+}
@@ -0,0 +1,185 @@
+package redisstore
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/zekroTJA/ratelimit"
+	"github.com/zekroTJA/ratelimit/storetest"
+)
+
+// clockStore sets the time of the miniredis
+// instance to the time of the test clock
+// before each operation.
+type clockStore struct {
+	*Store
+
+	m   *miniredis.Miniredis
+	now ratelimit.TimeSource
+}
+
+func (s clockStore) TakeN(ctx context.Context, key string, n int, l time.Duration, b int) (bool, ratelimit.Reservation, error) {
+	s.m.SetTime(s.now())
+	return s.Store.TakeN(ctx, key, n, l, b)
+}
+
+func (s clockStore) Tokens(ctx context.Context, key string, l time.Duration, b int) (int, error) {
+	s.m.SetTime(s.now())
+	return s.Store.Tokens(ctx, key, l, b)
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, ts ratelimit.TimeSource) ratelimit.Store {
+		m := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+		t.Cleanup(func() { client.Close() })
+		return clockStore{Store: New(client, ""), m: m, now: ts}
+	})
+}
+
+func TestStoreTTL(t *testing.T) {
+	const limit = time.Second
+	const burst = 3
+
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	m.SetTime(time.Now())
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	defer client.Close()
+
+	s := New(client, "test:")
+
+	if _, _, err := s.TakeN(ctx, "a", 1, limit, burst); err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Exists("test:a") {
+		t.Fatal("key test:a should exist")
+	}
+
+	if ttl := m.TTL("test:a"); ttl != limit*burst {
+		t.Errorf("TTL should be %s but was %s", limit*burst, ttl)
+	}
+
+	m.FastForward(limit * burst)
+
+	if m.Exists("test:a") {
+		t.Error("key test:a should have expired")
+	}
+}
+
+func TestNewDefaultPrefix(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	defer client.Close()
+
+	if _, _, err := New(client, "").TakeN(ctx, "a", 1, time.Second, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Exists(DefaultPrefix + "a") {
+		t.Errorf("key %sa should exist", DefaultPrefix)
+	}
+}
+
+// TestRedisServer runs against a real Redis server,
+// either given by the REDIS_ADDR environment variable
+// or started locally if redis-server is available.
+// Because the server time can not be controlled, the
+// token regeneration is tested in real time.
+func TestRedisServer(t *testing.T) {
+	const limit = 200 * time.Millisecond
+	const burst = 2
+
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: redisAddr(t)})
+	defer client.Close()
+
+	s := New(client, "ratelimit-test:"+strconv.FormatInt(time.Now().UnixNano(), 36)+":")
+
+	for i := 0; i < burst; i++ {
+		ok, _, err := s.TakeN(ctx, "a", 1, limit, burst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatal("Reservation was not successful")
+		}
+	}
+
+	ok, res, err := s.TakeN(ctx, "a", 1, limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("Reservation was successful even though it should not")
+	}
+	if res.Reset.IsNil() || res.Reset.After(time.Now().Add(limit)) {
+		t.Errorf("res.Reset should be within %s but was %s", limit, res.Reset.Time)
+	}
+
+	time.Sleep(time.Until(res.Reset.Time) + 10*time.Millisecond)
+
+	ok, _, err = s.TakeN(ctx, "a", 1, limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("Reservation was not successful")
+	}
+
+	if err = s.Reset(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := s.Tokens(ctx, "a", limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != burst {
+		t.Errorf("tokens should be %d but was %d", burst, tokens)
+	}
+}
+
+func redisAddr(t *testing.T) string {
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		return addr
+	}
+
+	bin, err := exec.LookPath("redis-server")
+	if err != nil {
+		t.Skip("neither REDIS_ADDR is set nor redis-server is available")
+	}
+
+	const addr = "127.0.0.1:63790"
+	cmd := exec.Command(bin, "--port", "63790", "--bind", "127.0.0.1",
+		"--save", "", "--appendonly", "no")
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+	for i := 0; ; i++ {
+		if err = client.Ping(context.Background()).Err(); err == nil {
+			break
+		}
+		if i == 50 {
+			t.Fatalf("redis-server did not start: %s", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	return addr
+}
@@ -0,0 +1,23 @@
+-- Adds ARGV[1] tokens back to the bucket stored
+-- in the hash KEYS[1], capped at the burst rate.
+-- Buckets which do not exist are full already,
+-- so they are not created.
+--
+-- ARGV[1]: amount of tokens to return
+-- ARGV[2]: burst rate
+
+local n = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+if tokens == nil then
+  return 0
+end
+
+tokens = tokens + n
+if tokens > burst then
+  tokens = burst
+end
+
+redis.call('HSET', KEYS[1], 'tokens', string.format('%d', tokens))
+return 1
@@ -0,0 +1,51 @@
+-- Consumes ARGV[1] tokens from the bucket stored
+-- in the hash KEYS[1]. When ARGV[1] is 0, the
+-- bucket is only inspected and not modified.
+--
+-- ARGV[1]: amount of tokens to consume
+-- ARGV[2]: limit in microseconds
+-- ARGV[3]: burst rate
+-- ARGV[4]: TTL of the key in milliseconds
+--
+-- Returns {ok, tokens, last} where last is the
+-- server time of the last consumption in micro-
+-- seconds or 0 if the bucket was never consumed.
+
+local n = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+local available = burst
+
+if tokens == nil or last == nil then
+  tokens = burst
+  last = 0
+else
+  available = tokens + math.floor((now - last) / limit)
+  if available > burst then
+    available = burst
+  end
+end
+
+if n == 0 then
+  return {1, available, string.format('%d', last)}
+end
+
+if available >= n then
+  tokens = available - n
+  last = now
+  redis.call('HSET', KEYS[1],
+    'tokens', string.format('%d', tokens),
+    'last', string.format('%d', last))
+  redis.call('PEXPIRE', KEYS[1], ttl)
+  return {1, tokens, string.format('%d', last)}
+end
+
+return {0, tokens, string.format('%d', last)}
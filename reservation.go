@@ -1,5 +1,7 @@
 package ratelimit
 
+import "time"
+
 // Reservation contains the pre-defined burst rate
 // of the Limiter, the amount of remaining tickets
 // and the time until a new token will be added to
@@ -12,4 +14,45 @@ type Reservation struct {
 	Burst     int       `json:"burst"`
 	Remaining int       `json:"remaining"`
 	Reset     ResetTime `json:"reset"`
+
+	// Path reports how the reservation has been
+	// decided by a FailoverStore. It is empty for
+	// all other limiters and stores.
+	Path Path `json:"path,omitempty"`
+}
+
+// Path identifies how a FailoverStore
+// decided a reservation.
+type Path string
+
+const (
+	// PathStore is set when the reservation has
+	// been decided by the wrapped store.
+	PathStore Path = "store"
+	// PathFailOpen is set when the wrapped store
+	// failed and the reservation was allowed.
+	PathFailOpen Path = "fail-open"
+	// PathFailClosed is set when the wrapped store
+	// failed and the reservation was denied.
+	PathFailClosed Path = "fail-closed"
+	// PathLocal is set when the wrapped store failed
+	// and the reservation has been decided by the
+	// local fallback store.
+	PathLocal Path = "local"
+)
+
+// NewReservation returns a new Reservation with
+// the given burst rate b, the amount of remaining
+// tokens r and the reset time. If reset is equal
+// to time.Time{}, Reset.IsNil will return true.
+//
+// This is mainly useful for Store implementations
+// which need to construct Reservations outside of
+// this package.
+func NewReservation(b, r int, reset time.Time) Reservation {
+	return Reservation{
+		Burst:     b,
+		Remaining: r,
+		Reset:     NewResetTime(reset),
+	}
 }
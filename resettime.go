@@ -14,6 +14,16 @@ type ResetTime struct {
 	isNil bool
 }
 
+// NewResetTime returns a new ResetTime
+// wrapping t. If t is equal to time.Time{},
+// IsNil will return true.
+func NewResetTime(t time.Time) ResetTime {
+	return ResetTime{
+		Time:  t,
+		isNil: t.IsZero(),
+	}
+}
+
 // IsNil returns the boolean value if
 // the inner Time value is equal
 // an empty time object (time.Time{}).
@@ -0,0 +1,117 @@
+package sqllimit
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Class is the class of a statement.
+type Class int
+
+const (
+	// Read statements only read data,
+	// like SELECT.
+	Read Class = iota
+	// Write statements modify data or the
+	// schema, like INSERT or CREATE.
+	Write
+)
+
+// CostFunc returns the amount of tokens the
+// given query or statement costs. If the
+// cost is below 1, no tokens are taken.
+type CostFunc func(query string) int
+
+// ClassCost returns a CostFunc which charges
+// read tokens for read statements and write
+// tokens for all other statements.
+func ClassCost(read, write int) CostFunc {
+	return func(query string) int {
+		if Classify(query) == Read {
+			return read
+		}
+		return write
+	}
+}
+
+var readKeywords = map[string]bool{
+	"SELECT":   true,
+	"SHOW":     true,
+	"EXPLAIN":  true,
+	"DESCRIBE": true,
+	"DESC":     true,
+	"VALUES":   true,
+	"TABLE":    true,
+}
+
+var writeKeywords = map[string]bool{
+	"INSERT": true,
+	"UPDATE": true,
+	"DELETE": true,
+	"MERGE":  true,
+}
+
+// Classify returns the Class of query by its first
+// keyword. Leading comments and parentheses are
+// skipped. Common table expressions (WITH) are
+// classified as Write if they contain an INSERT,
+// UPDATE, DELETE or MERGE. Unknown statements are
+// classified as Write.
+func Classify(query string) Class {
+	words := keywords(query)
+	if len(words) == 0 {
+		return Write
+	}
+
+	if words[0] == "WITH" {
+		for _, w := range words[1:] {
+			if writeKeywords[w] {
+				return Write
+			}
+		}
+		return Read
+	}
+
+	if readKeywords[words[0]] {
+		return Read
+	}
+	return Write
+}
+
+// keywords returns the upper cased words of query
+// outside of comments and quoted strings.
+func keywords(query string) []string {
+	var words []string
+	for len(query) > 0 {
+		switch {
+		case strings.HasPrefix(query, "--"):
+			query = skipPast(query[2:], "\n")
+		case strings.HasPrefix(query, "/*"):
+			query = skipPast(query[2:], "*/")
+		case query[0] == '\'' || query[0] == '"' || query[0] == '`':
+			query = skipPast(query[1:], query[:1])
+		case isWordChar(rune(query[0])):
+			i := strings.IndexFunc(query, func(r rune) bool { return !isWordChar(r) })
+			if i < 0 {
+				i = len(query)
+			}
+			words = append(words, strings.ToUpper(query[:i]))
+			query = query[i:]
+		default:
+			query = query[1:]
+		}
+	}
+	return words
+}
+
+func skipPast(s, sep string) string {
+	i := strings.Index(s, sep)
+	if i < 0 {
+		return ""
+	}
+	return s[i+len(sep):]
+}
+
+func isWordChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,40 @@
+package sqllimit
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		query string
+		class Class
+	}{
+		{"SELECT * FROM t", Read},
+		{"  select 1", Read},
+		{"(SELECT 1) UNION (SELECT 2)", Read},
+		{"-- comment\nSELECT 1", Read},
+		{"/* INSERT */ SHOW TABLES", Read},
+		{"EXPLAIN SELECT 1", Read},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", Read},
+		{"WITH x AS (SELECT 'delete') SELECT * FROM x", Read},
+		{"WITH x AS (DELETE FROM t RETURNING *) SELECT * FROM x", Write},
+		{"INSERT INTO t VALUES (1)", Write},
+		{"update t set n = 1", Write},
+		{"CREATE TABLE t (n INT)", Write},
+		{"", Write},
+	}
+
+	for _, tt := range tests {
+		if c := Classify(tt.query); c != tt.class {
+			t.Errorf("Classify(%q) should return %d but returned %d", tt.query, tt.class, c)
+		}
+	}
+}
+
+func TestClassCost(t *testing.T) {
+	f := ClassCost(1, 10)
+	if n := f("SELECT 1"); n != 1 {
+		t.Errorf("read cost should be 1 but was %d", n)
+	}
+	if n := f("DELETE FROM t"); n != 10 {
+		t.Errorf("write cost should be 10 but was %d", n)
+	}
+}
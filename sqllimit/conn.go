@@ -0,0 +1,266 @@
+package sqllimit
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+)
+
+var (
+	errNamedArgs = errors.New("sqllimit: driver does not support named arguments")
+	errTxOptions = errors.New("sqllimit: driver does not support transaction options")
+)
+
+// conn wraps a driver.Conn and limits its queries
+// and statements. Like all driver.Conn, it is
+// used by one goroutine at a time.
+type conn struct {
+	driver.Conn
+	d *Driver
+
+	// skipped is the query for which tokens have been
+	// taken before the underlying connection returned
+	// driver.ErrSkip. database/sql then prepares the
+	// query, so the statement does not take them again.
+	skipped *string
+}
+
+// validator is driver.Validator, which is only
+// available since Go 1.15.
+type validator interface {
+	IsValid() bool
+}
+
+var (
+	_ validator                 = (*conn)(nil)
+	_ driver.ConnPrepareContext = (*conn)(nil)
+	_ driver.ConnBeginTx        = (*conn)(nil)
+	_ driver.ExecerContext      = (*conn)(nil)
+	_ driver.QueryerContext     = (*conn)(nil)
+	_ driver.Pinger             = (*conn)(nil)
+	_ driver.SessionResetter    = (*conn)(nil)
+	_ driver.NamedValueChecker  = (*conn)(nil)
+)
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var (
+		s   driver.Stmt
+		err error
+	)
+	if pc, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		s, err = pc.PrepareContext(ctx, query)
+	} else {
+		s, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	paid := c.skipped != nil && *c.skipped == query
+	c.skipped = nil
+
+	return &stmt{Stmt: s, conn: c.Conn, d: c.d, query: query, paid: paid}, nil
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if bc, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return bc.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != 0 || opts.ReadOnly {
+		return nil, errTxOptions
+	}
+	return c.Conn.Begin()
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.skipped = nil
+
+	ec, hasCtx := c.Conn.(driver.ExecerContext)
+	e, has := c.Conn.(driver.Execer)
+	if !hasCtx && !has {
+		return nil, driver.ErrSkip
+	}
+
+	if err := c.d.wait(ctx, query); err != nil {
+		return nil, err
+	}
+
+	var (
+		res driver.Result
+		err error
+	)
+	if hasCtx {
+		res, err = ec.ExecContext(ctx, query, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValuesToValues(args); err != nil {
+			return nil, err
+		}
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		res, err = e.Exec(query, values)
+	}
+
+	if err == driver.ErrSkip {
+		c.skipped = &query
+	}
+	return res, err
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.skipped = nil
+
+	qc, hasCtx := c.Conn.(driver.QueryerContext)
+	q, has := c.Conn.(driver.Queryer)
+	if !hasCtx && !has {
+		return nil, driver.ErrSkip
+	}
+
+	if err := c.d.wait(ctx, query); err != nil {
+		return nil, err
+	}
+
+	var (
+		rows driver.Rows
+		err  error
+	)
+	if hasCtx {
+		rows, err = qc.QueryContext(ctx, query, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValuesToValues(args); err != nil {
+			return nil, err
+		}
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		rows, err = q.Query(query, values)
+	}
+
+	if err == driver.ErrSkip {
+		c.skipped = &query
+	}
+	return rows, err
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// IsValid implements driver.Validator, so that
+// database/sql discards the connection when the
+// underlying connection reports to be invalid.
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	c.skipped = nil
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// stmt wraps a driver.Stmt and limits
+// its executions.
+type stmt struct {
+	driver.Stmt
+	conn  driver.Conn
+	d     *Driver
+	query string
+
+	// paid is true if the tokens for the first
+	// execution have already been taken.
+	paid bool
+}
+
+var (
+	_ driver.StmtExecContext  = (*stmt)(nil)
+	_ driver.StmtQueryContext = (*stmt)(nil)
+)
+
+func (s *stmt) wait(ctx context.Context) error {
+	if s.paid {
+		s.paid = false
+		return nil
+	}
+	return s.d.wait(ctx, s.query)
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	if ec, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return ec.ExecContext(ctx, args)
+	}
+
+	values, err := namedValuesToValues(args)
+	if err != nil {
+		return nil, err
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Stmt.Exec(values)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	if qc, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return qc.QueryContext(ctx, args)
+	}
+
+	values, err := namedValuesToValues(args)
+	if err != nil {
+		return nil, err
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Stmt.Query(values)
+}
+
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	if nc, ok := s.conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func namedValuesToValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errNamedArgs
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
@@ -0,0 +1,151 @@
+// Package sqllimit provides a database/sql driver
+// wrapper which limits the rate of queries and
+// statements executed against a database.
+//
+// All connections opened by a wrapped driver share
+// one limiter, so the limit applies to the whole
+// connection pool of a sql.DB. Calls block using
+// the context of the caller until their tokens are
+// available instead of failing.
+package sqllimit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+)
+
+// Limiter is implemented by limiters which
+// are able to block until n tokens are
+// available, like ratelimit.Limiter.
+type Limiter interface {
+	WaitN(ctx context.Context, n int) error
+}
+
+// Driver wraps a driver.Driver and limits the
+// queries and statements of its connections.
+type Driver struct {
+	d       driver.Driver
+	limiter Limiter
+	cost    CostFunc
+}
+
+// NewDriver returns a new Driver wrapping d which
+// limits queries and statements using l. By
+// default, each call costs one token.
+func NewDriver(d driver.Driver, l Limiter) *Driver {
+	return &Driver{
+		d:       d,
+		limiter: l,
+	}
+}
+
+// Register wraps d using NewDriver and registers
+// the wrapper as database/sql driver with the
+// given name, which can then be passed to
+// sql.Open. Like sql.Register, it panics if a
+// driver with the name is already registered.
+func Register(name string, d driver.Driver, l Limiter) *Driver {
+	drv := NewDriver(d, l)
+	sql.Register(name, drv)
+	return drv
+}
+
+// SetCostFunc sets the function which returns the
+// amount of tokens a query or statement costs, for
+// example ClassCost to weight reads and writes
+// differently. It must be set before the driver
+// is used.
+func (d *Driver) SetCostFunc(f CostFunc) {
+	d.cost = f
+}
+
+// Open implements driver.Driver.
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	c, err := d.d.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return d.wrap(c), nil
+}
+
+// OpenConnector implements driver.DriverContext.
+func (d *Driver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.d.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return &Connector{c: c, d: d}, nil
+	}
+	return &Connector{c: dsnConnector{name: name, d: d.d}, d: d}, nil
+}
+
+func (d *Driver) wrap(c driver.Conn) driver.Conn {
+	return &conn{Conn: c, d: d}
+}
+
+// wait blocks until the tokens for
+// query are available.
+func (d *Driver) wait(ctx context.Context, query string) error {
+	n := 1
+	if d.cost != nil {
+		n = d.cost(query)
+	}
+	if n <= 0 {
+		return nil
+	}
+	return d.limiter.WaitN(ctx, n)
+}
+
+// Connector wraps a driver.Connector and limits
+// the queries and statements of its connections.
+// It can be passed to sql.OpenDB.
+type Connector struct {
+	c driver.Connector
+	d *Driver
+}
+
+// NewConnector returns a new Connector wrapping c
+// which limits queries and statements using l. By
+// default, each call costs one token.
+func NewConnector(c driver.Connector, l Limiter) *Connector {
+	return &Connector{
+		c: c,
+		d: NewDriver(c.Driver(), l),
+	}
+}
+
+// SetCostFunc sets the function which returns the
+// amount of tokens a query or statement costs. It
+// must be set before the connector is used.
+func (c *Connector) SetCostFunc(f CostFunc) {
+	c.d.SetCostFunc(f)
+}
+
+// Connect implements driver.Connector.
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.c.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.d.wrap(cn), nil
+}
+
+// Driver implements driver.Connector.
+func (c *Connector) Driver() driver.Driver {
+	return c.d
+}
+
+type dsnConnector struct {
+	name string
+	d    driver.Driver
+}
+
+func (c dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return c.d.Open(c.name)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.d
+}
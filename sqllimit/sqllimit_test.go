@@ -0,0 +1,310 @@
+package sqllimit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+// fakeDriver is a driver.Driver whose connections
+// count the calls reaching the database.
+type fakeDriver struct {
+	calls int32
+	// skipArgs lets queries with arguments return
+	// driver.ErrSkip like some drivers do.
+	skipArgs bool
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) {
+	return &fakeConn{d: d}, nil
+}
+
+type fakeConn struct {
+	d *fakeDriver
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{d: c.d}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, _ string, args []driver.NamedValue) (driver.Result, error) {
+	if c.d.skipArgs && len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	atomic.AddInt32(&c.d.calls, 1)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, _ string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.d.skipArgs && len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	atomic.AddInt32(&c.d.calls, 1)
+	return &fakeRows{}, nil
+}
+
+type fakeStmt struct {
+	d *fakeDriver
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	atomic.AddInt32(&s.d.calls, 1)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	atomic.AddInt32(&s.d.calls, 1)
+	return &fakeRows{}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	done bool
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"n"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(1)
+	return nil
+}
+
+// recordingLimiter records the tokens
+// of each WaitN call.
+type recordingLimiter struct {
+	mu     sync.Mutex
+	tokens []int
+	err    error
+}
+
+func (l *recordingLimiter) WaitN(_ context.Context, n int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = append(l.tokens, n)
+	return l.err
+}
+
+func (l *recordingLimiter) get() []int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]int(nil), l.tokens...)
+}
+
+var driverCount int32
+
+// open registers a new Driver wrapping d
+// and opens a database using it.
+func open(t *testing.T, d driver.Driver, l Limiter, cost CostFunc) *sql.DB {
+	name := "sqllimit-test-" + strconv.Itoa(int(atomic.AddInt32(&driverCount, 1)))
+	drv := Register(name, d, l)
+	drv.SetCostFunc(cost)
+
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func assertTokens(t *testing.T, l *recordingLimiter, exp ...int) {
+	t.Helper()
+	tokens := l.get()
+	if len(tokens) != len(exp) {
+		t.Fatalf("tokens taken were %v (expected %v)", tokens, exp)
+	}
+	for i := range exp {
+		if tokens[i] != exp[i] {
+			t.Fatalf("tokens taken were %v (expected %v)", tokens, exp)
+		}
+	}
+}
+
+func TestDriverCost(t *testing.T) {
+	l := &recordingLimiter{}
+	db := open(t, &fakeDriver{}, l, ClassCost(1, 5))
+
+	if _, err := db.Exec("INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT n FROM t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := db.Prepare("UPDATE t SET n = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := stmt.Exec(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertTokens(t, l, 5, 1, 5, 5)
+}
+
+func TestDriverDefaultCost(t *testing.T) {
+	l := &recordingLimiter{}
+	db := open(t, &fakeDriver{}, l, nil)
+
+	db.Exec("DELETE FROM t")
+	db.Exec("SELECT 1")
+
+	assertTokens(t, l, 1, 1)
+}
+
+func TestDriverSkip(t *testing.T) {
+	l := &recordingLimiter{}
+	d := &fakeDriver{skipArgs: true}
+	db := open(t, d, l, nil)
+
+	// The connection returns driver.ErrSkip and the
+	// query is executed using a prepared statement,
+	// which must not take the tokens again.
+	var n int
+	if err := db.QueryRow("SELECT n FROM t WHERE n = ?", 1).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DELETE FROM t WHERE n = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	assertTokens(t, l, 1, 1)
+	if d.calls != 2 {
+		t.Errorf("database should be called 2 times but was %d times", d.calls)
+	}
+}
+
+func TestDriverError(t *testing.T) {
+	errLimit := errors.New("limit error")
+	l := &recordingLimiter{err: errLimit}
+	d := &fakeDriver{}
+	db := open(t, d, l, nil)
+
+	if _, err := db.Exec("DELETE FROM t"); err != errLimit {
+		t.Errorf("Exec() should return %v but returned %v", errLimit, err)
+	}
+	if d.calls != 0 {
+		t.Errorf("database should not be called but was %d times", d.calls)
+	}
+}
+
+func TestDriverBlocks(t *testing.T) {
+	const limit = 20 * time.Millisecond
+
+	db := open(t, &fakeDriver{}, ratelimit.NewLimiter(limit, 1), nil)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := db.Exec("DELETE FROM t"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 2*limit {
+		t.Errorf("executing should take at least %v but took %v", 2*limit, d)
+	}
+
+	db = open(t, &fakeDriver{}, ratelimit.NewLimiter(time.Hour, 1), nil)
+	db.Exec("DELETE FROM t")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "DELETE FROM t"); err != ratelimit.ErrExceedsDeadline {
+		t.Errorf("ExecContext() should return %v but returned %v", ratelimit.ErrExceedsDeadline, err)
+	}
+}
+
+// invalidConn is a fakeConn which
+// reports to be invalid.
+type invalidConn struct {
+	fakeConn
+}
+
+func (c *invalidConn) IsValid() bool {
+	return false
+}
+
+func TestConnPassThrough(t *testing.T) {
+	c := &conn{Conn: &fakeConn{d: &fakeDriver{}}}
+	if err := c.Ping(context.Background()); err != nil {
+		t.Errorf("Ping() should return %v but returned %v", nil, err)
+	}
+	if !c.IsValid() {
+		t.Error("connection should be valid")
+	}
+
+	c = &conn{Conn: &invalidConn{}}
+	if c.IsValid() {
+		t.Error("connection should be invalid")
+	}
+
+	// The fake connection has no Pinger, so the
+	// database must be reported as reachable.
+	db := open(t, &fakeDriver{}, &recordingLimiter{}, nil)
+	if err := db.Ping(); err != nil {
+		t.Errorf("Ping() should return %v but returned %v", nil, err)
+	}
+}
+
+func TestConnector(t *testing.T) {
+	l := &recordingLimiter{}
+	c := NewConnector(dsnConnector{d: &fakeDriver{}}, l)
+	c.SetCostFunc(ClassCost(0, 2))
+
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	db.Exec("SELECT 1")
+	db.Exec("INSERT INTO t VALUES (1)")
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Exec("UPDATE t SET n = 2")
+	tx.Commit()
+
+	assertTokens(t, l, 2, 2)
+}
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS ratelimit (
+    id         TEXT     NOT NULL PRIMARY KEY,
+    tokens     BIGINT   NOT NULL,
+    last_ns    BIGINT   NOT NULL,
+    expires_ns BIGINT   NOT NULL,
+    granted    SMALLINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ratelimit_expires_ns_idx ON ratelimit (expires_ns);
@@ -0,0 +1,247 @@
+// Package sqlstore provides an implementation of
+// ratelimit.Store which keeps the state of the token
+// buckets in a table of a relational database using
+// database/sql.
+//
+// Each consumption is performed in a single atomic
+// INSERT ... ON CONFLICT DO UPDATE ... RETURNING
+// statement, which is supported by PostgreSQL and
+// SQLite (3.35.0 and later). The current time is
+// taken from the process and not from the database.
+//
+// The table must be created before the store can
+// be used, either by calling Migrate or by applying
+// the statements returned by Schema using your own
+// migration tooling. schema.sql contains the schema
+// for the default table name.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+// DefaultTable is the name of the table which
+// is used when no table name was passed to New.
+const DefaultTable = "ratelimit"
+
+// Schema returns the statements which create
+// the table with the given name and its index.
+func Schema(table string) string {
+	return strings.Join(schemaStatements(table), ";\n\n") + ";\n"
+}
+
+func schemaStatements(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    id         TEXT     NOT NULL PRIMARY KEY,
+    tokens     BIGINT   NOT NULL,
+    last_ns    BIGINT   NOT NULL,
+    expires_ns BIGINT   NOT NULL,
+    granted    SMALLINT NOT NULL
+)`, table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %[1]s_expires_ns_idx ON %[1]s (expires_ns)`, table),
+	}
+}
+
+// takeQuery consumes tokens from a bucket, creating
+// it if it does not exist. The granted column holds
+// the result of the last consumption, so it can be
+// returned from the same statement.
+//
+// $1: id, $2: n, $3: now, $4: limit, $5: burst, $6: ttl
+// (all times in nanoseconds)
+const takeQuery = `
+INSERT INTO %[1]s AS t (id, tokens, last_ns, expires_ns, granted)
+VALUES (
+    $1,
+    CASE WHEN CAST($2 AS BIGINT) <= CAST($5 AS BIGINT) THEN CAST($5 AS BIGINT) - CAST($2 AS BIGINT) ELSE CAST($5 AS BIGINT) END,
+    CASE WHEN CAST($2 AS BIGINT) <= CAST($5 AS BIGINT) THEN CAST($3 AS BIGINT) ELSE 0 END,
+    CASE WHEN CAST($2 AS BIGINT) <= CAST($5 AS BIGINT) THEN CAST($3 AS BIGINT) + CAST($6 AS BIGINT) ELSE 0 END,
+    CASE WHEN CAST($2 AS BIGINT) <= CAST($5 AS BIGINT) THEN 1 ELSE 0 END
+)
+ON CONFLICT (id) DO UPDATE SET
+    tokens     = CASE WHEN %[2]s >= CAST($2 AS BIGINT) THEN %[2]s - CAST($2 AS BIGINT) ELSE t.tokens END,
+    last_ns    = CASE WHEN %[2]s >= CAST($2 AS BIGINT) THEN CAST($3 AS BIGINT) ELSE t.last_ns END,
+    expires_ns = CASE WHEN %[2]s >= CAST($2 AS BIGINT) THEN CAST($3 AS BIGINT) + CAST($6 AS BIGINT) ELSE t.expires_ns END,
+    granted    = CASE WHEN %[2]s >= CAST($2 AS BIGINT) THEN 1 ELSE 0 END
+RETURNING granted, tokens, last_ns`
+
+// availableExpr calculates the available tokens
+// of the existing row, capped at the burst rate.
+const availableExpr = `(CASE
+        WHEN t.tokens + (CAST($3 AS BIGINT) - t.last_ns) / CAST($4 AS BIGINT) > CAST($5 AS BIGINT) THEN CAST($5 AS BIGINT)
+        ELSE t.tokens + (CAST($3 AS BIGINT) - t.last_ns) / CAST($4 AS BIGINT)
+    END)`
+
+// returnQuery adds tokens back to an existing
+// bucket, capped at the burst rate.
+//
+// $1: id, $2: n, $3: burst
+const returnQuery = `
+UPDATE %s SET tokens = CASE
+    WHEN tokens + CAST($2 AS BIGINT) > CAST($3 AS BIGINT) THEN CAST($3 AS BIGINT)
+    ELSE tokens + CAST($2 AS BIGINT)
+END
+WHERE id = $1`
+
+// Store implements ratelimit.Store using
+// a database/sql database.
+type Store struct {
+	db  *sql.DB
+	now ratelimit.TimeSource
+
+	table        string
+	takeQuery    string
+	tokensQuery  string
+	resetQuery   string
+	returnQuery  string
+	compactQuery string
+}
+
+var _ ratelimit.Returner = (*Store)(nil)
+
+// NewWithTimeSource returns a new instance of Store
+// using the given TimeSource, database and table name.
+// If table is empty, DefaultTable is used.
+//
+// The table name is inserted into the queries as is,
+// so it must never be derived from user input.
+func NewWithTimeSource(timeSource ratelimit.TimeSource, db *sql.DB, table string) *Store {
+	if table == "" {
+		table = DefaultTable
+	}
+
+	return &Store{
+		db:           db,
+		now:          timeSource,
+		table:        table,
+		takeQuery:    fmt.Sprintf(takeQuery, table, availableExpr),
+		tokensQuery:  fmt.Sprintf(`SELECT tokens, last_ns FROM %s WHERE id = $1`, table),
+		resetQuery:   fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, table),
+		returnQuery:  fmt.Sprintf(returnQuery, table),
+		compactQuery: fmt.Sprintf(`DELETE FROM %s WHERE expires_ns <= $1`, table),
+	}
+}
+
+// New returns a new instance of Store using
+// the given database and table name. If table
+// is empty, DefaultTable is used.
+func New(db *sql.DB, table string) *Store {
+	return NewWithTimeSource(time.Now, db, table)
+}
+
+// Migrate creates the table and its
+// index if they do not exist.
+func (s *Store) Migrate(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range schemaStatements(s.table) {
+		if _, err = tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// TakeN implements ratelimit.Store#TakeN.
+func (s *Store) TakeN(ctx context.Context, key string, n int, l time.Duration, b int) (bool, ratelimit.Reservation, error) {
+	if n <= 0 {
+		return true, ratelimit.Reservation{}, nil
+	}
+
+	if b <= 0 || l <= 0 {
+		return false, ratelimit.Reservation{}, nil
+	}
+
+	now := s.now()
+	ttl := l * time.Duration(b)
+
+	var (
+		granted int
+		tokens  int64
+		lastNs  int64
+	)
+
+	err := s.db.QueryRowContext(ctx, s.takeQuery,
+		key, int64(n), now.UnixNano(), int64(l), int64(b), int64(ttl)).
+		Scan(&granted, &tokens, &lastNs)
+	if err != nil {
+		return false, ratelimit.Reservation{}, err
+	}
+
+	ok := granted == 1
+
+	var reset time.Time
+	if !ok || tokens == 0 {
+		reset = lastTime(lastNs).Add(l)
+	}
+
+	return ok, ratelimit.NewReservation(b, int(tokens), reset), nil
+}
+
+// Tokens implements ratelimit.Store#Tokens.
+func (s *Store) Tokens(ctx context.Context, key string, l time.Duration, b int) (int, error) {
+	var tokens, lastNs int64
+
+	err := s.db.QueryRowContext(ctx, s.tokensQuery, key).Scan(&tokens, &lastNs)
+	if err == sql.ErrNoRows {
+		return b, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	t := int(tokens) + int(s.now().Sub(lastTime(lastNs))/l)
+	if t > b {
+		return b, nil
+	}
+
+	return t, nil
+}
+
+// Reset implements ratelimit.Store#Reset.
+func (s *Store) Reset(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.resetQuery, key)
+	return err
+}
+
+// ReturnN implements ratelimit.Returner#ReturnN.
+func (s *Store) ReturnN(ctx context.Context, key string, n int, _ time.Duration, b int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	_, err := s.db.ExecContext(ctx, s.returnQuery, key, int64(n), int64(b))
+	return err
+}
+
+// Compact removes all buckets which are completely
+// refilled and returns the amount of removed buckets.
+func (s *Store) Compact(ctx context.Context) (int64, error) {
+	r, err := s.db.ExecContext(ctx, s.compactQuery, s.now().UnixNano())
+	if err != nil {
+		return 0, err
+	}
+
+	return r.RowsAffected()
+}
+
+// lastTime converts the stored time of the last
+// consumption, where 0 represents time.Time{}.
+func lastTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
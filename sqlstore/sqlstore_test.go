@@ -0,0 +1,142 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/zekroTJA/ratelimit"
+	"github.com/zekroTJA/ratelimit/storetest"
+	_ "modernc.org/sqlite"
+)
+
+func openSQLite(t *testing.T) *sql.DB {
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") +
+		"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func newStore(t *testing.T, db *sql.DB, ts ratelimit.TimeSource, table string) *Store {
+	s := NewWithTimeSource(ts, db, table)
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStoreSQLite(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, ts ratelimit.TimeSource) ratelimit.Store {
+		return newStore(t, openSQLite(t), ts, "")
+	})
+}
+
+// TestStorePostgres runs the test suite against the
+// PostgreSQL database given by the POSTGRES_DSN
+// environment variable using the pgx driver.
+func TestStorePostgres(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_DSN is not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var i int
+	storetest.Run(t, func(t *testing.T, ts ratelimit.TimeSource) ratelimit.Store {
+		i++
+		table := "ratelimit_test_" + strconv.FormatInt(time.Now().UnixNano(), 36) + "_" + strconv.Itoa(i)
+		t.Cleanup(func() { db.Exec(fmt.Sprintf("DROP TABLE %s", table)) })
+		return newStore(t, db, ts, table)
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	s := New(db, "limits")
+
+	for i := 0; i < 2; i++ {
+		if err := s.Migrate(ctx); err != nil {
+			t.Fatalf("Migrate #%d failed: %s", i+1, err)
+		}
+	}
+
+	if _, _, err := s.TakeN(ctx, "a", 1, time.Second, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM limits").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("table should contain %d rows but contained %d", 1, n)
+	}
+}
+
+func TestSchemaFile(t *testing.T) {
+	data, err := os.ReadFile("schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := Schema(DefaultTable); string(data) != exp {
+		t.Errorf("schema.sql is outdated, it should contain:\n%s", exp)
+	}
+}
+
+func TestStoreCompact(t *testing.T) {
+	const limit = 100 * time.Millisecond
+	const burst = 2
+
+	ctx := context.Background()
+	c := storetest.NewClock(time.Now())
+	s := newStore(t, openSQLite(t), c.Now, "")
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, _, err := s.TakeN(ctx, key, 1, limit, burst); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Advance(limit)
+
+	if _, _, err := s.TakeN(ctx, "c", 2, limit, burst); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Advance(limit)
+
+	removed, err := s.Compact(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("removed should be %d but was %d", 2, removed)
+	}
+
+	tokens, err := s.Tokens(ctx, "c", limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != 1 {
+		t.Errorf("tokens of remaining key should be %d but was %d", 1, tokens)
+	}
+}
@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Store abstracts the state of token buckets
+// identified by a key, so that the state of
+// limiters can be kept outside of the current
+// process.
+//
+// All operations of a Store must be atomic per
+// key. The semantics of the token bucket must
+// equal those of Limiter.
+type Store interface {
+	// TakeN tries to consume n tokens from the
+	// bucket identified by key using the limit
+	// l and the burst rate b. The returned
+	// boolean and Reservation must equal the
+	// values returned by Limiter#ReserveN.
+	TakeN(ctx context.Context, key string, n int, l time.Duration, b int) (bool, Reservation, error)
+
+	// Tokens returns the current amount of
+	// available tokens of the bucket identified
+	// by key without consuming any tokens.
+	Tokens(ctx context.Context, key string, l time.Duration, b int) (int, error)
+
+	// Reset sets the bucket identified by
+	// key back to its initial state.
+	Reset(ctx context.Context, key string) error
+}
+
+// A Returner is a Store which is able to give
+// back previously taken tokens to a bucket.
+type Returner interface {
+	Store
+
+	// ReturnN adds n tokens back to the bucket
+	// identified by key. The amount of tokens
+	// in the bucket must never exceed b.
+	ReturnN(ctx context.Context, key string, n int, l time.Duration, b int) error
+}
@@ -0,0 +1,416 @@
+// Package storetest provides a test suite which
+// validates that an implementation of
+// ratelimit.Store matches the semantics of
+// ratelimit.Limiter.
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zekroTJA/ratelimit"
+)
+
+// Factory returns a new and empty instance of the
+// Store under test. The store must take the current
+// time from the given TimeSource.
+type Factory func(t *testing.T, timeSource ratelimit.TimeSource) ratelimit.Store
+
+// Clock is a manually advanced time source
+// which can be passed as TimeSource via its
+// Now method.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a new Clock set to t.
+func NewClock(t time.Time) *Clock {
+	return &Clock{now: t}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// epoch is the start time of the clock used in
+// the tests. It is not time.Time{} because remote
+// stores may not be able to represent it.
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Run runs the full test suite against the
+// stores returned by newStore.
+func Run(t *testing.T, newStore Factory) {
+	t.Run("TakeN", func(t *testing.T) { testTakeN(t, newStore) })
+	t.Run("Invalid", func(t *testing.T) { testInvalid(t, newStore) })
+	t.Run("Tokens", func(t *testing.T) { testTokens(t, newStore) })
+	t.Run("Reset", func(t *testing.T) { testReset(t, newStore) })
+	t.Run("Keys", func(t *testing.T) { testKeys(t, newStore) })
+	t.Run("Limiter", func(t *testing.T) { testLimiter(t, newStore) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, newStore) })
+	t.Run("ReturnN", func(t *testing.T) { testReturnN(t, newStore) })
+}
+
+func testTakeN(t *testing.T, newStore Factory) {
+	const limit = 100 * time.Millisecond
+	const burst = 3
+
+	ctx := context.Background()
+	c := NewClock(epoch)
+	s := newStore(t, c.Now)
+
+	ok, res, err := s.TakeN(ctx, "a", 0, limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || (res != ratelimit.Reservation{}) {
+		t.Errorf("TakeN(0) should return (true, nil) but returned (%t, %+v)", ok, res)
+	}
+
+	ok, res, err = s.TakeN(ctx, "a", 2, limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("returned false but should return true")
+	}
+	if res.Burst != burst {
+		t.Errorf("res.Burst should be %d but was %d", burst, res.Burst)
+	}
+	if res.Remaining != 1 {
+		t.Errorf("res.Remaining should be %d but was %d", 1, res.Remaining)
+	}
+	if !res.Reset.IsNil() {
+		t.Error("res.Reset.IsNil should be true but was false")
+	}
+
+	ok, res, err = s.TakeN(ctx, "a", 1, limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("returned false but should return true")
+	}
+	if res.Remaining != 0 {
+		t.Errorf("res.Remaining should be %d but was %d", 0, res.Remaining)
+	}
+	if exp := c.Now().Add(limit); !res.Reset.Equal(exp) {
+		t.Errorf("res.Reset should be %s but was %s", exp, res.Reset.Time)
+	}
+
+	c.Advance(limit / 2)
+
+	ok, res, err = s.TakeN(ctx, "a", 1, limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("Reservation was successful even though it should not")
+	}
+	if res.Remaining != 0 {
+		t.Errorf("res.Remaining should be %d but was %d", 0, res.Remaining)
+	}
+	if exp := c.Now().Add(limit / 2); !res.Reset.Equal(exp) {
+		t.Errorf("res.Reset should be %s but was %s", exp, res.Reset.Time)
+	}
+
+	c.Advance(limit / 2)
+
+	ok, _, err = s.TakeN(ctx, "a", 1, limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("Reservation was not successful")
+	}
+}
+
+func testInvalid(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+	c := NewClock(epoch)
+	s := newStore(t, c.Now)
+
+	cases := []struct {
+		l time.Duration
+		b int
+	}{
+		{0, 10},
+		{100 * time.Millisecond, 0},
+		{-1, 10},
+		{100 * time.Millisecond, -1},
+	}
+
+	for _, cs := range cases {
+		ok, res, err := s.TakeN(ctx, "a", 1, cs.l, cs.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok || (res != ratelimit.Reservation{}) {
+			t.Errorf("TakeN should return false for l=%s and b=%d", cs.l, cs.b)
+		}
+	}
+}
+
+func testTokens(t *testing.T, newStore Factory) {
+	const limit = 100 * time.Millisecond
+	const burst = 3
+
+	ctx := context.Background()
+	c := NewClock(epoch)
+	s := newStore(t, c.Now)
+
+	tokens, err := s.Tokens(ctx, "a", limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != burst {
+		t.Errorf("tokens should be %d but was %d", burst, tokens)
+	}
+
+	if _, _, err = s.TakeN(ctx, "a", 3, limit, burst); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err = s.Tokens(ctx, "a", limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != 0 {
+		t.Errorf("tokens should be %d but was %d", 0, tokens)
+	}
+
+	c.Advance(2 * limit)
+
+	tokens, err = s.Tokens(ctx, "a", limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != 2 {
+		t.Errorf("tokens should be %d but was %d", 2, tokens)
+	}
+
+	c.Advance(10 * limit)
+
+	tokens, err = s.Tokens(ctx, "a", limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != burst {
+		t.Errorf("tokens should be %d but was %d", burst, tokens)
+	}
+}
+
+func testReset(t *testing.T, newStore Factory) {
+	const limit = time.Hour
+	const burst = 2
+
+	ctx := context.Background()
+	c := NewClock(epoch)
+	s := newStore(t, c.Now)
+
+	if _, _, err := s.TakeN(ctx, "a", 2, limit, burst); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Reset(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, res, err := s.TakeN(ctx, "a", 2, limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("Reservation after reset was not successful")
+	}
+	if res.Remaining != 0 {
+		t.Errorf("res.Remaining should be %d but was %d", 0, res.Remaining)
+	}
+
+	if err = s.Reset(ctx, "unknown"); err != nil {
+		t.Errorf("resetting an unknown key should not fail: %s", err)
+	}
+}
+
+func testKeys(t *testing.T, newStore Factory) {
+	const limit = time.Hour
+	const burst = 1
+
+	ctx := context.Background()
+	c := NewClock(epoch)
+	s := newStore(t, c.Now)
+
+	for _, key := range []string{"a", "b", "c"} {
+		ok, _, err := s.TakeN(ctx, key, 1, limit, burst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Errorf("first reservation of key %q was not successful", key)
+		}
+	}
+
+	ok, _, err := s.TakeN(ctx, "a", 1, limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("Reservation was successful even though it should not")
+	}
+}
+
+// testLimiter runs the same sequence of operations
+// against a ratelimit.Limiter and the store and
+// compares the results.
+func testLimiter(t *testing.T, newStore Factory) {
+	const limit = 100 * time.Millisecond
+	const burst = 4
+
+	ctx := context.Background()
+	c := NewClock(epoch)
+	s := newStore(t, c.Now)
+	l := ratelimit.NewLimiterWithTimeSource(c.Now, limit, burst)
+
+	steps := []struct {
+		advance time.Duration
+		n       int
+	}{
+		{0, 1}, {0, 2}, {10 * time.Millisecond, 2}, {0, 1},
+		{90 * time.Millisecond, 1}, {50 * time.Millisecond, 1},
+		{250 * time.Millisecond, 3}, {0, 1}, {time.Second, 4},
+		{0, 5}, {150 * time.Millisecond, 1}, {0, 1}, {0, 1},
+	}
+
+	for i, st := range steps {
+		c.Advance(st.advance)
+
+		expOk, expRes := l.ReserveN(st.n)
+		ok, res, err := s.TakeN(ctx, "a", st.n, limit, burst)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ok != expOk {
+			t.Errorf("step %d: ok should be %t but was %t", i, expOk, ok)
+		}
+		if res.Burst != expRes.Burst || res.Remaining != expRes.Remaining {
+			t.Errorf("step %d: reservation should be %+v but was %+v", i, expRes, res)
+		}
+		if res.Reset.IsNil() != expRes.Reset.IsNil() || !res.Reset.Equal(expRes.Reset.Time) {
+			t.Errorf("step %d: reset should be %s but was %s",
+				i, expRes.Reset.Format(time.RFC3339Nano, "nil"), res.Reset.Format(time.RFC3339Nano, "nil"))
+		}
+	}
+}
+
+func testConcurrent(t *testing.T, newStore Factory) {
+	const limit = time.Hour
+	const burst = 50
+	const workers = 10
+
+	ctx := context.Background()
+	c := NewClock(epoch)
+	s := newStore(t, c.Now)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+		errs    []error
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2*burst/workers; j++ {
+				ok, _, err := s.TakeN(ctx, "a", 1, limit, burst)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				}
+				if ok {
+					allowed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		t.Fatal(fmt.Errorf("%d operations failed: %w", len(errs), errs[0]))
+	}
+	if allowed != burst {
+		t.Errorf("exactly %d reservations should be allowed but %d were", burst, allowed)
+	}
+}
+
+// testReturnN is skipped if the store does
+// not implement ratelimit.Returner.
+func testReturnN(t *testing.T, newStore Factory) {
+	const limit = time.Hour
+	const burst = 5
+
+	ctx := context.Background()
+	c := NewClock(epoch)
+
+	s, ok := newStore(t, c.Now).(ratelimit.Returner)
+	if !ok {
+		t.Skip("store does not implement ratelimit.Returner")
+	}
+
+	if _, _, err := s.TakeN(ctx, "a", 4, limit, burst); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ReturnN(ctx, "a", 2, limit, burst); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := s.Tokens(ctx, "a", limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != 3 {
+		t.Errorf("tokens should be %d but was %d", 3, tokens)
+	}
+
+	if err = s.ReturnN(ctx, "a", 10, limit, burst); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err = s.Tokens(ctx, "a", limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != burst {
+		t.Errorf("tokens should be capped at %d but were %d", burst, tokens)
+	}
+
+	if err = s.ReturnN(ctx, "unknown", 1, limit, burst); err != nil {
+		t.Errorf("returning tokens to an unknown key should not fail: %s", err)
+	}
+
+	tokens, err = s.Tokens(ctx, "unknown", limit, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != burst {
+		t.Errorf("tokens should be %d but were %d", burst, tokens)
+	}
+}